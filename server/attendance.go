@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	attendanceReportKey = "attendancereport_"
+
+	attendanceReportJobKey   = "attendance_report"
+	attendanceReportInterval = 15 * time.Minute
+
+	// attendanceReportRetention is how long after a meeting ends its report is waited for.
+	attendanceReportRetention = 7 * 24 * time.Hour
+)
+
+// attendanceReport is a meeting attendance report of MS Graph, one per session of a meeting.
+type attendanceReport struct {
+	ID                    string     `json:"id"`
+	TotalParticipantCount int        `json:"totalParticipantCount"`
+	MeetingStartDateTime  *time.Time `json:"meetingStartDateTime"`
+	MeetingEndDateTime    *time.Time `json:"meetingEndDateTime"`
+}
+
+// attendanceRecord is the attendance of one participant in an attendance report.
+type attendanceRecord struct {
+	EmailAddress             string `json:"emailAddress"`
+	TotalAttendanceInSeconds int    `json:"totalAttendanceInSeconds"`
+	Identity                 struct {
+		ID          string `json:"id"`
+		DisplayName string `json:"displayName"`
+	} `json:"identity"`
+	AttendanceIntervals []attendanceInterval `json:"attendanceIntervals"`
+}
+
+// attendanceInterval is a time a participant spent in the meeting.
+type attendanceInterval struct {
+	JoinDateTime  *time.Time `json:"joinDateTime"`
+	LeaveDateTime *time.Time `json:"leaveDateTime"`
+}
+
+// GetLatestAttendanceReport returns the attendance records of the last session of an online
+// meeting of the organizer, or nil if no report is available yet.
+func (c *Client) GetLatestAttendanceReport(organizerRemoteID, meetingRemoteID string) (*attendanceReport, []attendanceRecord, error) {
+	ctx := context.Background()
+	req := c.builder.Users().ID(organizerRemoteID).OnlineMeetings().ID(meetingRemoteID).Request()
+
+	reports := struct {
+		Value []attendanceReport `json:"value"`
+	}{}
+	if err := req.JSONRequest(ctx, http.MethodGet, "/attendanceReports", nil, &reports); err != nil {
+		return nil, nil, errors.Wrap(err, "cannot get attendance reports")
+	}
+
+	var latest *attendanceReport
+	for i, report := range reports.Value {
+		if report.MeetingEndDateTime == nil {
+			continue
+		}
+		if latest == nil || report.MeetingEndDateTime.After(*latest.MeetingEndDateTime) {
+			latest = &reports.Value[i]
+		}
+	}
+	if latest == nil {
+		return nil, nil, nil
+	}
+
+	records := struct {
+		Value []attendanceRecord `json:"value"`
+	}{}
+	if err := req.JSONRequest(ctx, http.MethodGet, "/attendanceReports/"+latest.ID+"/attendanceRecords", nil, &records); err != nil {
+		return nil, nil, errors.Wrap(err, "cannot get attendance records")
+	}
+
+	return latest, records.Value, nil
+}
+
+func getAttendanceReportKey(meetingID int) string {
+	return attendanceReportKey + strconv.Itoa(meetingID)
+}
+
+// scheduleAttendanceReport remembers to post the attendance report of the meeting once it ends.
+func (p *Plugin) scheduleAttendanceReport(meeting *Meeting) {
+	if !p.getConfiguration().PostAttendanceReports || meeting.ID == 0 || meeting.PostID == "" {
+		return
+	}
+
+	expiry := time.Until(time.UnixMilli(meeting.EndAt).Add(attendanceReportRetention))
+	if appErr := p.API.KVSetWithExpiry(getAttendanceReportKey(meeting.ID), []byte{1}, int64(expiry.Seconds())); appErr != nil {
+		p.API.LogWarn("scheduleAttendanceReport, failed to store pending report", "MeetingID", meeting.ID, "error", appErr.Error())
+	}
+}
+
+// rescheduleAttendanceReport moves the expiry of the pending report of a meeting after its end
+// changed.
+func (p *Plugin) rescheduleAttendanceReport(meeting *Meeting) {
+	data, appErr := p.API.KVGet(getAttendanceReportKey(meeting.ID))
+	if appErr != nil {
+		p.API.LogWarn("rescheduleAttendanceReport, failed to get pending report", "MeetingID", meeting.ID, "error", appErr.Error())
+		return
+	}
+	if data == nil {
+		return
+	}
+
+	p.scheduleAttendanceReport(meeting)
+}
+
+// postPendingAttendanceReports posts the attendance reports of the meetings that ended.
+func (p *Plugin) postPendingAttendanceReports() {
+	if !p.getConfiguration().PostAttendanceReports {
+		return
+	}
+
+	meetingIDs, err := p.listMeetingIDs(attendanceReportKey)
+	if err != nil {
+		p.API.LogError("postPendingAttendanceReports, failed to list pending reports", "error", err.Error())
+		return
+	}
+
+	for _, meetingID := range meetingIDs {
+		meeting, err := p.GetMeeting(meetingID)
+		if err != nil {
+			p.API.LogWarn("postPendingAttendanceReports, failed to get meeting", "MeetingID", meetingID, "error", err.Error())
+			continue
+		}
+
+		if time.UnixMilli(meeting.EndAt).After(time.Now()) {
+			continue
+		}
+
+		if err = p.postAttendanceReport(meeting); err != nil {
+			p.API.LogWarn("postPendingAttendanceReports, failed to post report", "MeetingID", meetingID, "error", err.Error())
+		}
+	}
+}
+
+// postAttendanceReport posts the attendance report of the meeting in the thread of its meeting
+// post, if the report is available and wasn't posted yet.
+func (p *Plugin) postAttendanceReport(meeting *Meeting) error {
+	key := getAttendanceReportKey(meeting.ID)
+	pending, appErr := p.API.KVGet(key)
+	if appErr != nil {
+		return appErr
+	}
+	if pending == nil {
+		return nil
+	}
+
+	client, err := p.getMeetingOrganizerClient(meeting)
+	if err != nil {
+		return err
+	}
+
+	report, records, err := client.GetLatestAttendanceReport(meeting.OrganizerRemoteID, meeting.RemoteID)
+	if err != nil {
+		return err
+	}
+	if report == nil {
+		return nil
+	}
+
+	// Claim the report so it is posted once, even when the meeting is ended while the job runs.
+	deleted, appErr := p.API.KVCompareAndDelete(key, pending)
+	if appErr != nil {
+		return appErr
+	}
+	if !deleted {
+		return nil
+	}
+
+	post := p.newMeetingReply(meeting, formatAttendanceReport(report, records, p.getAttendeeName, p.getUserLocation(meeting.OrganizerID)))
+	if _, appErr = p.API.CreatePost(post); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+// getAttendeeName mentions attendees who connected their Microsoft account, and names the others
+// as Microsoft knows them.
+func (p *Plugin) getAttendeeName(record attendanceRecord) string {
+	if record.Identity.ID != "" {
+		if userInfo, err := p.GetUserInfoByRemoteID(record.Identity.ID); err == nil {
+			if user, appErr := p.API.GetUser(userInfo.UserID); appErr == nil {
+				return "@" + user.Username
+			}
+		}
+	}
+
+	if record.Identity.DisplayName != "" {
+		return record.Identity.DisplayName
+	}
+	return record.EmailAddress
+}
+
+func formatAttendanceReport(report *attendanceReport, records []attendanceRecord, nameOf func(attendanceRecord) string, location *time.Location) string {
+	lines := []string{
+		"#### Attendance report",
+		fmt.Sprintf("%d participants attended the meeting.", report.TotalParticipantCount),
+		"",
+		"| Attendee | Joined | Left | Duration |",
+		"|:--|:--|:--|:--|",
+	}
+
+	for _, record := range records {
+		joined, left := "", ""
+		for _, interval := range record.AttendanceIntervals {
+			if interval.JoinDateTime != nil && joined == "" {
+				joined = formatMeetingTime(*interval.JoinDateTime, location)
+			}
+			if interval.LeaveDateTime != nil {
+				left = formatMeetingTime(*interval.LeaveDateTime, location)
+			}
+		}
+
+		lines = append(lines, fmt.Sprintf("| %s | %s | %s | %s |",
+			nameOf(record), joined, left, formatAttendanceDuration(record.TotalAttendanceInSeconds)))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// formatAttendanceDuration writes a duration such as 1h 5m, or 45s when under a minute.
+func formatAttendanceDuration(seconds int) string {
+	if seconds < 60 {
+		return fmt.Sprintf("%ds", seconds)
+	}
+
+	hours, minutes := seconds/3600, seconds%3600/60
+	if hours == 0 {
+		return fmt.Sprintf("%dm", minutes)
+	}
+	return fmt.Sprintf("%dh %dm", hours, minutes)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatAttendanceReport(t *testing.T) {
+	joined := time.Date(2024, time.March, 4, 10, 2, 0, 0, time.UTC)
+	left := time.Date(2024, time.March, 4, 10, 45, 0, 0, time.UTC)
+
+	alice := attendanceRecord{TotalAttendanceInSeconds: 2580}
+	alice.Identity.ID = "alice-remote"
+	alice.AttendanceIntervals = []attendanceInterval{{JoinDateTime: &joined, LeaveDateTime: &left}}
+	guest := attendanceRecord{EmailAddress: "guest@example.com", TotalAttendanceInSeconds: 30}
+
+	nameOf := func(record attendanceRecord) string {
+		if record.Identity.ID == "alice-remote" {
+			return "@alice"
+		}
+		return record.EmailAddress
+	}
+
+	paris, err := time.LoadLocation("Europe/Paris")
+	require.NoError(t, err)
+
+	report := formatAttendanceReport(&attendanceReport{TotalParticipantCount: 2}, []attendanceRecord{alice, guest}, nameOf, paris)
+	require.Equal(t, "#### Attendance report\n"+
+		"2 participants attended the meeting.\n"+
+		"\n"+
+		"| Attendee | Joined | Left | Duration |\n"+
+		"|:--|:--|:--|:--|\n"+
+		"| @alice | Mon, Mar 4 2024 at 11:02 CET | Mon, Mar 4 2024 at 11:45 CET | 43m |\n"+
+		"| guest@example.com |  |  | 30s |", report)
+}
+
+func TestFormatAttendanceDuration(t *testing.T) {
+	require.Equal(t, "45s", formatAttendanceDuration(45))
+	require.Equal(t, "5m", formatAttendanceDuration(330))
+	require.Equal(t, "1h 5m", formatAttendanceDuration(3900))
+}
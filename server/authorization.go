@@ -1,15 +1,26 @@
 package main
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net/url"
+	"strings"
 
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
 	msgraph "github.com/yaegashi/msgraph.go/beta"
 	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/microsoft"
+	"golang.org/x/oauth2/clientcredentials"
 )
 
+// multiTenantAuthorities lists the authorities that accept accounts from any tenant rather than
+// a single directory.
+var multiTenantAuthorities = map[string]bool{
+	"common":        true,
+	"organizations": true,
+}
+
 type authError struct {
 	Message string `json:"message"`
 	Err     error  `json:"err"`
@@ -30,12 +41,48 @@ func (p *Plugin) getOauthMessage(channelID string) (string, error) {
 }
 
 func (p *Plugin) authenticateAndFetchUser(userID, channelID string) (*msgraph.User, *authError) {
-	var user *msgraph.User
-	var err error
+	user, _, authErr := p.authenticateUser(userID, channelID)
+	return user, authErr
+}
+
+// authenticateOrganizer checks that meetings can be created on behalf of the user. It returns
+// their account when it was found in the directory with application permissions, and nil when
+// their own connection is used, to pass on to getOrganizerClient rather than looking it up
+// again.
+func (p *Plugin) authenticateOrganizer(userID, channelID string) (*msgraph.User, *authError) {
+	user, inDirectory, authErr := p.authenticateUser(userID, channelID)
+	if authErr != nil || !inDirectory {
+		return nil, authErr
+	}
+	return user, nil
+}
+
+// authenticateUser returns the Microsoft account of the user, reporting whether it was found in
+// the directory with application permissions rather than through their own connection.
+func (p *Plugin) authenticateUser(userID, channelID string) (*msgraph.User, bool, *authError) {
+	if p.getConfiguration().useApplicationPermissions() {
+		mmUser, appErr := p.API.GetUser(userID)
+		if appErr != nil {
+			return nil, false, &authError{Message: "Cannot get user.", Err: appErr}
+		}
+
+		user, err := p.lookupRemoteUser(mmUser)
+		if err == nil {
+			return user, true, nil
+		}
+		p.API.LogWarn("authenticateUser, falling back to delegated permissions", "UserID", userID, "error", err.Error())
+	}
+
+	user, authErr := p.fetchConnectedUser(userID, channelID)
+	return user, false, authErr
+}
 
+// fetchConnectedUser returns the Microsoft account the user connected, checking their stored
+// token still works.
+func (p *Plugin) fetchConnectedUser(userID, channelID string) (*msgraph.User, *authError) {
 	oauthMsg, err := p.getOauthMessage(channelID)
 	if err != nil {
-		p.API.LogError("authenticateAndFetchUser, cannot get oauth message", "error", err.Error())
+		p.API.LogError("fetchConnectedUser, cannot get oauth message", "error", err.Error())
 		return nil, &authError{Message: "Error getting oauth messsage.", Err: err}
 	}
 
@@ -43,7 +90,10 @@ func (p *Plugin) authenticateAndFetchUser(userID, channelID string) (*msgraph.Us
 	if apiErr != nil || userInfo == nil {
 		return nil, &authError{Message: oauthMsg, Err: apiErr}
 	}
-	user, err = p.getUserWithToken(userInfo.OAuthToken)
+	if userInfo.ConnectionBroken {
+		return nil, &authError{Message: oauthMsg, Err: errors.New("connection to Microsoft is broken")}
+	}
+	user, err := p.getUserWithToken(userInfo.OAuthToken)
 	if err != nil {
 		return nil, &authError{Message: oauthMsg, Err: err}
 	}
@@ -51,6 +101,128 @@ func (p *Plugin) authenticateAndFetchUser(userID, channelID string) (*msgraph.Us
 	return user, nil
 }
 
+// isSSOUser reports whether the user signs in to Mattermost with their Office 365 account.
+func isSSOUser(user *model.User) bool {
+	return user.AuthService == model.ServiceOffice365 && user.AuthData != nil && *user.AuthData != ""
+}
+
+// applySSOAccountHints steers an Office 365 SSO user towards the Microsoft account they sign
+// in to Mattermost with, returning the extra authorize URL parameters to use.
+func (p *Plugin) applySSOAccountHints(conf *oauth2.Config, user *model.User) []oauth2.AuthCodeOption {
+	if !p.getConfiguration().AutoLinkSSOAccounts || !isSSOUser(user) {
+		return nil
+	}
+
+	if p.getConfiguration().isMultiTenant() {
+		if directoryID := p.API.GetConfig().Office365Settings.DirectoryId; directoryID != nil && *directoryID != "" {
+			conf.Endpoint.AuthURL = p.getConfiguration().getCloudEnvironment().AzureADEndpoint(*directoryID).AuthURL
+		}
+	}
+
+	return []oauth2.AuthCodeOption{
+		oauth2.SetAuthURLParam("login_hint", user.Email),
+	}
+}
+
+// verifySSOAccount checks that an Office 365 SSO user linked the same Microsoft account they
+// sign in to Mattermost with.
+func (p *Plugin) verifySSOAccount(user *model.User, remoteID string) error {
+	if !p.getConfiguration().AutoLinkSSOAccounts || !isSSOUser(user) {
+		return nil
+	}
+
+	if *user.AuthData != remoteID {
+		return errors.New("the Microsoft account does not match the account used to sign in to Mattermost")
+	}
+
+	return nil
+}
+
+// verifyRemoteAccount checks a Microsoft account against the configured account matching rules
+// before it is linked to the Mattermost user.
+func (p *Plugin) verifyRemoteAccount(user *model.User, remoteUser *msgraph.User) error {
+	config := p.getConfiguration()
+
+	email := strings.ToLower(user.Email)
+	mail := ""
+	if remoteUser.Mail != nil {
+		mail = strings.ToLower(*remoteUser.Mail)
+	}
+	upn := ""
+	if remoteUser.UserPrincipalName != nil {
+		upn = strings.ToLower(*remoteUser.UserPrincipalName)
+	}
+
+	switch config.AccountMatchMode {
+	case accountMatchModeEmail:
+		if email == "" || (mail != email && upn != email) {
+			return errors.New("the Microsoft account email does not match the Mattermost email")
+		}
+
+	case accountMatchModeDomain:
+		for _, domain := range config.getAllowedAccountDomains() {
+			if getEmailDomain(mail) == domain || getEmailDomain(upn) == domain {
+				return nil
+			}
+		}
+		return errors.New("the Microsoft account does not belong to an allowed domain")
+
+	case accountMatchModeUPN:
+		if getEmailDomain(upn) == "" || getEmailDomain(upn) != getEmailDomain(email) {
+			return errors.New("the Microsoft user principal name does not match the Mattermost email domain")
+		}
+	}
+
+	return nil
+}
+
+// getTenantIDFromToken returns the tenant the user signed in to, as reported by the ID token
+// returned alongside the access token. The ID token comes straight from the token endpoint over
+// TLS, so its signature doesn't need to be validated.
+func getTenantIDFromToken(tok *oauth2.Token) string {
+	idToken, ok := tok.Extra("id_token").(string)
+	if !ok {
+		return ""
+	}
+
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+
+	var claims struct {
+		TenantID string `json:"tid"`
+	}
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return ""
+	}
+
+	return claims.TenantID
+}
+
+// verifyTenant checks that a user signing in through a multi-tenant authority belongs to one of
+// the allowed tenants.
+func (p *Plugin) verifyTenant(tenantID string) error {
+	config := p.getConfiguration()
+	allowedTenants := config.getAllowedTenants()
+	if !config.isMultiTenant() || len(allowedTenants) == 0 {
+		return nil
+	}
+
+	for _, allowedTenant := range allowedTenants {
+		if strings.EqualFold(allowedTenant, tenantID) {
+			return nil
+		}
+	}
+
+	return errors.Errorf("tenant %q is not allowed", tenantID)
+}
+
 func (p *Plugin) disconnect(userID string) error {
 	return p.RemoveUser(userID)
 }
@@ -61,6 +233,14 @@ func (p *Plugin) getOAuthConfig() (*oauth2.Config, error) {
 	clientID := config.OAuth2ClientID
 	clientSecret := config.OAuth2ClientSecret
 	clientAuthority := config.OAuth2Authority
+	cloud := config.getCloudEnvironment()
+
+	endpoint := cloud.AzureADEndpoint(clientAuthority)
+	if config.useCertificateCredentials() {
+		// The client assertion added by getOAuthContext replaces the client secret.
+		clientSecret = ""
+		endpoint.AuthStyle = oauth2.AuthStyleInParams
+	}
 
 	pluginOauthURL, err := p.getPluginOauthURL()
 	if err != nil {
@@ -69,16 +249,102 @@ func (p *Plugin) getOAuthConfig() (*oauth2.Config, error) {
 
 	redirectURL := fmt.Sprintf("%s/complete", pluginOauthURL)
 
+	scopes := []string{
+		"offline_access",
+		"openid",
+		cloud.Scope("OnlineMeetings.ReadWrite"),
+		// Invited users who never connected are looked up in the directory.
+		cloud.Scope("User.ReadBasic.All"),
+	}
+	if config.PostAttendanceReports {
+		scopes = append(scopes, cloud.Scope("OnlineMeetingArtifact.Read.All"))
+	}
+	if config.PostTranscriptsAndRecordings {
+		scopes = append(scopes, cloud.Scope("OnlineMeetingTranscript.Read.All"), cloud.Scope("OnlineMeetingRecording.Read.All"))
+	}
+	if config.SyncTeamsPresence {
+		scopes = append(scopes, cloud.Scope("Presence.Read"))
+	}
+	if config.EnableFindTime {
+		scopes = append(scopes, cloud.Scope("Calendars.Read.Shared"))
+	}
+
 	return &oauth2.Config{
 		ClientID:     clientID,
 		ClientSecret: clientSecret,
 		RedirectURL:  redirectURL,
+		Scopes:       scopes,
+		Endpoint:     endpoint,
+	}, nil
+}
+
+// getAppOAuthConfig returns the client credentials configuration used to call MS Graph with
+// application permissions.
+func (p *Plugin) getAppOAuthConfig() *clientcredentials.Config {
+	config := p.getConfiguration()
+	cloud := config.getCloudEnvironment()
+
+	conf := &clientcredentials.Config{
+		ClientID:     config.OAuth2ClientID,
+		ClientSecret: config.OAuth2ClientSecret,
+		TokenURL:     cloud.AzureADEndpoint(config.OAuth2Authority).TokenURL,
 		Scopes: []string{
-			"offline_access",
-			"OnlineMeetings.ReadWrite",
+			cloud.Scope(".default"),
 		},
-		Endpoint: microsoft.AzureADEndpoint(clientAuthority),
-	}, nil
+	}
+	if config.useCertificateCredentials() {
+		conf.ClientSecret = ""
+		conf.AuthStyle = oauth2.AuthStyleInParams
+	}
+
+	return conf
+}
+
+// lookupRemoteUser finds the Microsoft account of a Mattermost user by their email using
+// application permissions.
+func (p *Plugin) lookupRemoteUser(user *model.User) (*msgraph.User, error) {
+	if user.Email == "" {
+		return nil, errors.New("user has no email")
+	}
+
+	return p.getAppClient().GetUserByEmail(user.Email)
+}
+
+// getOrganizerClient returns the meeting organizer info of a Mattermost user together with a
+// client allowed to create meetings on their behalf. With application permissions the user is
+// looked up by email unless remoteUser already is their account in the directory, falling back
+// to their own connection if they cannot be found.
+func (p *Plugin) getOrganizerClient(user *model.User, remoteUser *msgraph.User) (*Client, *UserInfo, error) {
+	if p.getConfiguration().useApplicationPermissions() {
+		var err error
+		if remoteUser == nil {
+			remoteUser, err = p.lookupRemoteUser(user)
+		}
+		if err == nil && remoteUser.ID != nil && remoteUser.UserPrincipalName != nil {
+			userInfo := &UserInfo{
+				UserID:   user.Id,
+				Email:    user.Email,
+				RemoteID: *remoteUser.ID,
+				UPN:      *remoteUser.UserPrincipalName,
+			}
+			return p.getAppClient(), userInfo, nil
+		}
+		if err != nil {
+			p.API.LogWarn("getOrganizerClient, falling back to delegated permissions", "UserID", user.Id, "error", err.Error())
+		}
+	}
+
+	conf, err := p.getOAuthConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	userInfo, err := p.GetUserInfo(user.Id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return p.NewClient(conf, userInfo.OAuthToken), userInfo, nil
 }
 
 func (p *Plugin) getUserWithToken(token *oauth2.Token) (*msgraph.User, error) {
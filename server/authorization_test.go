@@ -1,11 +1,18 @@
 package main
 
 import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"slices"
 	"testing"
 
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	msgraph "github.com/yaegashi/msgraph.go/beta"
+	"golang.org/x/oauth2"
 )
 
 func TestGetOauthMessage(t *testing.T) {
@@ -48,3 +55,373 @@ func TestGetOauthMessage(t *testing.T) {
 		})
 	}
 }
+
+func TestLookupRemoteUser(t *testing.T) {
+	for _, testCase := range []struct {
+		description   string
+		email         string
+		statusCode    int
+		body          string
+		expectRequest bool
+		expectError   bool
+	}{
+		{
+			description:   "found",
+			email:         "alice@example.com",
+			statusCode:    http.StatusOK,
+			body:          `{"value":[{"id":"remote-id","userPrincipalName":"alice@corp.example.com","mail":"alice@example.com"}]}`,
+			expectRequest: true,
+		},
+		{
+			description:   "not found",
+			email:         "alice@example.com",
+			statusCode:    http.StatusOK,
+			body:          `{"value":[]}`,
+			expectRequest: true,
+			expectError:   true,
+		},
+		{
+			description:   "lookup denied",
+			email:         "alice@example.com",
+			statusCode:    http.StatusForbidden,
+			body:          `{"error":{"code":"Authorization_RequestDenied","message":"Insufficient privileges"}}`,
+			expectRequest: true,
+			expectError:   true,
+		},
+		{
+			description: "no email",
+			expectError: true,
+		},
+	} {
+		t.Run(testCase.description, func(t *testing.T) {
+			requested := false
+			mockGraph(t, func(w http.ResponseWriter, r *http.Request) {
+				requested = true
+				require.Equal(t, "/beta/users", r.URL.Path)
+				writeGraphJSON(w, testCase.statusCode, testCase.body)
+			})
+
+			p := &Plugin{}
+			p.setConfiguration(&configuration{OAuth2Authority: "tenant-id", OAuth2ClientID: "client-id", OAuth2ClientSecret: "secret"})
+
+			remoteUser, err := p.lookupRemoteUser(&model.User{Email: testCase.email})
+			require.Equal(t, testCase.expectRequest, requested)
+			if testCase.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, "remote-id", *remoteUser.ID)
+		})
+	}
+}
+
+func newRemoteUser(id, upn string) *msgraph.User {
+	remoteUser := &msgraph.User{UserPrincipalName: &upn}
+	remoteUser.ID = &id
+	return remoteUser
+}
+
+func TestGetOrganizerClient(t *testing.T) {
+	user := &model.User{Id: "user-id", Email: "alice@example.com"}
+	storedInfo := &UserInfo{
+		UserID:     "user-id",
+		Email:      "alice@example.com",
+		RemoteID:   "connected-remote-id",
+		UPN:        "alice@corp.example.com",
+		OAuthToken: &oauth2.Token{AccessToken: "access-token"},
+	}
+
+	for _, testCase := range []struct {
+		description    string
+		mode           string
+		remoteUser     *msgraph.User
+		lookupBody     string
+		connected      bool
+		expectLookup   bool
+		expectFallback bool
+		expectRemoteID string
+		expectError    bool
+	}{
+		{
+			description:    "delegated",
+			mode:           authenticationModeDelegated,
+			connected:      true,
+			expectRemoteID: "connected-remote-id",
+		},
+		{
+			description: "delegated without connection",
+			mode:        authenticationModeDelegated,
+			expectError: true,
+		},
+		{
+			description:    "application",
+			mode:           authenticationModeApplication,
+			lookupBody:     `{"value":[{"id":"remote-id","userPrincipalName":"alice@corp.example.com"}]}`,
+			expectLookup:   true,
+			expectRemoteID: "remote-id",
+		},
+		{
+			description:    "application with the account already found",
+			mode:           authenticationModeApplication,
+			remoteUser:     newRemoteUser("found-remote-id", "alice@corp.example.com"),
+			expectRemoteID: "found-remote-id",
+		},
+		{
+			description:    "application falls back to the connection",
+			mode:           authenticationModeApplication,
+			lookupBody:     `{"value":[]}`,
+			connected:      true,
+			expectLookup:   true,
+			expectFallback: true,
+			expectRemoteID: "connected-remote-id",
+		},
+		{
+			description:    "application without lookup nor connection",
+			mode:           authenticationModeApplication,
+			lookupBody:     `{"value":[]}`,
+			expectLookup:   true,
+			expectFallback: true,
+			expectError:    true,
+		},
+	} {
+		t.Run(testCase.description, func(t *testing.T) {
+			looked := false
+			mockGraph(t, func(w http.ResponseWriter, r *http.Request) {
+				looked = true
+				writeGraphJSON(w, http.StatusOK, testCase.lookupBody)
+			})
+
+			p := &Plugin{}
+			p.setConfiguration(&configuration{
+				OAuth2Authority:    "tenant-id",
+				OAuth2ClientID:     "client-id",
+				OAuth2ClientSecret: "secret",
+				AuthenticationMode: testCase.mode,
+			})
+			api := &plugintest.API{}
+			api.On("GetConfig").Return(&model.Config{
+				ServiceSettings: model.ServiceSettings{
+					SiteURL: model.NewString("https://example.com"),
+				},
+			})
+			if testCase.connected {
+				data, err := storedInfo.EncryptedJSON(nil)
+				require.NoError(t, err)
+				api.On("KVGet", tokenKey+user.Id).Return(data, nil)
+			} else {
+				api.On("KVGet", tokenKey+user.Id).Return(nil, nil)
+			}
+			if testCase.expectFallback {
+				api.On("LogWarn", "getOrganizerClient, falling back to delegated permissions", "UserID", user.Id, "error", mock.AnythingOfType("string")).Return().Once()
+			}
+			p.SetAPI(api)
+
+			client, userInfo, err := p.getOrganizerClient(user, testCase.remoteUser)
+			require.Equal(t, testCase.expectLookup, looked)
+			if testCase.expectError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				require.NotNil(t, client)
+				require.Equal(t, testCase.expectRemoteID, userInfo.RemoteID)
+				require.Equal(t, user.Id, userInfo.UserID)
+			}
+			if testCase.expectFallback {
+				api.AssertCalled(t, "LogWarn", "getOrganizerClient, falling back to delegated permissions", "UserID", user.Id, "error", mock.AnythingOfType("string"))
+			}
+		})
+	}
+}
+
+func TestGetAppClientSharesToken(t *testing.T) {
+	tokenRequests := 0
+	mockTransport(t, roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		w := httptest.NewRecorder()
+		if r.URL.Path == "/tenant-id/oauth2/v2.0/token" {
+			tokenRequests++
+			writeGraphJSON(w, http.StatusOK, `{"access_token":"access-token","token_type":"Bearer","expires_in":3600}`)
+		} else {
+			writeGraphJSON(w, http.StatusOK, `{"value":[{"id":"remote-id","userPrincipalName":"alice@example.com"}]}`)
+		}
+		res := w.Result()
+		res.Request = r
+		return res, nil
+	}))
+
+	p := &Plugin{}
+	p.setConfiguration(&configuration{
+		OAuth2Authority:    "tenant-id",
+		OAuth2ClientID:     "client-id",
+		OAuth2ClientSecret: "secret",
+	})
+
+	for i := 0; i < 3; i++ {
+		_, err := p.getAppClient().GetUserByEmail("alice@example.com")
+		require.NoError(t, err)
+	}
+	require.Equal(t, 1, tokenRequests)
+
+	p.resetAppClient()
+	_, err := p.getAppClient().GetUserByEmail("alice@example.com")
+	require.NoError(t, err)
+	require.Equal(t, 2, tokenRequests)
+}
+
+func TestVerifySSOAccount(t *testing.T) {
+	for _, testCase := range []struct {
+		description string
+		enabled     bool
+		user        *model.User
+		remoteID    string
+		expectError bool
+	}{
+		{
+			description: "matching account",
+			enabled:     true,
+			user:        &model.User{AuthService: model.ServiceOffice365, AuthData: model.NewString("remote-id")},
+			remoteID:    "remote-id",
+		},
+		{
+			description: "mismatched account",
+			enabled:     true,
+			user:        &model.User{AuthService: model.ServiceOffice365, AuthData: model.NewString("remote-id")},
+			remoteID:    "other-id",
+			expectError: true,
+		},
+		{
+			description: "mismatched account with linking disabled",
+			enabled:     false,
+			user:        &model.User{AuthService: model.ServiceOffice365, AuthData: model.NewString("remote-id")},
+			remoteID:    "other-id",
+		},
+		{
+			description: "non SSO user",
+			enabled:     true,
+			user:        &model.User{AuthService: model.UserAuthServiceEmail},
+			remoteID:    "other-id",
+		},
+	} {
+		t.Run(testCase.description, func(t *testing.T) {
+			p := &Plugin{}
+			p.setConfiguration(&configuration{AutoLinkSSOAccounts: testCase.enabled})
+
+			err := p.verifySSOAccount(testCase.user, testCase.remoteID)
+			if testCase.expectError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestVerifyRemoteAccount(t *testing.T) {
+	remoteUser := &msgraph.User{
+		Mail:              model.NewString("Alice@Example.com"),
+		UserPrincipalName: model.NewString("alice@corp.example.com"),
+	}
+
+	for _, testCase := range []struct {
+		description string
+		config      *configuration
+		email       string
+		expectError bool
+	}{
+		{
+			description: "no matching",
+			config:      &configuration{},
+			email:       "someone@else.com",
+		},
+		{
+			description: "email matches mail",
+			config:      &configuration{AccountMatchMode: accountMatchModeEmail},
+			email:       "alice@example.com",
+		},
+		{
+			description: "email does not match",
+			config:      &configuration{AccountMatchMode: accountMatchModeEmail},
+			email:       "bob@example.com",
+			expectError: true,
+		},
+		{
+			description: "domain allowed",
+			config:      &configuration{AccountMatchMode: accountMatchModeDomain, AllowedAccountDomains: "other.com, @Corp.Example.com"},
+			email:       "someone@else.com",
+		},
+		{
+			description: "domain not allowed",
+			config:      &configuration{AccountMatchMode: accountMatchModeDomain, AllowedAccountDomains: "other.com"},
+			email:       "alice@example.com",
+			expectError: true,
+		},
+		{
+			description: "UPN suffix matches",
+			config:      &configuration{AccountMatchMode: accountMatchModeUPN},
+			email:       "alice.smith@corp.example.com",
+		},
+		{
+			description: "UPN suffix does not match",
+			config:      &configuration{AccountMatchMode: accountMatchModeUPN},
+			email:       "alice@example.com",
+			expectError: true,
+		},
+	} {
+		t.Run(testCase.description, func(t *testing.T) {
+			p := &Plugin{}
+			p.setConfiguration(testCase.config)
+
+			err := p.verifyRemoteAccount(&model.User{Email: testCase.email}, remoteUser)
+			if testCase.expectError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestGetTenantIDFromToken(t *testing.T) {
+	claims := base64.RawURLEncoding.EncodeToString([]byte(`{"tid":"tenant-id"}`))
+	tok := (&oauth2.Token{}).WithExtra(map[string]interface{}{
+		"id_token": "header." + claims + ".signature",
+	})
+	require.Equal(t, "tenant-id", getTenantIDFromToken(tok))
+
+	require.Equal(t, "", getTenantIDFromToken(&oauth2.Token{}))
+}
+
+func TestVerifyTenant(t *testing.T) {
+	p := &Plugin{}
+	p.setConfiguration(&configuration{OAuth2Authority: "organizations", AllowedTenants: "tenant-a, Tenant-B"})
+	require.NoError(t, p.verifyTenant("tenant-b"))
+	require.Error(t, p.verifyTenant("tenant-c"))
+
+	p.setConfiguration(&configuration{OAuth2Authority: "organizations"})
+	require.NoError(t, p.verifyTenant("tenant-c"))
+
+	p.setConfiguration(&configuration{OAuth2Authority: "tenant-a", AllowedTenants: "tenant-a"})
+	require.NoError(t, p.verifyTenant("tenant-c"))
+}
+
+func TestGetOAuthConfigCalendarScope(t *testing.T) {
+	for _, enableFindTime := range []bool{false, true} {
+		p := &Plugin{}
+		p.setConfiguration(&configuration{
+			OAuth2Authority: "tenant-id",
+			OAuth2ClientID:  "client-id",
+			EnableFindTime:  enableFindTime,
+		})
+		api := &plugintest.API{}
+		api.On("GetConfig").Return(&model.Config{
+			ServiceSettings: model.ServiceSettings{
+				SiteURL: model.NewString("https://example.com"),
+			},
+		})
+		p.SetAPI(api)
+
+		conf, err := p.getOAuthConfig()
+		require.NoError(t, err)
+		require.Equal(t, enableFindTime, slices.Contains(conf.Scopes, "Calendars.Read.Shared"))
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+	msgraph "github.com/yaegashi/msgraph.go/beta"
+)
+
+const (
+	meetingCardActionPath = "/api/v1/meetings/action"
+
+	// Post action IDs must be alphanumeric.
+	cardActionAddMe  = "addme"
+	cardActionDialIn = "dialin"
+	cardActionExtend = "extend"
+	cardActionEnd    = "end"
+
+	// meetingExtension is how much longer a meeting lasts each time it is extended.
+	meetingExtension = 30 * time.Minute
+)
+
+// getMeetingCardActions returns the actions of the card of a meeting from the registry.
+func (p *Plugin) getMeetingCardActions(meeting *Meeting) []*model.SlackAttachment {
+	pluginURL, err := p.getPluginURL()
+	if err != nil {
+		p.API.LogWarn("getMeetingCardActions, failed to get plugin URL", "error", err.Error())
+		return nil
+	}
+
+	newAction := func(name, action string) *model.PostAction {
+		return &model.PostAction{
+			Id:   action,
+			Name: name,
+			Type: model.PostActionTypeButton,
+			Integration: &model.PostActionIntegration{
+				URL: pluginURL + meetingCardActionPath,
+				Context: map[string]interface{}{
+					"action":     action,
+					"meeting_id": strconv.Itoa(meeting.ID),
+				},
+			},
+		}
+	}
+
+	return []*model.SlackAttachment{{
+		Actions: []*model.PostAction{
+			newAction("Add me", cardActionAddMe),
+			newAction("Dial-in details", cardActionDialIn),
+			newAction(fmt.Sprintf("Extend %d minutes", int(meetingExtension.Minutes())), cardActionExtend),
+			newAction("End meeting", cardActionEnd),
+		},
+	}}
+}
+
+// handleMeetingCardAction answers the buttons of a meeting card.
+func (p *Plugin) handleMeetingCardAction(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		p.API.LogError("handleMeetingCardAction, unauthorized user")
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req model.PostActionIntegrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		p.API.LogError("handleMeetingCardAction, failed to decode payload", "Error", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	meetingID, err := strconv.Atoi(getString("meeting_id", req.Context))
+	if err != nil {
+		http.Error(w, "invalid meeting id", http.StatusBadRequest)
+		return
+	}
+
+	meeting, err := p.GetMeeting(meetingID)
+	if err != nil {
+		p.API.LogError("handleMeetingCardAction, failed to get meeting", "MeetingID", meetingID, "Error", err.Error())
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	if meeting.OrganizerID != userID &&
+		(meeting.Private || !p.API.HasPermissionToChannel(userID, meeting.ChannelID, model.PermissionReadChannel)) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	postID := p.getMeetingCardPostID(userID, meeting, req.PostId)
+
+	var text string
+	switch action := getString("action", req.Context); action {
+	case cardActionAddMe:
+		text, err = p.addMeetingAttendee(userID, meeting, postID)
+	case cardActionDialIn:
+		text, err = p.getMeetingDialIn(meeting)
+	case cardActionExtend:
+		text, err = p.rescheduleMeeting(userID, meeting, postID, false)
+	case cardActionEnd:
+		text, err = p.rescheduleMeeting(userID, meeting, postID, true)
+	default:
+		http.Error(w, "unknown action", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		p.API.LogError("handleMeetingCardAction, failed to run action", "MeetingID", meetingID, "UserID", userID, "Error", err.Error())
+		text = "Something went wrong. Please try again."
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(&model.PostActionIntegrationResponse{EphemeralText: text}); err != nil {
+		p.API.LogWarn("failed to write response", "error", err.Error())
+	}
+}
+
+// getMeetingCardPostID returns the post to update after an action on a meeting card. This is
+// the meeting post, unless the action came from another card of the same meeting the user can
+// see, such as the direct message of a private meeting.
+func (p *Plugin) getMeetingCardPostID(userID string, meeting *Meeting, postID string) string {
+	if postID == "" || postID == meeting.PostID {
+		return meeting.PostID
+	}
+
+	post, appErr := p.API.GetPost(postID)
+	if appErr != nil || getInt("meeting_id", post.Props) != meeting.ID ||
+		!p.API.HasPermissionToChannel(userID, post.ChannelId, model.PermissionReadChannel) {
+		return meeting.PostID
+	}
+	return post.Id
+}
+
+// getMeetingOrganizerClient returns a client acting as the organizer of a meeting.
+func (p *Plugin) getMeetingOrganizerClient(meeting *Meeting) (*Client, error) {
+	if meeting.OrganizerID == "" {
+		return nil, errors.New("meeting has no known organizer")
+	}
+
+	organizer, appErr := p.API.GetUser(meeting.OrganizerID)
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	client, _, err := p.getOrganizerClient(organizer, nil)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// addMeetingAttendee invites the user to the meeting, listing them on the card.
+func (p *Plugin) addMeetingAttendee(userID string, meeting *Meeting, postID string) (string, error) {
+	userInfo, err := p.GetUserInfo(userID)
+	if err != nil {
+		return "Connect your Microsoft account with `/mstmeetings connect` to add yourself to the meeting.", nil
+	}
+
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil {
+		return "", appErr
+	}
+
+	client, err := p.getMeetingOrganizerClient(meeting)
+	if err != nil {
+		return "The organizer of this meeting can't be reached to add you.", nil
+	}
+
+	added, err := client.AddMeetingAttendee(meeting.OrganizerRemoteID, meeting.RemoteID, userInfo)
+	if err != nil {
+		return "", err
+	}
+	if !added {
+		return "You are already invited to this meeting.", nil
+	}
+
+	p.updateMeetingPost(postID, func(post *model.Post) {
+		attendees := getStrings("meeting_attendee_usernames", post.Props)
+		post.AddProp("meeting_attendee_usernames", append(attendees, user.Username))
+	})
+	p.scheduleMeetingStatus(meeting, []string{userID})
+
+	return "You were added to the meeting.", nil
+}
+
+// getMeetingDialIn returns the dial-in details of the meeting.
+func (p *Plugin) getMeetingDialIn(meeting *Meeting) (string, error) {
+	client, err := p.getMeetingOrganizerClient(meeting)
+	if err != nil {
+		return "The dial-in details of this meeting are not available.", nil
+	}
+
+	onlineMeeting, err := client.GetOnlineMeeting(meeting.OrganizerRemoteID, meeting.RemoteID)
+	if err != nil {
+		return "", err
+	}
+
+	return formatDialIn(onlineMeeting.AudioConferencing), nil
+}
+
+func formatDialIn(audio *msgraph.AudioConferencing) string {
+	if audio == nil || (audio.TollNumber == nil && audio.TollFreeNumber == nil) {
+		return "This meeting has no dial-in details."
+	}
+
+	lines := []string{"Dial-in details:"}
+	if audio.TollNumber != nil {
+		lines = append(lines, fmt.Sprintf("* Phone: %s", *audio.TollNumber))
+	}
+	if audio.TollFreeNumber != nil {
+		lines = append(lines, fmt.Sprintf("* Toll free: %s", *audio.TollFreeNumber))
+	}
+	if audio.ConferenceID != nil {
+		lines = append(lines, fmt.Sprintf("* Conference ID: %s#", *audio.ConferenceID))
+	}
+	if audio.DialinURL != nil {
+		lines = append(lines, fmt.Sprintf("* [Find a local number](%s)", *audio.DialinURL))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// rescheduleMeeting lets the organizer end the meeting now, or extend it.
+func (p *Plugin) rescheduleMeeting(userID string, meeting *Meeting, postID string, end bool) (string, error) {
+	now := time.Now()
+	update := meetingUpdate{EndAt: now}
+	if !end {
+		update.EndAt = time.UnixMilli(meeting.EndAt)
+		if update.EndAt.Before(now) {
+			update.EndAt = now
+		}
+		update.EndAt = update.EndAt.Add(meetingExtension)
+	}
+	if time.UnixMilli(meeting.StartAt).After(update.EndAt) {
+		update.StartAt = update.EndAt
+	}
+
+	if err := p.updateMeeting(userID, meeting, postID, update); err != nil {
+		if err == errNotOrganizer {
+			return "Only the organizer can change this meeting.", nil
+		}
+		return "", err
+	}
+
+	if !end {
+		return fmt.Sprintf("The meeting now ends at %s.", formatMeetingTime(update.EndAt, p.getUserLocation(userID))), nil
+	}
+
+	p.markMeetingEnded(meeting, postID)
+	return "The meeting has ended.", nil
+}
+
+// markMeetingEnded shows on the meeting post that the meeting ended.
+func (p *Plugin) markMeetingEnded(meeting *Meeting, postID string) {
+	p.updateMeetingPost(postID, func(post *model.Post) {
+		post.Message = "Meeting ended."
+		post.AddProp("meeting_status", postTypeEnded)
+		post.DelProp("attachments")
+	})
+	p.endMeetingStatus(meeting)
+
+	// The report usually takes a few minutes to be ready, the job posts it otherwise.
+	if err := p.postAttendanceReport(meeting); err != nil {
+		p.API.LogDebug("markMeetingEnded, attendance report not posted", "MeetingID", meeting.ID, "error", err.Error())
+	}
+}
+
+// updateMeetingPost applies a change to a meeting card.
+func (p *Plugin) updateMeetingPost(postID string, update func(post *model.Post)) {
+	post, appErr := p.API.GetPost(postID)
+	if appErr != nil {
+		// Private meetings are shown to their creator in an ephemeral post, which can't be updated.
+		p.API.LogDebug("updateMeetingPost, failed to get post", "PostID", postID, "error", appErr.Error())
+		return
+	}
+
+	update(post)
+	if _, appErr = p.API.UpdatePost(post); appErr != nil {
+		p.API.LogWarn("updateMeetingPost, failed to update post", "PostID", postID, "error", appErr.Error())
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/require"
+	msgraph "github.com/yaegashi/msgraph.go/beta"
+)
+
+func TestFormatDialIn(t *testing.T) {
+	require.Equal(t, "This meeting has no dial-in details.", formatDialIn(nil))
+	require.Equal(t, "This meeting has no dial-in details.", formatDialIn(&msgraph.AudioConferencing{}))
+
+	tollNumber := "+1 555 0100"
+	conferenceID := "123456"
+	dialinURL := "https://dialin.teams.microsoft.com/local"
+	require.Equal(t,
+		"Dial-in details:\n* Phone: +1 555 0100\n* Conference ID: 123456#\n* [Find a local number](https://dialin.teams.microsoft.com/local)",
+		formatDialIn(&msgraph.AudioConferencing{
+			TollNumber:   &tollNumber,
+			ConferenceID: &conferenceID,
+			DialinURL:    &dialinURL,
+		}))
+}
+
+func TestGetMeetingCardPostID(t *testing.T) {
+	meeting := &Meeting{ID: 7, PostID: "meeting-post-id"}
+
+	for _, testCase := range []struct {
+		description string
+		postID      string
+		post        *model.Post
+		canRead     bool
+		expected    string
+	}{
+		{
+			description: "meeting post",
+			postID:      "meeting-post-id",
+			expected:    "meeting-post-id",
+		},
+		{
+			description: "no post",
+			expected:    "meeting-post-id",
+		},
+		{
+			description: "card of the same meeting",
+			postID:      "dm-post-id",
+			post:        &model.Post{Id: "dm-post-id", ChannelId: "dm-id", Props: model.StringInterface{"meeting_id": float64(7)}},
+			canRead:     true,
+			expected:    "dm-post-id",
+		},
+		{
+			description: "card of another meeting",
+			postID:      "other-post-id",
+			post:        &model.Post{Id: "other-post-id", ChannelId: "dm-id", Props: model.StringInterface{"meeting_id": float64(8)}},
+			canRead:     true,
+			expected:    "meeting-post-id",
+		},
+		{
+			description: "post without meeting",
+			postID:      "other-post-id",
+			post:        &model.Post{Id: "other-post-id", ChannelId: "dm-id"},
+			canRead:     true,
+			expected:    "meeting-post-id",
+		},
+		{
+			description: "card in an unreadable channel",
+			postID:      "dm-post-id",
+			post:        &model.Post{Id: "dm-post-id", ChannelId: "dm-id", Props: model.StringInterface{"meeting_id": float64(7)}},
+			expected:    "meeting-post-id",
+		},
+		{
+			description: "missing post",
+			postID:      "missing-post-id",
+			expected:    "meeting-post-id",
+		},
+	} {
+		t.Run(testCase.description, func(t *testing.T) {
+			p := &Plugin{}
+			api := &plugintest.API{}
+			if testCase.post != nil {
+				api.On("GetPost", testCase.postID).Return(testCase.post, nil)
+			} else {
+				api.On("GetPost", testCase.postID).Return(nil, model.NewAppError("GetPost", "not_found", nil, "", http.StatusNotFound))
+			}
+			api.On("HasPermissionToChannel", "user-id", "dm-id", model.PermissionReadChannel).Return(testCase.canRead)
+			p.SetAPI(api)
+
+			require.Equal(t, testCase.expected, p.getMeetingCardPostID("user-id", meeting, testCase.postID))
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1" //nolint:gosec // x5t is defined as the SHA-1 thumbprint of the certificate
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+const (
+	clientAssertionType     = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+	clientAssertionLifetime = 10 * time.Minute
+)
+
+// clientCertificate holds the certificate credentials used to authenticate the plugin's Azure
+// application instead of a client secret.
+type clientCertificate struct {
+	certificate *x509.Certificate
+	privateKey  *rsa.PrivateKey
+}
+
+func parseClientCertificate(certificatePEM, privateKeyPEM string) (*clientCertificate, error) {
+	keyPair, err := tls.X509KeyPair([]byte(certificatePEM), []byte(privateKeyPEM))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse certificate and private key")
+	}
+
+	privateKey, ok := keyPair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key must be an RSA key")
+	}
+
+	certificate, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse certificate")
+	}
+
+	return &clientCertificate{
+		certificate: certificate,
+		privateKey:  privateKey,
+	}, nil
+}
+
+// newAssertion creates a signed JWT client assertion for the given token endpoint.
+func (c *clientCertificate) newAssertion(clientID, tokenURL string) (string, error) {
+	thumbprint := sha1.Sum(c.certificate.Raw) //nolint:gosec
+	header, err := json.Marshal(map[string]string{
+		"alg": "RS256",
+		"typ": "JWT",
+		"x5t": base64.RawURLEncoding.EncodeToString(thumbprint[:]),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": tokenURL,
+		"iss": clientID,
+		"sub": clientID,
+		"jti": model.NewId(),
+		"nbf": now.Unix(),
+		"iat": now.Unix(),
+		"exp": now.Add(clientAssertionLifetime).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hashed := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, c.privateKey, crypto.SHA256, hashed[:])
+	if err != nil {
+		return "", errors.Wrap(err, "failed to sign client assertion")
+	}
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// clientAssertionTransport adds a freshly signed client assertion to every request sent to the
+// token endpoint, covering both the authorization code exchange and token refreshes.
+type clientAssertionTransport struct {
+	certificate *clientCertificate
+	clientID    string
+	tokenURL    string
+	base        http.RoundTripper
+}
+
+func (t *clientAssertionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPost || req.Body == nil || req.URL.String() != t.tokenURL {
+		return t.base.RoundTrip(req)
+	}
+
+	body, err := io.ReadAll(req.Body)
+	_ = req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse token request")
+	}
+
+	assertion, err := t.certificate.newAssertion(t.clientID, t.tokenURL)
+	if err != nil {
+		return nil, err
+	}
+	values.Set("client_assertion_type", clientAssertionType)
+	values.Set("client_assertion", assertion)
+
+	encoded := values.Encode()
+	signedReq := req.Clone(req.Context())
+	signedReq.Body = io.NopCloser(strings.NewReader(encoded))
+	signedReq.ContentLength = int64(len(encoded))
+	signedReq.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader([]byte(encoded))), nil
+	}
+
+	return t.base.RoundTrip(signedReq)
+}
+
+// getOAuthContext returns the context to use for OAuth2 token requests. With certificate
+// credentials configured, token requests are signed with a client assertion.
+func (p *Plugin) getOAuthContext(tokenURL string) context.Context {
+	ctx := context.Background()
+
+	config := p.getConfiguration()
+	if config.clientCertificate == nil {
+		// An invalid certificate fails the configuration check, so requests don't get here.
+		return ctx
+	}
+
+	httpClient := &http.Client{
+		Transport: &clientAssertionTransport{
+			certificate: config.clientCertificate,
+			clientID:    config.OAuth2ClientID,
+			tokenURL:    tokenURL,
+			base:        http.DefaultTransport,
+		},
+	}
+	return context.WithValue(ctx, oauth2.HTTPClient, httpClient)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+// newTestCertificate returns a self-signed certificate and its private key, PEM encoded.
+func newTestCertificate(t *testing.T) (string, string, *rsa.PrivateKey) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "msteamsmeetings"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	require.NoError(t, err)
+
+	certificatePEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	privateKeyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)}))
+	return certificatePEM, privateKeyPEM, privateKey
+}
+
+func TestClientCertificateAssertion(t *testing.T) {
+	certificatePEM, privateKeyPEM, privateKey := newTestCertificate(t)
+
+	certificate, err := parseClientCertificate(certificatePEM, privateKeyPEM)
+	require.NoError(t, err)
+
+	tokenURL := "https://login.microsoftonline.com/tenant/oauth2/v2.0/token"
+	assertion, err := certificate.newAssertion("client-id", tokenURL)
+	require.NoError(t, err)
+
+	parts := strings.Split(assertion, ".")
+	require.Len(t, parts, 3)
+
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	require.NoError(t, err)
+	claims := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(claimsJSON, &claims))
+	require.Equal(t, tokenURL, claims["aud"])
+	require.Equal(t, "client-id", claims["iss"])
+	require.Equal(t, "client-id", claims["sub"])
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	require.NoError(t, err)
+	hashed := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	require.NoError(t, rsa.VerifyPKCS1v15(&privateKey.PublicKey, crypto.SHA256, hashed[:], signature))
+
+	_, err = parseClientCertificate(certificatePEM, "not a key")
+	require.Error(t, err)
+}
+
+func TestGetOAuthContext(t *testing.T) {
+	certificatePEM, privateKeyPEM, _ := newTestCertificate(t)
+	certificate, err := parseClientCertificate(certificatePEM, privateKeyPEM)
+	require.NoError(t, err)
+
+	tokenURL := "https://login.microsoftonline.com/tenant/oauth2/v2.0/token"
+
+	p := &Plugin{}
+	p.setConfiguration(&configuration{OAuth2ClientSecret: "secret"})
+	require.Nil(t, p.getOAuthContext(tokenURL).Value(oauth2.HTTPClient))
+
+	p.setConfiguration(&configuration{
+		OAuth2ClientID:    "client-id",
+		OAuth2Certificate: certificatePEM,
+		OAuth2PrivateKey:  privateKeyPEM,
+		clientCertificate: certificate,
+	})
+	httpClient, ok := p.getOAuthContext(tokenURL).Value(oauth2.HTTPClient).(*http.Client)
+	require.True(t, ok)
+	transport, ok := httpClient.Transport.(*clientAssertionTransport)
+	require.True(t, ok)
+	require.Same(t, certificate, transport.certificate)
+	require.Equal(t, "client-id", transport.clientID)
+	require.Equal(t, tokenURL, transport.tokenURL)
+}
@@ -5,6 +5,7 @@ package main
 
 import (
 	"context"
+	"net/http"
 
 	"github.com/mattermost/mattermost/server/public/plugin"
 	msgraph "github.com/yaegashi/msgraph.go/beta"
@@ -19,10 +20,53 @@ type Client struct {
 
 // NewClient returns a new MSGraph API client.
 func (p *Plugin) NewClient(conf *oauth2.Config, token *oauth2.Token) *Client {
-	ctx := context.Background()
+	ctx := p.getOAuthContext(conf.Endpoint.TokenURL)
 	httpClient := conf.Client(ctx, token)
+	return p.newClient(httpClient)
+}
+
+// NewUserClient returns a new MSGraph API client authenticated as a connected user, storing
+// their token again whenever it is refreshed. data is the stored user info the token was read
+// from, see getStoredUserInfo.
+func (p *Plugin) NewUserClient(conf *oauth2.Config, info *UserInfo, data []byte) *Client {
+	ctx := p.getOAuthContext(conf.Endpoint.TokenURL)
+	source := &storingTokenSource{
+		p:      p,
+		source: conf.TokenSource(ctx, info.OAuthToken),
+		info:   info,
+		data:   data,
+	}
+	return p.newClient(oauth2.NewClient(ctx, source))
+}
+
+// getAppClient returns a MSGraph API client authenticated as the application itself. The
+// application token is shared by all the clients until it expires or the configuration changes.
+func (p *Plugin) getAppClient() *Client {
+	p.appTokenSourceLock.Lock()
+	if p.appTokenSource == nil {
+		conf := p.getAppOAuthConfig()
+		p.appTokenSource = conf.TokenSource(p.getOAuthContext(conf.TokenURL))
+	}
+	source := p.appTokenSource
+	p.appTokenSourceLock.Unlock()
+
+	return p.newClient(oauth2.NewClient(context.Background(), source))
+}
+
+// resetAppClient drops the shared application token, for the next clients to get a new one.
+func (p *Plugin) resetAppClient() {
+	p.appTokenSourceLock.Lock()
+	defer p.appTokenSourceLock.Unlock()
+	p.appTokenSource = nil
+}
+
+// newClient returns a MSGraph API client pointed at the Graph deployment of the configured
+// cloud environment.
+func (p *Plugin) newClient(httpClient *http.Client) *Client {
+	builder := msgraph.NewClient(httpClient)
+	builder.SetURL(p.getConfiguration().getCloudEnvironment().GraphURL())
 	return &Client{
-		builder: msgraph.NewClient(httpClient),
+		builder: builder,
 		api:     p.API,
 	}
 }
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// graphTransport serves the requests of the MS Graph clients from a handler, answering token
+// requests itself.
+type graphTransport struct {
+	handler http.Handler
+}
+
+func (t *graphTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	w := httptest.NewRecorder()
+	if strings.HasSuffix(r.URL.Path, "/oauth2/v2.0/token") {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.WriteString(`{"access_token":"access-token","token_type":"Bearer","expires_in":3600}`)
+	} else {
+		t.handler.ServeHTTP(w, r)
+	}
+
+	res := w.Result()
+	res.Request = r
+	return res, nil
+}
+
+// roundTripFunc adapts a function to a http.RoundTripper.
+type roundTripFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// mockTransport routes the requests the plugin makes to Microsoft to the transport for the
+// duration of the test.
+func mockTransport(t *testing.T, transport http.RoundTripper) {
+	prev := http.DefaultTransport
+	http.DefaultTransport = transport
+	t.Cleanup(func() {
+		http.DefaultTransport = prev
+	})
+}
+
+// mockGraph routes the requests the plugin makes to Microsoft to the handler for the duration
+// of the test.
+func mockGraph(t *testing.T, handler http.HandlerFunc) {
+	mockTransport(t, &graphTransport{handler: handler})
+}
+
+// writeGraphJSON writes a MS Graph JSON response.
+func writeGraphJSON(w http.ResponseWriter, statusCode int, body string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_, _ = w.Write([]byte(body))
+}
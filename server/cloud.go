@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	cloudEnvironmentGlobal = "global"
+	cloudEnvironmentGCCH   = "usgovhigh"
+	cloudEnvironmentDoD    = "usgovdod"
+	cloudEnvironmentChina  = "china"
+)
+
+// cloudEnvironment describes the Microsoft Entra ID authority and MS Graph hosts of a national
+// cloud deployment.
+type cloudEnvironment struct {
+	AuthorityHost string
+	GraphHost     string
+}
+
+var cloudEnvironments = map[string]cloudEnvironment{
+	cloudEnvironmentGlobal: {
+		AuthorityHost: "https://login.microsoftonline.com",
+		GraphHost:     "https://graph.microsoft.com",
+	},
+	cloudEnvironmentGCCH: {
+		AuthorityHost: "https://login.microsoftonline.us",
+		GraphHost:     "https://graph.microsoft.us",
+	},
+	cloudEnvironmentDoD: {
+		AuthorityHost: "https://login.microsoftonline.us",
+		GraphHost:     "https://dod-graph.microsoft.us",
+	},
+	cloudEnvironmentChina: {
+		AuthorityHost: "https://login.chinacloudapi.cn",
+		GraphHost:     "https://microsoftgraph.chinacloudapi.cn",
+	},
+}
+
+// getCloudEnvironment returns the configured cloud environment, defaulting to the global
+// Microsoft cloud.
+func (c *configuration) getCloudEnvironment() cloudEnvironment {
+	if env, ok := cloudEnvironments[c.CloudEnvironment]; ok {
+		return env
+	}
+	return cloudEnvironments[cloudEnvironmentGlobal]
+}
+
+// AzureADEndpoint returns the OAuth2 endpoint of the given tenant in this cloud.
+func (e cloudEnvironment) AzureADEndpoint(tenant string) oauth2.Endpoint {
+	return oauth2.Endpoint{
+		AuthURL:  fmt.Sprintf("%s/%s/oauth2/v2.0/authorize", e.AuthorityHost, tenant),
+		TokenURL: fmt.Sprintf("%s/%s/oauth2/v2.0/token", e.AuthorityHost, tenant),
+	}
+}
+
+// GraphURL returns the base URL of the MS Graph beta API in this cloud.
+func (e cloudEnvironment) GraphURL() string {
+	return e.GraphHost + "/beta"
+}
+
+// Scope qualifies an MS Graph permission with the Graph resource of this cloud, since national
+// clouds don't resolve bare permission names to their own Graph deployment. Delegated permissions
+// stay bare in the global cloud, keeping the scopes users already consented to.
+func (e cloudEnvironment) Scope(permission string) string {
+	if e == cloudEnvironments[cloudEnvironmentGlobal] && permission != ".default" {
+		return permission
+	}
+	return fmt.Sprintf("%s/%s", e.GraphHost, permission)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetCloudEnvironment(t *testing.T) {
+	for _, testCase := range []struct {
+		description      string
+		cloudEnvironment string
+		expectAuthURL    string
+		expectTokenURL   string
+		expectGraphURL   string
+		expectScope      string
+		expectAppScope   string
+	}{
+		{
+			description:    "default",
+			expectAuthURL:  "https://login.microsoftonline.com/tenant-id/oauth2/v2.0/authorize",
+			expectTokenURL: "https://login.microsoftonline.com/tenant-id/oauth2/v2.0/token",
+			expectGraphURL: "https://graph.microsoft.com/beta",
+			expectScope:    "OnlineMeetings.ReadWrite",
+			expectAppScope: "https://graph.microsoft.com/.default",
+		},
+		{
+			description:      "global",
+			cloudEnvironment: cloudEnvironmentGlobal,
+			expectAuthURL:    "https://login.microsoftonline.com/tenant-id/oauth2/v2.0/authorize",
+			expectTokenURL:   "https://login.microsoftonline.com/tenant-id/oauth2/v2.0/token",
+			expectGraphURL:   "https://graph.microsoft.com/beta",
+			expectScope:      "OnlineMeetings.ReadWrite",
+			expectAppScope:   "https://graph.microsoft.com/.default",
+		},
+		{
+			description:      "unknown",
+			cloudEnvironment: "unknown",
+			expectAuthURL:    "https://login.microsoftonline.com/tenant-id/oauth2/v2.0/authorize",
+			expectTokenURL:   "https://login.microsoftonline.com/tenant-id/oauth2/v2.0/token",
+			expectGraphURL:   "https://graph.microsoft.com/beta",
+			expectScope:      "OnlineMeetings.ReadWrite",
+			expectAppScope:   "https://graph.microsoft.com/.default",
+		},
+		{
+			description:      "US Government GCC High",
+			cloudEnvironment: cloudEnvironmentGCCH,
+			expectAuthURL:    "https://login.microsoftonline.us/tenant-id/oauth2/v2.0/authorize",
+			expectTokenURL:   "https://login.microsoftonline.us/tenant-id/oauth2/v2.0/token",
+			expectGraphURL:   "https://graph.microsoft.us/beta",
+			expectScope:      "https://graph.microsoft.us/OnlineMeetings.ReadWrite",
+			expectAppScope:   "https://graph.microsoft.us/.default",
+		},
+		{
+			description:      "US Government DoD",
+			cloudEnvironment: cloudEnvironmentDoD,
+			expectAuthURL:    "https://login.microsoftonline.us/tenant-id/oauth2/v2.0/authorize",
+			expectTokenURL:   "https://login.microsoftonline.us/tenant-id/oauth2/v2.0/token",
+			expectGraphURL:   "https://dod-graph.microsoft.us/beta",
+			expectScope:      "https://dod-graph.microsoft.us/OnlineMeetings.ReadWrite",
+			expectAppScope:   "https://dod-graph.microsoft.us/.default",
+		},
+		{
+			description:      "China",
+			cloudEnvironment: cloudEnvironmentChina,
+			expectAuthURL:    "https://login.chinacloudapi.cn/tenant-id/oauth2/v2.0/authorize",
+			expectTokenURL:   "https://login.chinacloudapi.cn/tenant-id/oauth2/v2.0/token",
+			expectGraphURL:   "https://microsoftgraph.chinacloudapi.cn/beta",
+			expectScope:      "https://microsoftgraph.chinacloudapi.cn/OnlineMeetings.ReadWrite",
+			expectAppScope:   "https://microsoftgraph.chinacloudapi.cn/.default",
+		},
+	} {
+		t.Run(testCase.description, func(t *testing.T) {
+			cloud := (&configuration{CloudEnvironment: testCase.cloudEnvironment}).getCloudEnvironment()
+
+			endpoint := cloud.AzureADEndpoint("tenant-id")
+			require.Equal(t, testCase.expectAuthURL, endpoint.AuthURL)
+			require.Equal(t, testCase.expectTokenURL, endpoint.TokenURL)
+			require.Equal(t, testCase.expectGraphURL, cloud.GraphURL())
+			require.Equal(t, testCase.expectScope, cloud.Scope("OnlineMeetings.ReadWrite"))
+			require.Equal(t, testCase.expectAppScope, cloud.Scope(".default"))
+		})
+	}
+}
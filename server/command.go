@@ -2,21 +2,32 @@ package main
 
 import (
 	"fmt"
+	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin"
 	"github.com/mattermost/mattermost/server/public/pluginapi"
 	"github.com/mattermost/mattermost/server/public/pluginapi/experimental/command"
 	"github.com/pkg/errors"
+	msgraph "github.com/yaegashi/msgraph.go/beta"
 )
 
 const (
-	availableCommands = "Available commands: start, connect, disconnect, help"
+	availableCommands = "Available commands: start, new, findtime, update, share, connect, disconnect, status, presence, sweep, help"
 	commandHelp       = "###### Mattermost MS Teams Meetings Plugin - Slash Command Help\n" +
-		"* |/mstmeetings start| - Start an MS Teams meeting. \n" +
+		"* |/mstmeetings start [@user|email ...] [topic]| - Start an MS Teams meeting, inviting the mentioned people. \n" +
+		"* |/mstmeetings new| - Create an MS Teams meeting, choosing its time, invitees and lobby settings. \n" +
+		"* |/mstmeetings findtime @user|email ... <duration> [within <days> days]| - Find when everyone is available and schedule an MS Teams meeting. \n" +
+		"* |/mstmeetings update <meeting> [--topic <topic>] [--start <YYYY-MM-DD HH:MM|+30m>] [--duration <minutes>]| - Change a meeting you organized. \n" +
+		"* |/mstmeetings share <join-url>| - Share an existing MS Teams meeting, such as one scheduled in Outlook. \n" +
 		"* |/mstmeetings connect| - Connect to MS Teams meeting. \n" +
 		"* |/mstmeetings disconnect| - Disconnect your Mattermost account from MS Teams. \n" +
+		"* |/mstmeetings status [on|off]| - Set your custom status while you are in a meeting created from Mattermost. \n" +
+		"* |/mstmeetings presence [on|off]| - Set your status to Do Not Disturb while you are busy in MS Teams. \n" +
+		"* |/mstmeetings sweep| - Remove the stored connections of deactivated or deleted users (system admins only). \n" +
 		"* |/mstmeetings help| - Display this help text."
 	tooManyParametersText = "Too many parameters."
 )
@@ -51,9 +62,25 @@ func (p *Plugin) postCommandResponse(args *model.CommandArgs, text string) {
 func getAutocompleteData() *model.AutocompleteData {
 	cmd := model.NewAutocompleteData("mstmeetings", "[command]", availableCommands)
 
-	start := model.NewAutocompleteData("start", "", "Start an MS Teams meeting")
+	start := model.NewAutocompleteData("start", "[@user|email ...] [topic]", "Start an MS Teams meeting")
+	start.AddDynamicListArgument("Mention people or enter emails to invite, followed by the meeting topic", "api/v1/autocomplete/users", false)
 	cmd.AddCommand(start)
 
+	newMeeting := model.NewAutocompleteData("new", "", "Create an MS Teams meeting with more options")
+	cmd.AddCommand(newMeeting)
+
+	findTime := model.NewAutocompleteData("findtime", "@user|email ... <duration> [within <days> days]", "Find a time when everyone is available")
+	findTime.AddDynamicListArgument("Mention people or enter emails to invite, followed by the meeting duration, such as 30m within 3 days", "api/v1/autocomplete/users", false)
+	cmd.AddCommand(findTime)
+
+	update := model.NewAutocompleteData("update", "<meeting> [--topic <topic>] [--start <YYYY-MM-DD HH:MM|+30m>] [--duration <minutes>]", "Change the topic or time of a meeting you organized")
+	update.AddTextArgument("Number of the meeting, followed by the changes", "<meeting> [--topic <topic>] [--start <time>] [--duration <minutes>]", "")
+	cmd.AddCommand(update)
+
+	share := model.NewAutocompleteData("share", "<join-url>", "Share an existing MS Teams meeting")
+	share.AddTextArgument("Join link of the MS Teams meeting", "<join-url>", "")
+	cmd.AddCommand(share)
+
 	connect := model.NewAutocompleteData("connect", "",
 		"Connect your Mattermost account to MS Teams")
 	cmd.AddCommand(connect)
@@ -62,6 +89,27 @@ func getAutocompleteData() *model.AutocompleteData {
 		"Disconnect your Mattermost account from MS Teams")
 	cmd.AddCommand(disconnect)
 
+	status := model.NewAutocompleteData("status", "[on|off]",
+		"Set your custom status while you are in a meeting")
+	status.AddStaticListArgument("Whether to set your custom status during meetings", false, []model.AutocompleteListItem{
+		{Item: "on", HelpText: "Set your custom status during meetings"},
+		{Item: "off", HelpText: "Leave your custom status unchanged during meetings"},
+	})
+	cmd.AddCommand(status)
+
+	presence := model.NewAutocompleteData("presence", "[on|off]",
+		"Sync your MS Teams presence into your status")
+	presence.AddStaticListArgument("Whether to sync your MS Teams presence", false, []model.AutocompleteListItem{
+		{Item: "on", HelpText: "Set your status to Do Not Disturb while you are busy in MS Teams"},
+		{Item: "off", HelpText: "Stop syncing your MS Teams presence"},
+	})
+	cmd.AddCommand(presence)
+
+	sweep := model.NewAutocompleteData("sweep", "",
+		"Remove the stored connections of deactivated or deleted users")
+	sweep.RoleID = model.SystemAdminRoleId
+	cmd.AddCommand(sweep)
+
 	help := model.NewAutocompleteData("help", "", "Display usage information")
 	cmd.AddCommand(help)
 
@@ -86,10 +134,24 @@ func (p *Plugin) executeCommand(_ *plugin.Context, args *model.CommandArgs) (str
 	switch action {
 	case "start":
 		return p.handleStart(split[1:], args)
+	case "new":
+		return p.handleNew(split[1:], args)
+	case "findtime":
+		return p.handleFindTime(split[1:], args)
+	case "update":
+		return p.handleUpdate(split[1:], args)
+	case "share":
+		return p.handleShare(split[1:], args)
 	case "connect":
 		return p.handleConnect(split[1:], args)
 	case "disconnect":
 		return p.handleDisconnect(split[1:], args)
+	case "status":
+		return p.handleStatus(split[1:], args)
+	case "presence":
+		return p.handlePresence(split[1:], args)
+	case "sweep":
+		return p.handleSweep(split[1:], args)
 	case "help":
 		return p.handleHelp()
 	}
@@ -105,10 +167,52 @@ func (p *Plugin) handleHelp() (string, error) {
 	return p.getHelpText(), nil
 }
 
+// parseStartArgs splits the arguments of the start command into the people to invite, given as
+// @mentions or emails, and the meeting topic made of the remaining words. Only the members of
+// the team the user is in are resolved, like the suggestions of handleAutocompleteUsers.
+// Emails are kept as guest addresses when the server hides emails, so the users they belong to
+// are not revealed.
+func (p *Plugin) parseStartArgs(args []string, userID, teamID string) (string, *meetingInvitees, error) {
+	canSee := p.isActiveTeamMember(teamID, userID)
+	showEmail := p.API.GetConfig().PrivacySettings.ShowEmailAddress
+	resolveEmails := canSee && (showEmail == nil || *showEmail)
+
+	invitees := &meetingInvitees{}
+	words := []string{}
+	for _, arg := range args {
+		candidate := strings.TrimRight(arg, ",;")
+		switch {
+		case strings.HasPrefix(candidate, "@") && len(candidate) > 1:
+			user, appErr := p.API.GetUserByUsername(strings.TrimPrefix(candidate, "@"))
+			if appErr != nil || !canSee || !p.isActiveTeamMember(teamID, user.Id) {
+				return "", nil, errors.Errorf("user %s could not be found", candidate)
+			}
+			invitees.Users = append(invitees.Users, user)
+
+		case model.IsValidEmail(candidate):
+			if !resolveEmails {
+				invitees.Guests = append(invitees.Guests, candidate)
+				continue
+			}
+			user, appErr := p.API.GetUserByEmail(candidate)
+			if appErr != nil || !p.isActiveTeamMember(teamID, user.Id) {
+				invitees.Guests = append(invitees.Guests, candidate)
+				continue
+			}
+			invitees.Users = append(invitees.Users, user)
+
+		default:
+			words = append(words, arg)
+		}
+	}
+
+	return strings.Join(words, " "), invitees, nil
+}
+
 func (p *Plugin) handleStart(args []string, extra *model.CommandArgs) (string, error) {
-	topic := ""
-	if len(args) > 1 {
-		topic = strings.Join(args[1:], " ")
+	topic, invitees, err := p.parseStartArgs(args[1:], extra.UserId, extra.TeamId)
+	if err != nil {
+		return fmt.Sprintf("Cannot start the meeting: %s.", err.Error()), nil
 	}
 	userID := extra.UserId
 	user, appErr := p.API.GetUser(userID)
@@ -120,18 +224,18 @@ func (p *Plugin) handleStart(args []string, extra *model.CommandArgs) (string, e
 		return "We could not get channel members.", errors.Wrap(appErr, "cannot get channel member")
 	}
 
-	recentMeeting, recentMeetingURL, creatorName, provider, appErr := p.checkPreviousMessages(extra.ChannelId)
+	recentMeeting, recentMeetingURL, creatorName, provider, appErr := p.checkPreviousMessages(extra.ChannelId, extra.RootId)
 	if appErr != nil {
 		return "Error checking previous messages.", errors.Wrap(appErr, "cannot check previous messages")
 	}
 
 	if recentMeeting {
-		p.postConfirmCreateOrJoin(recentMeetingURL, extra.ChannelId, topic, userID, creatorName, provider)
+		p.postConfirmCreateOrJoin(recentMeetingURL, extra.ChannelId, extra.RootId, "", topic, userID, creatorName, provider)
 		p.trackMeetingDuplication(extra.UserId)
 		return "", nil
 	}
 
-	_, authErr := p.authenticateAndFetchUser(userID, extra.ChannelId)
+	remoteUser, authErr := p.authenticateOrganizer(userID, extra.ChannelId)
 	if authErr != nil {
 		// the user state will be needed later while connecting the user to MS teams meeting via OAuth
 		if _, err := p.StoreState(userID, extra.ChannelId, false); err != nil {
@@ -141,7 +245,13 @@ func (p *Plugin) handleStart(args []string, extra *model.CommandArgs) (string, e
 		return authErr.Message, authErr.Err
 	}
 
-	_, _, err := p.postMeeting(user, extra.ChannelId, topic)
+	_, _, err = p.postMeeting(user, meetingOptions{
+		ChannelID:  extra.ChannelId,
+		RootID:     extra.RootId,
+		Topic:      topic,
+		Invitees:   invitees,
+		RemoteUser: remoteUser,
+	})
 	if err != nil {
 		return "Failed to post message. Please try again.", errors.Wrap(err, "cannot post message")
 	}
@@ -150,12 +260,179 @@ func (p *Plugin) handleStart(args []string, extra *model.CommandArgs) (string, e
 	return "", nil
 }
 
+func (p *Plugin) handleNew(args []string, extra *model.CommandArgs) (string, error) {
+	if len(args) > 1 {
+		return tooManyParametersText, nil
+	}
+
+	if _, appErr := p.API.GetChannelMember(extra.ChannelId, extra.UserId); appErr != nil {
+		return "We could not get channel members.", errors.Wrap(appErr, "cannot get channel member")
+	}
+
+	if err := p.openNewMeetingDialog(extra.TriggerId, extra.ChannelId, extra.RootId); err != nil {
+		return "Failed to open the meeting dialog. Please try again.", errors.Wrap(err, "cannot open dialog")
+	}
+
+	return "", nil
+}
+
+func (p *Plugin) handleFindTime(args []string, extra *model.CommandArgs) (string, error) {
+	if !p.getConfiguration().EnableFindTime {
+		return "Finding a meeting time is not enabled on this server.", nil
+	}
+
+	words, invitees, err := p.parseStartArgs(args[1:], extra.UserId, extra.TeamId)
+	if err != nil {
+		return fmt.Sprintf("Cannot find a time: %s.", err.Error()), nil
+	}
+
+	duration, window, err := parseFindTimeArgs(strings.Fields(words))
+	if err != nil {
+		return fmt.Sprintf("Cannot find a time: %s. Use `/mstmeetings findtime @user ... 30m within 3 days`.", err.Error()), nil
+	}
+
+	userID := extra.UserId
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil {
+		return "Cannot get user.", errors.Wrap(appErr, "cannot get user")
+	}
+
+	emails := p.getInviteeEmails(userID, invitees)
+	if len(emails) == 0 {
+		return "Please mention the people to invite, or enter their emails.", nil
+	}
+
+	if _, appErr = p.API.GetChannelMember(extra.ChannelId, userID); appErr != nil {
+		return "We could not get channel members.", errors.Wrap(appErr, "cannot get channel member")
+	}
+
+	_, authErr := p.authenticateAndFetchUser(userID, extra.ChannelId)
+	if authErr != nil {
+		// the user state will be needed later while connecting the user to MS teams meeting via OAuth
+		if _, err = p.StoreState(userID, extra.ChannelId, false); err != nil {
+			p.API.LogWarn("failed to store user state", "error", err.Error())
+		}
+
+		return authErr.Message, authErr.Err
+	}
+
+	// Finding times reads the calendars of the invitees, which only the organizer's own
+	// connection is allowed to.
+	conf, err := p.getOAuthConfig()
+	if err != nil {
+		return "Failed to find a time. Please try again.", errors.Wrap(err, "cannot get OAuth config")
+	}
+	userInfo, data, err := p.getStoredUserInfo(userID)
+	if err != nil {
+		return "Connect your Microsoft account with `/mstmeetings connect` to find a time.", nil
+	}
+
+	now := time.Now()
+	slots, reason, err := p.NewUserClient(conf, userInfo, data).FindMeetingTimes(emails, duration, now, now.Add(window))
+	if err != nil {
+		var errRes *msgraph.ErrorResponse
+		if errors.As(err, &errRes) && errRes.StatusCode() == http.StatusForbidden {
+			// The user connected before reading calendars was enabled and hasn't granted it yet.
+			return p.getCalendarConsentMessage(userID, extra.ChannelId)
+		}
+		return "Failed to find a time. Please try again.", errors.Wrap(err, "cannot find meeting times")
+	}
+	if len(slots) == 0 {
+		return getEmptySuggestionsText(reason), nil
+	}
+
+	if err = p.postTimeSuggestions(user, extra.ChannelId, extra.RootId, invitees, slots); err != nil {
+		return "Failed to post the suggested times. Please try again.", errors.Wrap(err, "cannot post time suggestions")
+	}
+
+	return "", nil
+}
+
+// getCalendarConsentMessage asks the user to reconnect to allow reading calendars.
+func (p *Plugin) getCalendarConsentMessage(userID, channelID string) (string, error) {
+	if _, err := p.StoreState(userID, channelID, false); err != nil {
+		p.API.LogWarn("failed to store user state", "error", err.Error())
+	}
+
+	oauthMsg, err := p.getOauthMessage(channelID)
+	if err != nil {
+		return "Failed to find a time. Please try again.", errors.Wrap(err, "cannot get oauth message")
+	}
+	return "Your Microsoft account has not allowed reading calendars yet, reconnect to allow it. " + oauthMsg, nil
+}
+
+func (p *Plugin) handleUpdate(args []string, extra *model.CommandArgs) (string, error) {
+	if len(args) < 3 {
+		return "Please provide the meeting and what to change: `/mstmeetings update <meeting> --topic <topic> --start <time> --duration <minutes>`.", nil
+	}
+
+	meetingID, err := strconv.Atoi(strings.TrimPrefix(args[1], "#"))
+	if err != nil {
+		return fmt.Sprintf("%s is not a meeting number.", args[1]), nil
+	}
+
+	meeting, err := p.GetMeeting(meetingID)
+	if err != nil || meeting.OrganizerID != extra.UserId {
+		return "You can only update meetings you organized.", nil
+	}
+
+	user, appErr := p.API.GetUser(extra.UserId)
+	if appErr != nil {
+		return "Cannot get user.", errors.Wrap(appErr, "cannot get user")
+	}
+
+	update, err := parseMeetingUpdateArgs(args[2:], meeting, user.GetTimezoneLocation())
+	if err != nil {
+		return fmt.Sprintf("Cannot update the meeting: %s.", err.Error()), nil
+	}
+
+	if err = p.updateMeeting(extra.UserId, meeting, meeting.PostID, update); err != nil {
+		return "Failed to update the meeting. Please try again.", errors.Wrap(err, "cannot update meeting")
+	}
+
+	return fmt.Sprintf("Meeting #%d was updated.", meeting.ID), nil
+}
+
+func (p *Plugin) handleShare(args []string, extra *model.CommandArgs) (string, error) {
+	if len(args) != 2 {
+		return "Please provide the join link of the meeting: `/mstmeetings share <join-url>`.", nil
+	}
+
+	joinURL := strings.Trim(args[1], "<>")
+	if !isTeamsMeetingURL(joinURL) {
+		return "The link is not an MS Teams meeting join link.", nil
+	}
+
+	user, appErr := p.API.GetUser(extra.UserId)
+	if appErr != nil {
+		return "Cannot get user.", errors.Wrap(appErr, "cannot get user")
+	}
+
+	remoteUser, authErr := p.authenticateOrganizer(user.Id, extra.ChannelId)
+	if authErr != nil {
+		// the user state will be needed later while connecting the user to MS teams meeting via OAuth
+		if _, err := p.StoreState(user.Id, extra.ChannelId, true); err != nil {
+			p.API.LogWarn("failed to store user state", "error", err.Error())
+		}
+
+		return authErr.Message, authErr.Err
+	}
+
+	if _, err := p.shareMeeting(user, remoteUser, extra.ChannelId, extra.RootId, joinURL); err != nil {
+		return "The meeting could not be found for your Microsoft account.", errors.Wrap(err, "cannot share meeting")
+	}
+
+	return "", nil
+}
+
 func (p *Plugin) handleConnect(args []string, extra *model.CommandArgs) (string, error) {
 	if len(args) > 1 {
 		return tooManyParametersText, nil
 	}
 
-	msUser, authErr := p.authenticateAndFetchUser(extra.UserId, extra.ChannelId)
+	// Users found in the directory with application permissions still connect their own
+	// account, which some features need.
+	msUser, authErr := p.fetchConnectedUser(extra.UserId, extra.ChannelId)
 	if authErr != nil {
 		// the user state will be needed later while connecting the user to MS teams meeting via OAuth
 		if _, err := p.StoreState(extra.UserId, extra.ChannelId, true); err != nil {
@@ -185,6 +462,99 @@ func (p *Plugin) handleDisconnect(args []string, extra *model.CommandArgs) (stri
 	return "You have successfully disconnected from MS Teams Meetings.", nil
 }
 
+func (p *Plugin) handleStatus(args []string, extra *model.CommandArgs) (string, error) {
+	if len(args) > 2 {
+		return tooManyParametersText, nil
+	}
+
+	preferences, err := p.getUserPreferences(extra.UserId)
+	if err != nil {
+		return "Failed to get your preferences.", errors.Wrap(err, "cannot get user preferences")
+	}
+
+	if len(args) == 1 {
+		if preferences.MeetingStatus {
+			return "Your custom status is set while you are in a meeting. Turn it off with `/mstmeetings status off`.", nil
+		}
+		return "Your custom status is left unchanged during meetings. Turn it on with `/mstmeetings status on`.", nil
+	}
+
+	switch args[1] {
+	case "on":
+		preferences.MeetingStatus = true
+	case "off":
+		preferences.MeetingStatus = false
+	default:
+		return "Please use `/mstmeetings status on` or `/mstmeetings status off`.", nil
+	}
+
+	if err = p.storeUserPreferences(extra.UserId, preferences); err != nil {
+		return "Failed to save your preferences.", errors.Wrap(err, "cannot store user preferences")
+	}
+
+	if preferences.MeetingStatus {
+		return fmt.Sprintf("Your custom status will be set to \"%s\" while you are in a meeting created from Mattermost.", meetingStatusText), nil
+	}
+	return "Your custom status will be left unchanged during meetings.", nil
+}
+
+func (p *Plugin) handlePresence(args []string, extra *model.CommandArgs) (string, error) {
+	if len(args) > 2 {
+		return tooManyParametersText, nil
+	}
+
+	if !p.getConfiguration().SyncTeamsPresence {
+		return "Syncing your MS Teams presence is not enabled on this server.", nil
+	}
+
+	state, err := p.getPresenceSync(extra.UserId)
+	if err != nil {
+		return "Failed to get your presence sync.", errors.Wrap(err, "cannot get presence sync")
+	}
+
+	if len(args) == 1 {
+		if state != nil {
+			return "Your MS Teams presence is synced into your status. Turn it off with `/mstmeetings presence off`.", nil
+		}
+		return "Your MS Teams presence is not synced. Turn it on with `/mstmeetings presence on`.", nil
+	}
+
+	switch args[1] {
+	case "on":
+		if _, err = p.GetUserInfo(extra.UserId); err != nil {
+			return "Connect your Microsoft account with `/mstmeetings connect` to sync your presence.", nil
+		}
+		if err = p.startPresenceSync(extra.UserId); err != nil {
+			return "Failed to turn on the presence sync.", errors.Wrap(err, "cannot start presence sync")
+		}
+		return "Your status will be set to Do Not Disturb while you are busy in MS Teams. If you connected before, reconnect with `/mstmeetings connect` to allow reading your presence.", nil
+	case "off":
+		if err = p.stopPresenceSync(extra.UserId); err != nil {
+			return "Failed to turn off the presence sync.", errors.Wrap(err, "cannot stop presence sync")
+		}
+		return "Your MS Teams presence is no longer synced.", nil
+	default:
+		return "Please use `/mstmeetings presence on` or `/mstmeetings presence off`.", nil
+	}
+}
+
+func (p *Plugin) handleSweep(args []string, extra *model.CommandArgs) (string, error) {
+	if len(args) > 1 {
+		return tooManyParametersText, nil
+	}
+
+	if !p.API.HasPermissionTo(extra.UserId, model.PermissionManageSystem) {
+		return "Only system admins can remove stored connections.", nil
+	}
+
+	removed, err := p.removeDeactivatedUsers()
+	if err != nil {
+		return "Failed to remove stored connections.", errors.Wrap(err, "cannot remove deactivated users")
+	}
+
+	return fmt.Sprintf("Removed the stored connections of %d deactivated or deleted users.", removed), nil
+}
+
 // ExecuteCommand is called when any registered by this plugin command is executed
 func (p *Plugin) ExecuteCommand(c *plugin.Context, args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
 	msg, err := p.executeCommand(c, args)
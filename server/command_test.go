@@ -0,0 +1,181 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestParseStartArgs(t *testing.T) {
+	alice := &model.User{Id: "alice", Username: "alice"}
+	bob := &model.User{Id: "bob", Email: "bob@example.com"}
+	carol := &model.User{Id: "carol", Username: "carol", Email: "carol@example.com"}
+	notFound := model.NewAppError("GetUser", "not_found", nil, "", http.StatusNotFound)
+
+	newPlugin := func(showEmailAddress bool) (*Plugin, *plugintest.API) {
+		p := &Plugin{}
+		api := &plugintest.API{}
+		api.On("GetConfig").Return(&model.Config{
+			PrivacySettings: model.PrivacySettings{ShowEmailAddress: model.NewBool(showEmailAddress)},
+		})
+		api.On("GetUserByUsername", "alice").Return(alice, nil)
+		api.On("GetUserByUsername", "carol").Return(carol, nil)
+		api.On("GetUserByUsername", "nobody").Return(nil, notFound)
+		api.On("GetUserByEmail", "bob@example.com").Return(bob, nil)
+		api.On("GetUserByEmail", "carol@example.com").Return(carol, nil)
+		api.On("GetUserByEmail", "guest@other.com").Return(nil, notFound)
+		for _, userID := range []string{"user-id", "alice", "bob"} {
+			api.On("GetTeamMember", "team-id", userID).Return(&model.TeamMember{TeamId: "team-id", UserId: userID}, nil)
+		}
+		// Carol is in another team.
+		api.On("GetTeamMember", "team-id", "carol").Return(nil, notFound)
+		p.SetAPI(api)
+		return p, api
+	}
+
+	t.Run("resolves team members", func(t *testing.T) {
+		p, _ := newPlugin(true)
+
+		topic, invitees, err := p.parseStartArgs([]string{"@alice,", "bob@example.com", "guest@other.com", "carol@example.com", "Weekly", "sync,", "part", "2"}, "user-id", "team-id")
+		require.NoError(t, err)
+		require.Equal(t, "Weekly sync, part 2", topic)
+		require.Equal(t, []*model.User{alice, bob}, invitees.Users)
+		require.Equal(t, []string{"guest@other.com", "carol@example.com"}, invitees.Guests)
+
+		_, _, err = p.parseStartArgs([]string{"@nobody", "topic"}, "user-id", "team-id")
+		require.EqualError(t, err, "user @nobody could not be found")
+
+		_, _, err = p.parseStartArgs([]string{"@carol", "topic"}, "user-id", "team-id")
+		require.EqualError(t, err, "user @carol could not be found")
+	})
+
+	t.Run("keeps emails as guests when emails are hidden", func(t *testing.T) {
+		p, api := newPlugin(false)
+
+		_, invitees, err := p.parseStartArgs([]string{"@alice", "bob@example.com"}, "user-id", "team-id")
+		require.NoError(t, err)
+		require.Equal(t, []*model.User{alice}, invitees.Users)
+		require.Equal(t, []string{"bob@example.com"}, invitees.Guests)
+		api.AssertNotCalled(t, "GetUserByEmail", mock.Anything)
+	})
+
+	t.Run("resolves nobody outside the user's teams", func(t *testing.T) {
+		p, api := newPlugin(true)
+
+		_, invitees, err := p.parseStartArgs([]string{"bob@example.com"}, "user-id", "")
+		require.NoError(t, err)
+		require.Empty(t, invitees.Users)
+		require.Equal(t, []string{"bob@example.com"}, invitees.Guests)
+		api.AssertNotCalled(t, "GetUserByEmail", mock.Anything)
+
+		_, _, err = p.parseStartArgs([]string{"@alice"}, "user-id", "")
+		require.EqualError(t, err, "user @alice could not be found")
+	})
+}
+
+func TestHandleConnectApplicationPermissions(t *testing.T) {
+	// The user is found in the directory, which doesn't make them connected.
+	mockGraph(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Fatalf("unexpected request to %s", r.URL.Path)
+	})
+
+	p := &Plugin{}
+	p.setConfiguration(&configuration{
+		OAuth2Authority:    "tenant-id",
+		OAuth2ClientID:     "client-id",
+		OAuth2ClientSecret: "secret",
+		AuthenticationMode: authenticationModeApplication,
+	})
+	api := &plugintest.API{}
+	api.On("GetConfig").Return(&model.Config{
+		ServiceSettings: model.ServiceSettings{
+			SiteURL: model.NewString("https://example.com"),
+		},
+	})
+	api.On("KVGet", tokenKey+"user-id").Return(nil, nil)
+	api.On("KVSet", getOAuthUserStateKey("user-id"), []byte(getOAuthUserStateKey("user-id")+"_channel-id_true")).Return(nil)
+	p.SetAPI(api)
+
+	msg, _ := p.handleConnect([]string{"connect"}, &model.CommandArgs{UserId: "user-id", ChannelId: "channel-id"})
+	require.Equal(t, "[Click here to link your Microsoft account.](https://example.com/plugins/com.mattermost.msteamsmeetings/oauth2/connect?channelID=channel-id)", msg)
+	api.AssertCalled(t, "KVSet", getOAuthUserStateKey("user-id"), []byte(getOAuthUserStateKey("user-id")+"_channel-id_true"))
+}
+
+func TestHandleFindTime(t *testing.T) {
+	args := &model.CommandArgs{UserId: "user-id", ChannelId: "channel-id", TeamId: "team-id"}
+	command := []string{"findtime", "@alice", "30m"}
+
+	t.Run("disabled", func(t *testing.T) {
+		p := &Plugin{}
+		p.setConfiguration(&configuration{})
+		p.SetAPI(&plugintest.API{})
+
+		msg, err := p.handleFindTime(command, args)
+		require.NoError(t, err)
+		require.Equal(t, "Finding a meeting time is not enabled on this server.", msg)
+	})
+
+	t.Run("reading calendars not allowed yet", func(t *testing.T) {
+		mockGraph(t, func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/beta/me":
+				writeGraphJSON(w, http.StatusOK, `{"id":"remote-id","userPrincipalName":"user@example.com"}`)
+			case "/beta/me/findMeetingTimes":
+				writeGraphJSON(w, http.StatusForbidden, `{"error":{"code":"ErrorAccessDenied","message":"Access is denied. Check credentials and try again."}}`)
+			default:
+				t.Fatalf("unexpected request to %s", r.URL.Path)
+			}
+		})
+
+		userInfo := &UserInfo{
+			UserID:     "user-id",
+			RemoteID:   "remote-id",
+			OAuthToken: &oauth2.Token{AccessToken: "access-token", Expiry: time.Now().Add(time.Hour)},
+		}
+		data, err := userInfo.EncryptedJSON(nil)
+		require.NoError(t, err)
+		// The token is refreshed when finding times, and stored again.
+		expired := *userInfo
+		expired.OAuthToken = &oauth2.Token{AccessToken: "expired-access-token", RefreshToken: "refresh-token", Expiry: time.Now().Add(-time.Hour)}
+		expiredData, err := expired.EncryptedJSON(nil)
+		require.NoError(t, err)
+
+		p := &Plugin{}
+		p.setConfiguration(&configuration{
+			OAuth2Authority:    "tenant-id",
+			OAuth2ClientID:     "client-id",
+			OAuth2ClientSecret: "secret",
+			EnableFindTime:     true,
+		})
+		api := &plugintest.API{}
+		api.On("GetConfig").Return(&model.Config{
+			ServiceSettings: model.ServiceSettings{
+				SiteURL: model.NewString("https://example.com"),
+			},
+		})
+		api.On("GetUserByUsername", "alice").Return(&model.User{Id: "alice", Username: "alice", Email: "alice@example.com"}, nil)
+		api.On("GetTeamMember", "team-id", mock.Anything).Return(&model.TeamMember{TeamId: "team-id"}, nil)
+		api.On("KVGet", tokenKey+"alice").Return(nil, nil)
+		api.On("GetUser", "user-id").Return(&model.User{Id: "user-id"}, nil)
+		api.On("GetChannelMember", "channel-id", "user-id").Return(&model.ChannelMember{}, nil)
+		api.On("KVGet", tokenKey+"user-id").Return(data, nil).Once()
+		api.On("KVGet", tokenKey+"user-id").Return(expiredData, nil)
+		api.On("KVCompareAndSet", tokenKey+"user-id", expiredData, mock.Anything).Return(true, nil)
+		api.On("KVSet", tokenKeyByRemoteID+"remote-id", mock.Anything).Return(nil)
+		api.On("KVSet", getOAuthUserStateKey("user-id"), mock.Anything).Return(nil)
+		p.SetAPI(api)
+
+		msg, err := p.handleFindTime(command, args)
+		require.NoError(t, err)
+		require.Equal(t, "Your Microsoft account has not allowed reading calendars yet, reconnect to allow it. "+
+			"[Click here to link your Microsoft account.](https://example.com/plugins/com.mattermost.msteamsmeetings/oauth2/connect?channelID=channel-id)", msg)
+		api.AssertCalled(t, "KVSet", getOAuthUserStateKey("user-id"), []byte(getOAuthUserStateKey("user-id")+"_channel-id_false"))
+		api.AssertCalled(t, "KVCompareAndSet", tokenKey+"user-id", expiredData, mock.Anything)
+	})
+}
@@ -6,6 +6,7 @@ package main
 import (
 	"encoding/json"
 	"reflect"
+	"strings"
 
 	"github.com/mattermost/mattermost/server/public/pluginapi/experimental/bot/logger"
 	"github.com/mattermost/mattermost/server/public/pluginapi/experimental/telemetry"
@@ -24,12 +25,40 @@ import (
 // If you add non-reference types to your configuration struct, be sure to rewrite Clone as a deep
 // copy appropriate for your types.
 type configuration struct {
-	OAuth2Authority    string `json:"oauth2authority"`
-	OAuth2ClientID     string `json:"oauth2clientid"`
-	OAuth2ClientSecret string `json:"oauth2clientsecret"`
-	EncryptionKey      string `json:"encryptionkey"`
+	OAuth2Authority              string `json:"oauth2authority"`
+	OAuth2ClientID               string `json:"oauth2clientid"`
+	OAuth2ClientSecret           string `json:"oauth2clientsecret"`
+	EncryptionKey                string `json:"encryptionkey"`
+	AuthenticationMode           string `json:"authenticationmode"`
+	AutoLinkSSOAccounts          bool   `json:"autolinkssoaccounts"`
+	AccountMatchMode             string `json:"accountmatchmode"`
+	AllowedAccountDomains        string `json:"allowedaccountdomains"`
+	CloudEnvironment             string `json:"cloudenvironment"`
+	OAuth2Certificate            string `json:"oauth2certificate"`
+	OAuth2PrivateKey             string `json:"oauth2privatekey"`
+	AllowedTenants               string `json:"allowedtenants"`
+	InviteUnconnectedMembers     bool   `json:"inviteunconnectedmembers"`
+	PostAttendanceReports        bool   `json:"postattendancereports"`
+	PostTranscriptsAndRecordings bool   `json:"posttranscriptsandrecordings"`
+	EnableMeetingNotifications   bool   `json:"enablemeetingnotifications"`
+	SyncTeamsPresence            bool   `json:"syncteamspresence"`
+	EnableFindTime               bool   `json:"enablefindtime"`
+
+	// clientCertificate is parsed from OAuth2Certificate and OAuth2PrivateKey whenever the
+	// configuration changes.
+	clientCertificate *clientCertificate
 }
 
+const (
+	authenticationModeDelegated   = "delegated"
+	authenticationModeApplication = "application"
+
+	accountMatchModeNone   = "none"
+	accountMatchModeEmail  = "email"
+	accountMatchModeDomain = "domain"
+	accountMatchModeUPN    = "upn"
+)
+
 func (c *configuration) ToMap() (map[string]interface{}, error) {
 	var out map[string]interface{}
 	data, err := json.Marshal(c)
@@ -44,13 +73,59 @@ func (c *configuration) ToMap() (map[string]interface{}, error) {
 	return out, nil
 }
 
+// differsOAuth2 reports whether the stored tokens are no longer usable with the other
+// configuration. Rotating the client secret or certificate of the same application keeps them
+// valid.
 func (c *configuration) differsOAuth2(other *configuration) bool {
 	return c.OAuth2Authority != other.OAuth2Authority ||
 		c.OAuth2ClientID != other.OAuth2ClientID ||
-		c.OAuth2ClientSecret != other.OAuth2ClientSecret ||
+		c.getCloudEnvironment() != other.getCloudEnvironment() ||
 		c.EncryptionKey != other.EncryptionKey
 }
 
+// useApplicationPermissions reports whether meetings should be created with the application's
+// own credentials instead of each user's delegated token.
+func (c *configuration) useApplicationPermissions() bool {
+	return c.AuthenticationMode == authenticationModeApplication
+}
+
+// getAllowedAccountDomains returns the normalized list of domains linked Microsoft accounts
+// may belong to.
+func (c *configuration) getAllowedAccountDomains() []string {
+	domains := []string{}
+	for _, domain := range strings.Split(c.AllowedAccountDomains, ",") {
+		domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "@"))
+		if domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
+
+// useCertificateCredentials reports whether the Azure application authenticates with a
+// certificate instead of a client secret.
+func (c *configuration) useCertificateCredentials() bool {
+	return c.OAuth2Certificate != "" || c.OAuth2PrivateKey != ""
+}
+
+// isMultiTenant reports whether the configured authority accepts accounts from any tenant.
+func (c *configuration) isMultiTenant() bool {
+	return multiTenantAuthorities[strings.ToLower(c.OAuth2Authority)]
+}
+
+// getAllowedTenants returns the normalized list of tenant IDs users may link accounts from when
+// using a multi-tenant authority. An empty list allows every tenant.
+func (c *configuration) getAllowedTenants() []string {
+	tenants := []string{}
+	for _, tenant := range strings.Split(c.AllowedTenants, ",") {
+		tenant = strings.ToLower(strings.TrimSpace(tenant))
+		if tenant != "" {
+			tenants = append(tenants, tenant)
+		}
+	}
+	return tenants
+}
+
 // Clone shallow copies the configuration. Your implementation may require a deep copy if
 // your configuration has reference types.
 func (c *configuration) Clone() *configuration {
@@ -61,7 +136,7 @@ func (c *configuration) Clone() *configuration {
 // IsValid checks if all needed fields are set.
 func (c *configuration) IsValid() error {
 	switch {
-	case len(c.OAuth2ClientSecret) == 0:
+	case len(c.OAuth2ClientSecret) == 0 && !c.useCertificateCredentials():
 		return errors.New("OAuthClientSecret is not configured")
 
 	case len(c.OAuth2ClientID) == 0:
@@ -69,6 +144,36 @@ func (c *configuration) IsValid() error {
 
 	case len(c.OAuth2Authority) == 0:
 		return errors.New("OAuth2Authority is not configured")
+
+	case c.useCertificateCredentials() && (len(c.OAuth2Certificate) == 0 || len(c.OAuth2PrivateKey) == 0):
+		return errors.New("both OAuth2Certificate and OAuth2PrivateKey must be configured")
+
+	case c.useCertificateCredentials() && c.clientCertificate == nil:
+		return errors.New("OAuth2Certificate is not valid")
+
+	case c.AuthenticationMode != "" &&
+		c.AuthenticationMode != authenticationModeDelegated &&
+		c.AuthenticationMode != authenticationModeApplication:
+		return errors.Errorf("AuthenticationMode %q is not supported", c.AuthenticationMode)
+
+	case c.useApplicationPermissions() && c.isMultiTenant():
+		return errors.New("AuthenticationMode application requires OAuth2Authority to be a specific tenant")
+
+	case c.EnableMeetingNotifications && c.isMultiTenant():
+		return errors.New("EnableMeetingNotifications requires OAuth2Authority to be a specific tenant")
+
+	case c.AccountMatchMode != "" &&
+		c.AccountMatchMode != accountMatchModeNone &&
+		c.AccountMatchMode != accountMatchModeEmail &&
+		c.AccountMatchMode != accountMatchModeDomain &&
+		c.AccountMatchMode != accountMatchModeUPN:
+		return errors.Errorf("AccountMatchMode %q is not supported", c.AccountMatchMode)
+
+	case c.CloudEnvironment != "" && cloudEnvironments[c.CloudEnvironment] == (cloudEnvironment{}):
+		return errors.Errorf("CloudEnvironment %q is not supported", c.CloudEnvironment)
+
+	case c.AccountMatchMode == accountMatchModeDomain && len(c.getAllowedAccountDomains()) == 0:
+		return errors.New("AllowedAccountDomains is not configured")
 	}
 
 	return nil
@@ -121,6 +226,14 @@ func (p *Plugin) OnConfigurationChange() error {
 		return errors.Wrap(err, "failed to load plugin configuration")
 	}
 
+	if loaded.useCertificateCredentials() {
+		certificate, err := parseClientCertificate(loaded.OAuth2Certificate, loaded.OAuth2PrivateKey)
+		if err != nil {
+			p.API.LogError("OnConfigurationChange, failed to load client certificate", "error", err.Error())
+		}
+		loaded.clientCertificate = certificate
+	}
+
 	changedEncryptionKey := false
 	resetUserKeys := (prev != nil && loaded.differsOAuth2(prev))
 	if loaded.EncryptionKey == "" {
@@ -135,10 +248,14 @@ func (p *Plugin) OnConfigurationChange() error {
 	}
 
 	p.setConfiguration(&loaded)
+	p.resetAppClient()
 
 	if changedEncryptionKey {
 		go p.storeConfiguration(&loaded)
 	}
+	if prev != nil && prev.SyncTeamsPresence && !loaded.SyncTeamsPresence {
+		go p.restorePresenceStatuses()
+	}
 	if resetUserKeys {
 		// not sure how to avoid executing this from each server since
 		// cluster.Mutex relies on KV, which is wiped out. Should be safe to
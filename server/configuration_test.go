@@ -0,0 +1,122 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigurationDiffersOAuth2(t *testing.T) {
+	base := &configuration{
+		OAuth2Authority:    "tenant-id",
+		OAuth2ClientID:     "client-id",
+		OAuth2ClientSecret: "secret",
+		OAuth2Certificate:  "certificate",
+		OAuth2PrivateKey:   "private key",
+		EncryptionKey:      "encryption key",
+	}
+
+	for _, testCase := range []struct {
+		description string
+		change      func(c *configuration)
+		expected    bool
+	}{
+		{
+			description: "unchanged",
+			change:      func(c *configuration) {},
+		},
+		{
+			description: "rotated client secret",
+			change:      func(c *configuration) { c.OAuth2ClientSecret = "new secret" },
+		},
+		{
+			description: "rotated certificate",
+			change: func(c *configuration) {
+				c.OAuth2Certificate = "new certificate"
+				c.OAuth2PrivateKey = "new private key"
+			},
+		},
+		{
+			description: "changed authority",
+			change:      func(c *configuration) { c.OAuth2Authority = "other-tenant-id" },
+			expected:    true,
+		},
+		{
+			description: "changed client ID",
+			change:      func(c *configuration) { c.OAuth2ClientID = "other-client-id" },
+			expected:    true,
+		},
+		{
+			description: "changed cloud environment",
+			change:      func(c *configuration) { c.CloudEnvironment = cloudEnvironmentGCCH },
+			expected:    true,
+		},
+		{
+			description: "changed encryption key",
+			change:      func(c *configuration) { c.EncryptionKey = "new encryption key" },
+			expected:    true,
+		},
+	} {
+		t.Run(testCase.description, func(t *testing.T) {
+			changed := base.Clone()
+			testCase.change(changed)
+			require.Equal(t, testCase.expected, changed.differsOAuth2(base))
+		})
+	}
+}
+
+func TestOnConfigurationChangeClientCertificate(t *testing.T) {
+	certificatePEM, privateKeyPEM, _ := newTestCertificate(t)
+
+	for _, testCase := range []struct {
+		description       string
+		privateKey        string
+		expectCertificate bool
+	}{
+		{
+			description:       "valid certificate",
+			privateKey:        privateKeyPEM,
+			expectCertificate: true,
+		},
+		{
+			description: "invalid certificate",
+			privateKey:  "not a key",
+		},
+	} {
+		t.Run(testCase.description, func(t *testing.T) {
+			p := &Plugin{}
+			api := &plugintest.API{}
+			api.On("LoadPluginConfiguration", mock.AnythingOfType("*main.configuration")).Run(func(args mock.Arguments) {
+				*args.Get(0).(*configuration) = configuration{
+					OAuth2Authority:   "tenant-id",
+					OAuth2ClientID:    "client-id",
+					OAuth2Certificate: certificatePEM,
+					OAuth2PrivateKey:  testCase.privateKey,
+					EncryptionKey:     "encryption key",
+				}
+			}).Return(nil)
+			api.On("GetDiagnosticId").Return("diagnostic-id")
+			api.On("GetServerVersion").Return("9.0.0")
+			api.On("GetConfig").Return(&model.Config{})
+			if !testCase.expectCertificate {
+				api.On("LogError", "OnConfigurationChange, failed to load client certificate", "error", mock.AnythingOfType("string")).Return().Once()
+			}
+			p.SetAPI(api)
+
+			require.NoError(t, p.OnConfigurationChange())
+
+			config := p.getConfiguration()
+			if testCase.expectCertificate {
+				require.NotNil(t, config.clientCertificate)
+				require.NoError(t, config.IsValid())
+			} else {
+				require.Nil(t, config.clientCertificate)
+				require.EqualError(t, config.IsValid(), "OAuth2Certificate is not valid")
+			}
+			api.AssertExpectations(t)
+		})
+	}
+}
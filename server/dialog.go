@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	newMeetingDialogCallbackID = "new_meeting"
+	newMeetingDialogPath       = "/api/v1/meetings/dialog"
+	newMeetingDialogSubmitPath = "/api/v1/meetings/dialog/submit"
+
+	// dialogTimeFormat is how the start time of a meeting is entered in the dialog.
+	dialogTimeFormat = "2006-01-02 15:04"
+
+	dialogFieldTopic        = "topic"
+	dialogFieldStartTime    = "start_time"
+	dialogFieldDuration     = "duration"
+	dialogFieldInvitees     = "invitees"
+	dialogFieldLobby        = "lobby"
+	dialogFieldPostInThread = "post_in_thread"
+)
+
+// meetingDurations are the durations offered in the dialog, in minutes.
+var meetingDurations = []int{15, 30, 45, 60, 90, 120}
+
+// lobbyBypassScopes are the lobby policies of Microsoft online meetings, by who skips the lobby.
+var lobbyBypassScopes = []*model.PostActionOptions{
+	{Text: "Everyone", Value: "everyone"},
+	{Text: "People in my organization and trusted organizations", Value: "organizationAndFederated"},
+	{Text: "People in my organization", Value: "organization"},
+	{Text: "People I invite", Value: "invited"},
+	{Text: "Only me", Value: "organizer"},
+}
+
+// newMeetingDialogState is where the meeting of a dialog is posted.
+type newMeetingDialogState struct {
+	ChannelID string `json:"channel_id"`
+	RootID    string `json:"root_id"`
+}
+
+// getNewMeetingDialog returns the dialog to create a meeting in the channel, or in the thread if
+// rootID is set.
+func (p *Plugin) getNewMeetingDialog(channelID, rootID string) (*model.OpenDialogRequest, error) {
+	pluginURL, err := p.getPluginURL()
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := json.Marshal(newMeetingDialogState{ChannelID: channelID, RootID: rootID})
+	if err != nil {
+		return nil, err
+	}
+
+	durations := []*model.PostActionOptions{}
+	for _, minutes := range meetingDurations {
+		durations = append(durations, &model.PostActionOptions{
+			Text:  fmt.Sprintf("%d minutes", minutes),
+			Value: strconv.Itoa(minutes),
+		})
+	}
+
+	elements := []model.DialogElement{
+		{
+			DisplayName: "Topic",
+			Name:        dialogFieldTopic,
+			Type:        "text",
+			Placeholder: "MS Teams Meeting",
+			Optional:    true,
+		},
+		{
+			DisplayName: "Start time",
+			Name:        dialogFieldStartTime,
+			Type:        "text",
+			Placeholder: "YYYY-MM-DD HH:MM",
+			HelpText:    "In your timezone. Leave empty to start the meeting now.",
+			Optional:    true,
+		},
+		{
+			DisplayName: "Duration",
+			Name:        dialogFieldDuration,
+			Type:        "select",
+			Default:     strconv.Itoa(int(defaultMeetingDuration.Minutes())),
+			Options:     durations,
+		},
+		{
+			DisplayName: "Invitees",
+			Name:        dialogFieldInvitees,
+			Type:        "textarea",
+			Placeholder: "@user email@example.com",
+			HelpText:    "Mention people or enter emails, separated by spaces or commas.",
+			Optional:    true,
+		},
+		{
+			DisplayName: "Who can bypass the lobby",
+			Name:        dialogFieldLobby,
+			Type:        "select",
+			HelpText:    "Leave empty to use the policy of your organization.",
+			Optional:    true,
+			Options:     lobbyBypassScopes,
+		},
+	}
+
+	if rootID != "" {
+		elements = append(elements, model.DialogElement{
+			DisplayName: "Post in thread",
+			Name:        dialogFieldPostInThread,
+			Type:        "bool",
+			Default:     "true",
+			Placeholder: "Post the meeting in the thread instead of the channel.",
+			Optional:    true,
+		})
+	}
+
+	return &model.OpenDialogRequest{
+		URL: pluginURL + newMeetingDialogSubmitPath,
+		Dialog: model.Dialog{
+			CallbackId:  newMeetingDialogCallbackID,
+			Title:       "New MS Teams Meeting",
+			Elements:    elements,
+			SubmitLabel: "Create",
+			State:       string(state),
+		},
+	}, nil
+}
+
+// openNewMeetingDialog opens the dialog to create a meeting in response to a slash command.
+func (p *Plugin) openNewMeetingDialog(triggerID, channelID, rootID string) error {
+	request, err := p.getNewMeetingDialog(channelID, rootID)
+	if err != nil {
+		return err
+	}
+
+	request.TriggerId = triggerID
+	if appErr := p.API.OpenInteractiveDialog(*request); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+// parseNewMeetingSubmission validates the submitted dialog, returning the meeting options or
+// the errors to show next to the invalid fields.
+func (p *Plugin) parseNewMeetingSubmission(user *model.User, teamID string, state newMeetingDialogState, submission map[string]interface{}) (*meetingOptions, map[string]string) {
+	opts := &meetingOptions{
+		ChannelID: state.ChannelID,
+		Topic:     strings.TrimSpace(getString(dialogFieldTopic, submission)),
+	}
+	fieldErrors := map[string]string{}
+
+	if postInThread, _ := submission[dialogFieldPostInThread].(bool); postInThread {
+		opts.RootID = state.RootID
+	}
+
+	if startTime := strings.TrimSpace(getString(dialogFieldStartTime, submission)); startTime != "" {
+		start, err := time.ParseInLocation(dialogTimeFormat, startTime, user.GetTimezoneLocation())
+		switch {
+		case err != nil:
+			fieldErrors[dialogFieldStartTime] = "Enter the start time as YYYY-MM-DD HH:MM."
+		case start.Before(time.Now().Add(-time.Minute)):
+			fieldErrors[dialogFieldStartTime] = "The start time must be in the future."
+		default:
+			opts.Settings.StartAt = start
+		}
+	}
+
+	if duration := getString(dialogFieldDuration, submission); duration != "" {
+		minutes, err := strconv.Atoi(duration)
+		if err != nil || minutes <= 0 {
+			fieldErrors[dialogFieldDuration] = "Select a duration."
+		} else {
+			opts.Settings.Duration = time.Duration(minutes) * time.Minute
+		}
+	}
+
+	if lobby := getString(dialogFieldLobby, submission); lobby != "" {
+		if !isValidLobbyBypassScope(lobby) {
+			fieldErrors[dialogFieldLobby] = "Select who can bypass the lobby."
+		}
+		opts.Settings.LobbyBypassScope = lobby
+	}
+
+	invitees, err := p.parseInvitees(getString(dialogFieldInvitees, submission), user.Id, teamID)
+	if err != nil {
+		fieldErrors[dialogFieldInvitees] = fmt.Sprintf("%s.", err.Error())
+	}
+	opts.Invitees = invitees
+
+	return opts, fieldErrors
+}
+
+// parseInvitees reads the people to invite from a list of mentions and emails, resolving them
+// like parseStartArgs.
+func (p *Plugin) parseInvitees(text, userID, teamID string) (*meetingInvitees, error) {
+	args := strings.FieldsFunc(text, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' ' || r == '\n' || r == '\t'
+	})
+
+	rest, invitees, err := p.parseStartArgs(args, userID, teamID)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, errors.Errorf("%s is not a mention or an email", strings.Fields(rest)[0])
+	}
+	return invitees, nil
+}
+
+func isValidLobbyBypassScope(scope string) bool {
+	for _, option := range lobbyBypassScopes {
+		if option.Value == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type newMeetingDialogRequest struct {
+	ChannelID string `json:"channel_id"`
+	RootID    string `json:"root_id"`
+}
+
+// handleNewMeetingDialog returns the dialog to create a meeting, for the webapp to open.
+func (p *Plugin) handleNewMeetingDialog(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		p.API.LogError("handleNewMeetingDialog, unauthorized user")
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req newMeetingDialogRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		p.API.LogError("handleNewMeetingDialog, failed to decode payload", "Error", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, appErr := p.API.GetChannelMember(req.ChannelID, userID); appErr != nil {
+		p.API.LogError("handleNewMeetingDialog, failed to get channel member", "UserID", userID, "Error", appErr.Message)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	dialog, err := p.getNewMeetingDialog(req.ChannelID, req.RootID)
+	if err != nil {
+		p.API.LogError("handleNewMeetingDialog, failed to get dialog", "Error", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(dialog); err != nil {
+		p.API.LogWarn("failed to write response", "error", err.Error())
+	}
+}
+
+// handleSubmitNewMeetingDialog creates the meeting described in a submitted dialog.
+func (p *Plugin) handleSubmitNewMeetingDialog(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		p.API.LogError("handleSubmitNewMeetingDialog, unauthorized user")
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req model.SubmitDialogRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		p.API.LogError("handleSubmitNewMeetingDialog, failed to decode payload", "Error", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Cancelled {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var state newMeetingDialogState
+	if err := json.Unmarshal([]byte(req.State), &state); err != nil {
+		p.API.LogError("handleSubmitNewMeetingDialog, failed to decode state", "Error", err.Error())
+		http.Error(w, "invalid state", http.StatusBadRequest)
+		return
+	}
+
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil {
+		p.API.LogError("handleSubmitNewMeetingDialog, failed to get user", "UserID", userID, "Error", appErr.Message)
+		http.Error(w, appErr.Error(), appErr.StatusCode)
+		return
+	}
+
+	if _, appErr = p.API.GetChannelMember(state.ChannelID, userID); appErr != nil {
+		p.API.LogError("handleSubmitNewMeetingDialog, failed to get channel member", "UserID", userID, "Error", appErr.Message)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	opts, fieldErrors := p.parseNewMeetingSubmission(user, req.TeamId, state, req.Submission)
+	if len(fieldErrors) > 0 {
+		p.writeDialogResponse(w, &model.SubmitDialogResponse{Errors: fieldErrors})
+		return
+	}
+
+	remoteUser, authErr := p.authenticateOrganizer(userID, state.ChannelID)
+	if authErr != nil {
+		if _, err := p.postConnect(state.ChannelID, userID); err != nil {
+			p.API.LogWarn("failed to create connect post", "error", err.Error())
+		}
+
+		// the user state will be needed later while connecting the user to MS teams meeting via OAuth
+		if _, err := p.StoreState(userID, state.ChannelID, false); err != nil {
+			p.API.LogWarn("failed to store user state", "error", err.Error())
+		}
+
+		p.writeDialogResponse(w, &model.SubmitDialogResponse{Error: "Connect your Microsoft account before creating a meeting."})
+		return
+	}
+
+	opts.RemoteUser = remoteUser
+	if _, _, err := p.postMeeting(user, *opts); err != nil {
+		p.API.LogError("handleSubmitNewMeetingDialog, failed to post meeting", "UserID", userID, "Error", err.Error())
+		p.writeDialogResponse(w, &model.SubmitDialogResponse{Error: "Failed to create the meeting. Please try again."})
+		return
+	}
+
+	p.trackMeetingStart(userID, telemetryStartSourceDialog)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (p *Plugin) writeDialogResponse(w http.ResponseWriter, response *model.SubmitDialogResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		p.API.LogWarn("failed to write response", "error", err.Error())
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNewMeetingSubmission(t *testing.T) {
+	p := &Plugin{}
+	api := &plugintest.API{}
+	p.SetAPI(api)
+
+	alice := &model.User{Id: "alice", Username: "alice"}
+	api.On("GetUserByUsername", "alice").Return(alice, nil)
+	api.On("GetUserByEmail", "guest@example.com").Return(nil, model.NewAppError("GetUser", "not_found", nil, "", http.StatusNotFound))
+	api.On("GetConfig").Return(&model.Config{})
+	api.On("GetTeamMember", "team", mock.Anything).Return(&model.TeamMember{TeamId: "team"}, nil)
+
+	user := &model.User{
+		Id:       "user",
+		Timezone: model.StringMap{"useAutomaticTimezone": "false", "manualTimezone": "Europe/Paris"},
+	}
+	state := newMeetingDialogState{ChannelID: "channel", RootID: "root"}
+	start := time.Now().Add(24 * time.Hour).In(user.GetTimezoneLocation())
+
+	opts, fieldErrors := p.parseNewMeetingSubmission(user, "team", state, map[string]interface{}{
+		dialogFieldTopic:        " Planning ",
+		dialogFieldStartTime:    start.Format(dialogTimeFormat),
+		dialogFieldDuration:     "30",
+		dialogFieldInvitees:     "@alice, guest@example.com",
+		dialogFieldLobby:        "organization",
+		dialogFieldPostInThread: true,
+	})
+	require.Empty(t, fieldErrors)
+	require.Equal(t, "channel", opts.ChannelID)
+	require.Equal(t, "root", opts.RootID)
+	require.Equal(t, "Planning", opts.Topic)
+	require.Equal(t, start.Truncate(time.Minute).Unix(), opts.Settings.StartAt.Unix())
+	require.Equal(t, 30*time.Minute, opts.Settings.Duration)
+	require.Equal(t, "organization", opts.Settings.LobbyBypassScope)
+	require.Equal(t, []*model.User{alice}, opts.Invitees.Users)
+	require.Equal(t, []string{"guest@example.com"}, opts.Invitees.Guests)
+
+	opts, fieldErrors = p.parseNewMeetingSubmission(user, "team", state, map[string]interface{}{
+		dialogFieldStartTime: "tomorrow",
+		dialogFieldDuration:  "0",
+		dialogFieldInvitees:  "alice",
+		dialogFieldLobby:     "anyone",
+	})
+	require.Len(t, fieldErrors, 4)
+	require.Contains(t, fieldErrors, dialogFieldStartTime)
+	require.Contains(t, fieldErrors, dialogFieldDuration)
+	require.Contains(t, fieldErrors, dialogFieldInvitees)
+	require.Contains(t, fieldErrors, dialogFieldLobby)
+	require.Empty(t, opts.RootID)
+
+	_, fieldErrors = p.parseNewMeetingSubmission(user, "team", state, map[string]interface{}{
+		dialogFieldStartTime: start.Add(-48 * time.Hour).Format(dialogTimeFormat),
+	})
+	require.Equal(t, "The start time must be in the future.", fieldErrors[dialogFieldStartTime])
+}
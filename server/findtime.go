@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	findTimeActionPath = "/api/v1/meetings/findtime"
+
+	// graphDateTimeFormat is the format of the date and time of a dateTimeTimeZone of MS Graph.
+	graphDateTimeFormat = "2006-01-02T15:04:05.9999999"
+
+	maxTimeSuggestions    = 5
+	defaultFindTimeWindow = 7 * 24 * time.Hour
+	maxFindTimeWindow     = 30 * 24 * time.Hour
+
+	findTimeSlotFormat = "Mon, Jan 2 15:04"
+)
+
+// dateTimeTimeZone is a date and time of MS Graph, in the given time zone.
+type dateTimeTimeZone struct {
+	DateTime string `json:"dateTime"`
+	TimeZone string `json:"timeZone"`
+}
+
+// meetingTimeSlot is a time all the attendees of a meeting are available.
+type meetingTimeSlot struct {
+	Start time.Time
+	End   time.Time
+}
+
+// FindMeetingTimes suggests up to maxTimeSuggestions times between start and end when the
+// signed-in user and the attendees are available for a meeting of the given duration. When none
+// is found, the reason Microsoft gives is returned instead.
+func (c *Client) FindMeetingTimes(emails []string, duration time.Duration, start, end time.Time) ([]meetingTimeSlot, string, error) {
+	type attendee struct {
+		Type         string `json:"type"`
+		EmailAddress struct {
+			Address string `json:"address"`
+		} `json:"emailAddress"`
+	}
+	attendees := []attendee{}
+	for _, email := range emails {
+		a := attendee{Type: "required"}
+		a.EmailAddress.Address = email
+		attendees = append(attendees, a)
+	}
+
+	in := map[string]interface{}{
+		"attendees": attendees,
+		"timeConstraint": map[string]interface{}{
+			"activityDomain": "work",
+			"timeSlots": []map[string]interface{}{{
+				"start": dateTimeTimeZone{DateTime: start.UTC().Format(graphDateTimeFormat), TimeZone: "UTC"},
+				"end":   dateTimeTimeZone{DateTime: end.UTC().Format(graphDateTimeFormat), TimeZone: "UTC"},
+			}},
+		},
+		"meetingDuration":           fmt.Sprintf("PT%dM", int(duration.Minutes())),
+		"maxCandidates":             maxTimeSuggestions,
+		"isOrganizerOptional":       false,
+		"returnSuggestionReasons":   true,
+		"minimumAttendeePercentage": 100,
+	}
+
+	out := struct {
+		EmptySuggestionsReason string `json:"emptySuggestionsReason"`
+		MeetingTimeSuggestions []struct {
+			MeetingTimeSlot struct {
+				Start dateTimeTimeZone `json:"start"`
+				End   dateTimeTimeZone `json:"end"`
+			} `json:"meetingTimeSlot"`
+		} `json:"meetingTimeSuggestions"`
+	}{}
+
+	req := c.builder.Me().Request()
+	req.Header().Set("Prefer", `outlook.timezone="UTC"`)
+	if err := req.JSONRequest(context.Background(), http.MethodPost, "/findMeetingTimes", in, &out); err != nil {
+		return nil, "", errors.Wrap(err, "cannot find meeting times")
+	}
+
+	slots := []meetingTimeSlot{}
+	for _, suggestion := range out.MeetingTimeSuggestions {
+		slotStart, err := time.ParseInLocation(graphDateTimeFormat, suggestion.MeetingTimeSlot.Start.DateTime, time.UTC)
+		if err != nil {
+			return nil, "", errors.Wrap(err, "invalid meeting time suggestion")
+		}
+		slotEnd, err := time.ParseInLocation(graphDateTimeFormat, suggestion.MeetingTimeSlot.End.DateTime, time.UTC)
+		if err != nil {
+			return nil, "", errors.Wrap(err, "invalid meeting time suggestion")
+		}
+		slots = append(slots, meetingTimeSlot{Start: slotStart, End: slotEnd})
+	}
+
+	if len(slots) > maxTimeSuggestions {
+		slots = slots[:maxTimeSuggestions]
+	}
+	return slots, out.EmptySuggestionsReason, nil
+}
+
+// parseFindTimeArgs reads the duration of the meeting and the optional window to search, such
+// as 30m within 3 days.
+func parseFindTimeArgs(words []string) (time.Duration, time.Duration, error) {
+	if len(words) == 0 {
+		return 0, 0, errors.New("the meeting duration is missing, such as 30m")
+	}
+
+	duration, err := parseMeetingDuration(words[0])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	words = words[1:]
+	if len(words) == 0 {
+		return duration, defaultFindTimeWindow, nil
+	}
+	if words[0] != "within" || len(words) < 2 || len(words) > 3 {
+		return 0, 0, errors.Errorf("unexpected %s, use within followed by a number of days", strings.Join(words, " "))
+	}
+
+	window, err := parseFindTimeWindow(words[1:])
+	if err != nil {
+		return 0, 0, err
+	}
+	if window < duration {
+		return 0, 0, errors.New("the window to search must be longer than the meeting")
+	}
+	if window > maxFindTimeWindow {
+		return 0, 0, errors.Errorf("the window to search must be at most %d days", int(maxFindTimeWindow.Hours()/24))
+	}
+
+	return duration, window, nil
+}
+
+// parseFindTimeWindow reads a window such as 3 days, 1 week, 3d or 36h. A number alone is a
+// number of days.
+func parseFindTimeWindow(words []string) (time.Duration, error) {
+	value, unit := words[0], ""
+	if len(words) == 2 {
+		unit = strings.TrimSuffix(strings.ToLower(words[1]), "s")
+	} else if trimmed := strings.TrimRight(value, "dw"); trimmed != value && trimmed != "" {
+		value, unit = trimmed, strings.TrimPrefix(value, trimmed)
+	} else if _, err := strconv.Atoi(value); err == nil {
+		unit = "day"
+	}
+
+	if unit == "" {
+		window, err := time.ParseDuration(value)
+		if err != nil || window <= 0 {
+			return 0, errors.Errorf("invalid window %s", strings.Join(words, " "))
+		}
+		return window, nil
+	}
+
+	count, err := strconv.Atoi(value)
+	if err != nil || count <= 0 {
+		return 0, errors.Errorf("invalid window %s", strings.Join(words, " "))
+	}
+
+	switch unit {
+	case "h", "hour":
+		return time.Duration(count) * time.Hour, nil
+	case "d", "day":
+		return time.Duration(count) * 24 * time.Hour, nil
+	case "w", "week":
+		return time.Duration(count) * 7 * 24 * time.Hour, nil
+	}
+	return 0, errors.Errorf("invalid window %s", strings.Join(words, " "))
+}
+
+// getInviteeEmails returns the addresses to look up the availability of the invitees with,
+// preferring the Microsoft account of connected users.
+func (p *Plugin) getInviteeEmails(organizerID string, invitees *meetingInvitees) []string {
+	emails := []string{}
+	for _, user := range invitees.Users {
+		if user.Id == organizerID {
+			continue
+		}
+
+		if userInfo, err := p.GetUserInfo(user.Id); err == nil {
+			if userInfo.Email != "" {
+				emails = append(emails, userInfo.Email)
+				continue
+			}
+			if userInfo.UPN != "" {
+				emails = append(emails, userInfo.UPN)
+				continue
+			}
+		}
+		if user.Email != "" {
+			emails = append(emails, user.Email)
+		}
+	}
+	return append(emails, invitees.Guests...)
+}
+
+// postTimeSuggestions offers the suggested times as buttons, each scheduling the meeting at
+// that time.
+func (p *Plugin) postTimeSuggestions(user *model.User, channelID, rootID string, invitees *meetingInvitees, slots []meetingTimeSlot) error {
+	pluginURL, err := p.getPluginURL()
+	if err != nil {
+		return err
+	}
+
+	userIDs := []string{}
+	for _, invitee := range invitees.Users {
+		userIDs = append(userIDs, invitee.Id)
+	}
+
+	location := user.GetTimezoneLocation()
+	actions := []*model.PostAction{}
+	for i, slot := range slots {
+		actions = append(actions, &model.PostAction{
+			// Post action IDs must be alphanumeric.
+			Id:   fmt.Sprintf("slot%d", i),
+			Name: slot.Start.In(location).Format(findTimeSlotFormat),
+			Type: model.PostActionTypeButton,
+			Integration: &model.PostActionIntegration{
+				URL: pluginURL + findTimeActionPath,
+				Context: map[string]interface{}{
+					"channel_id": channelID,
+					"root_id":    rootID,
+					"start_at":   strconv.FormatInt(model.GetMillisForTime(slot.Start), 10),
+					"duration":   strconv.Itoa(int(slot.End.Sub(slot.Start).Minutes())),
+					"user_ids":   userIDs,
+					"guests":     invitees.Guests,
+				},
+			},
+		})
+	}
+
+	post := &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: channelID,
+		RootId:    rootID,
+		Message:   fmt.Sprintf("Everyone is available at these times (%s). Pick one to schedule the meeting.", location.String()),
+	}
+	post.AddProp("attachments", []*model.SlackAttachment{{Actions: actions}})
+	p.API.SendEphemeralPost(user.Id, post)
+	return nil
+}
+
+// getEmptySuggestionsText explains why no time could be suggested.
+func getEmptySuggestionsText(reason string) string {
+	switch reason {
+	case "attendeesUnavailable", "attendeesUnavailableOrUnknown":
+		return "No time works for all the invitees in that window. Try a longer window or a shorter meeting."
+	case "organizerUnavailable":
+		return "You are not available in that window. Try a longer window or a shorter meeting."
+	default:
+		return "No time could be found in that window. Try a longer window or a shorter meeting."
+	}
+}
+
+// handleFindTimeAction schedules the meeting at the time picked among the suggestions.
+func (p *Plugin) handleFindTimeAction(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		p.API.LogError("handleFindTimeAction, unauthorized user")
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req model.PostActionIntegrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		p.API.LogError("handleFindTimeAction, failed to decode payload", "Error", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	channelID := getString("channel_id", req.Context)
+	if _, appErr := p.API.GetChannelMember(channelID, userID); appErr != nil {
+		p.API.LogError("handleFindTimeAction, failed to get channel member", "UserID", userID, "Error", appErr.Message)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	startAt, err := strconv.ParseInt(getString("start_at", req.Context), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid start time", http.StatusBadRequest)
+		return
+	}
+	minutes, err := strconv.Atoi(getString("duration", req.Context))
+	if err != nil || minutes <= 0 {
+		http.Error(w, "invalid duration", http.StatusBadRequest)
+		return
+	}
+
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil {
+		p.API.LogError("handleFindTimeAction, failed to get user", "UserID", userID, "Error", appErr.Message)
+		http.Error(w, appErr.Error(), appErr.StatusCode)
+		return
+	}
+
+	invitees := &meetingInvitees{Guests: getStrings("guests", req.Context)}
+	if userIDs := getStrings("user_ids", req.Context); len(userIDs) > 0 {
+		invitees.Users, appErr = p.API.GetUsersByIds(userIDs)
+		if appErr != nil {
+			p.API.LogError("handleFindTimeAction, failed to get invitees", "Error", appErr.Message)
+			http.Error(w, appErr.Error(), appErr.StatusCode)
+			return
+		}
+	}
+
+	response := &model.PostActionIntegrationResponse{}
+	_, _, err = p.postMeeting(user, meetingOptions{
+		ChannelID: channelID,
+		RootID:    getString("root_id", req.Context),
+		Invitees:  invitees,
+		Settings: meetingSettings{
+			StartAt:  time.UnixMilli(startAt),
+			Duration: time.Duration(minutes) * time.Minute,
+		},
+	})
+	if err != nil {
+		p.API.LogError("handleFindTimeAction, failed to post meeting", "UserID", userID, "Error", err.Error())
+		response.EphemeralText = "Failed to schedule the meeting. Please try again."
+	} else {
+		p.API.DeleteEphemeralPost(userID, req.PostId)
+		p.trackMeetingStart(userID, telemetryStartSourceFindTime)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(response); err != nil {
+		p.API.LogWarn("failed to write response", "error", err.Error())
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFindTimeArgs(t *testing.T) {
+	for _, tc := range []struct {
+		args     []string
+		duration time.Duration
+		window   time.Duration
+		err      string
+	}{
+		{args: []string{"30m"}, duration: 30 * time.Minute, window: defaultFindTimeWindow},
+		{args: []string{"45"}, duration: 45 * time.Minute, window: defaultFindTimeWindow},
+		{args: []string{"1h", "within", "3", "days"}, duration: time.Hour, window: 3 * 24 * time.Hour},
+		{args: []string{"30m", "within", "1", "week"}, duration: 30 * time.Minute, window: 7 * 24 * time.Hour},
+		{args: []string{"30m", "within", "2d"}, duration: 30 * time.Minute, window: 2 * 24 * time.Hour},
+		{args: []string{"30m", "within", "36h"}, duration: 30 * time.Minute, window: 36 * time.Hour},
+		{args: []string{"30m", "within", "5"}, duration: 30 * time.Minute, window: 5 * 24 * time.Hour},
+		{args: []string{}, err: "the meeting duration is missing, such as 30m"},
+		{args: []string{"soon"}, err: "invalid duration soon"},
+		{args: []string{"30m", "tomorrow"}, err: "unexpected tomorrow, use within followed by a number of days"},
+		{args: []string{"30m", "within", "3", "months"}, err: "invalid window 3 months"},
+		{args: []string{"2h", "within", "1h"}, err: "the window to search must be longer than the meeting"},
+		{args: []string{"30m", "within", "60", "days"}, err: "the window to search must be at most 30 days"},
+	} {
+		duration, window, err := parseFindTimeArgs(tc.args)
+		if tc.err != "" {
+			require.EqualError(t, err, tc.err, tc.args)
+			continue
+		}
+		require.NoError(t, err, tc.args)
+		require.Equal(t, tc.duration, duration, tc.args)
+		require.Equal(t, tc.window, window, tc.args)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+const (
+	tokenHealthCheckJobKey   = "token_health_check"
+	tokenHealthCheckInterval = 24 * time.Hour
+)
+
+// checkTokenHealth refreshes every stored token, flags the connections Microsoft no longer
+// accepts and asks the affected users to reconnect before they next need to start a meeting.
+func (p *Plugin) checkTokenHealth() {
+	userIDs, err := p.listConnectedUserIDs()
+	if err != nil {
+		p.API.LogError("checkTokenHealth, failed to list connected users", "error", err.Error())
+		return
+	}
+
+	for _, userID := range userIDs {
+		if err = p.checkUserTokenHealth(userID); err != nil {
+			p.API.LogWarn("checkTokenHealth, failed to check user token", "UserID", userID, "error", err.Error())
+		}
+	}
+}
+
+func (p *Plugin) checkUserTokenHealth(userID string) error {
+	userInfo, data, err := p.getStoredUserInfo(userID)
+	if err != nil {
+		return err
+	}
+
+	conf, err := p.getOAuthConfig()
+	if err != nil {
+		return err
+	}
+
+	// Force a refresh so revoked or expired refresh tokens are detected now.
+	expired := *userInfo.OAuthToken
+	expired.Expiry = time.Now().Add(-time.Minute)
+	tok, err := conf.TokenSource(p.getOAuthContext(conf.Endpoint.TokenURL), &expired).Token()
+	if err != nil {
+		var retrieveErr *oauth2.RetrieveError
+		if !errors.As(err, &retrieveErr) {
+			// Transient failures, such as network errors, don't mean the connection is broken.
+			return err
+		}
+
+		if userInfo.ConnectionBroken {
+			return nil
+		}
+
+		userInfo.ConnectionBroken = true
+		stored, storeErr := p.compareAndStoreUserInfo(userInfo, data)
+		if storeErr != nil || !stored {
+			// The user reconnected while the token was being checked.
+			return storeErr
+		}
+
+		return p.postReconnect(userID)
+	}
+
+	userInfo.OAuthToken = tok
+	userInfo.ConnectionBroken = false
+	_, err = p.compareAndStoreUserInfo(userInfo, data)
+	return err
+}
+
+// postReconnect sends the user a direct message from the bot with a link to reconnect their
+// Microsoft account.
+func (p *Plugin) postReconnect(userID string) error {
+	channel, appErr := p.API.GetDirectChannel(userID, p.botUserID)
+	if appErr != nil {
+		return appErr
+	}
+
+	// the user state will be needed later while connecting the user to MS teams meeting via OAuth
+	if err := p.StoreStateIfAbsent(userID, channel.Id, true); err != nil {
+		return err
+	}
+
+	oauthMsg, err := p.getOauthMessage(channel.Id)
+	if err != nil {
+		return err
+	}
+
+	post := &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: channel.Id,
+		Message:   fmt.Sprintf("Your connection to MS Teams Meetings has expired or was revoked by Microsoft. %s", oauthMsg),
+	}
+	if _, appErr = p.API.CreatePost(post); appErr != nil {
+		return appErr
+	}
+
+	return nil
+}
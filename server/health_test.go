@@ -0,0 +1,154 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestCheckUserTokenHealth(t *testing.T) {
+	userInfo := &UserInfo{
+		UserID:     "user-id",
+		RemoteID:   "remote-id",
+		OAuthToken: &oauth2.Token{AccessToken: "access-token", RefreshToken: "refresh-token", Expiry: time.Now().Add(time.Hour)},
+	}
+	data, err := userInfo.EncryptedJSON(nil)
+	require.NoError(t, err)
+
+	isBroken := func(broken bool) interface{} {
+		return mock.MatchedBy(func(stored []byte) bool {
+			info, decryptErr := DecryptUserInfo(stored, nil)
+			return decryptErr == nil && info.ConnectionBroken == broken
+		})
+	}
+
+	for _, testCase := range []struct {
+		description     string
+		tokenResponse   func(r *http.Request) (*http.Response, error)
+		storedInfo      bool
+		expectStore     bool
+		expectBroken    bool
+		expectReconnect bool
+		expectError     bool
+	}{
+		{
+			description: "refreshed",
+			tokenResponse: tokenResponse(http.StatusOK,
+				`{"access_token":"new-access-token","refresh_token":"new-refresh-token","token_type":"Bearer","expires_in":3600}`),
+			storedInfo:  true,
+			expectStore: true,
+		},
+		{
+			description: "refreshed after a reconnect",
+			tokenResponse: tokenResponse(http.StatusOK,
+				`{"access_token":"new-access-token","refresh_token":"new-refresh-token","token_type":"Bearer","expires_in":3600}`),
+			expectStore: true,
+		},
+		{
+			description: "revoked",
+			tokenResponse: tokenResponse(http.StatusBadRequest,
+				`{"error":"invalid_grant","error_description":"AADSTS50173: The provided grant has expired due to it being revoked."}`),
+			storedInfo:      true,
+			expectStore:     true,
+			expectBroken:    true,
+			expectReconnect: true,
+		},
+		{
+			description: "revoked after a reconnect",
+			tokenResponse: tokenResponse(http.StatusBadRequest,
+				`{"error":"invalid_grant","error_description":"AADSTS50173: The provided grant has expired due to it being revoked."}`),
+			expectStore:  true,
+			expectBroken: true,
+		},
+		{
+			description: "network error",
+			tokenResponse: func(r *http.Request) (*http.Response, error) {
+				return nil, errors.New("connection reset by peer")
+			},
+			expectError: true,
+		},
+	} {
+		t.Run(testCase.description, func(t *testing.T) {
+			mockTransport(t, roundTripFunc(testCase.tokenResponse))
+
+			p := &Plugin{botUserID: "bot-id"}
+			p.setConfiguration(&configuration{OAuth2Authority: "tenant-id", OAuth2ClientID: "client-id", OAuth2ClientSecret: "secret"})
+			api := &plugintest.API{}
+			api.On("GetConfig").Return(&model.Config{
+				ServiceSettings: model.ServiceSettings{
+					SiteURL: model.NewString("https://example.com"),
+				},
+			})
+			api.On("KVGet", tokenKey+"user-id").Return(data, nil)
+			api.On("KVCompareAndSet", tokenKey+"user-id", data, isBroken(testCase.expectBroken)).Return(testCase.storedInfo, nil)
+			api.On("KVSet", tokenKeyByRemoteID+"remote-id", isBroken(testCase.expectBroken)).Return(nil)
+			api.On("GetDirectChannel", "user-id", "bot-id").Return(&model.Channel{Id: "dm-id"}, nil)
+			api.On("KVCompareAndSet", getOAuthUserStateKey("user-id"), []byte(nil), []byte(getOAuthUserStateKey("user-id")+"_dm-id_true")).Return(false, nil)
+			api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{}, nil)
+			p.SetAPI(api)
+
+			err := p.checkUserTokenHealth("user-id")
+			if testCase.expectError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			if testCase.expectStore {
+				api.AssertCalled(t, "KVCompareAndSet", tokenKey+"user-id", data, isBroken(testCase.expectBroken))
+			} else {
+				api.AssertNotCalled(t, "KVCompareAndSet", tokenKey+"user-id", mock.Anything, mock.Anything)
+			}
+			if testCase.storedInfo {
+				api.AssertCalled(t, "KVSet", tokenKeyByRemoteID+"remote-id", isBroken(testCase.expectBroken))
+			} else {
+				api.AssertNotCalled(t, "KVSet", tokenKeyByRemoteID+"remote-id", mock.Anything)
+			}
+			if testCase.expectReconnect {
+				api.AssertCalled(t, "CreatePost", mock.AnythingOfType("*model.Post"))
+			} else {
+				api.AssertNotCalled(t, "CreatePost", mock.Anything)
+			}
+		})
+	}
+}
+
+func TestPostReconnectKeepsPendingState(t *testing.T) {
+	p := &Plugin{botUserID: "bot-id"}
+	p.setConfiguration(&configuration{})
+	api := &plugintest.API{}
+	api.On("GetConfig").Return(&model.Config{
+		ServiceSettings: model.ServiceSettings{
+			SiteURL: model.NewString("https://example.com"),
+		},
+	})
+	api.On("GetDirectChannel", "user-id", "bot-id").Return(&model.Channel{Id: "dm-id"}, nil)
+	// A pending connection started from another channel is kept rather than overwritten.
+	api.On("KVCompareAndSet", getOAuthUserStateKey("user-id"), []byte(nil), []byte(getOAuthUserStateKey("user-id")+"_dm-id_true")).Return(false, nil).Once()
+	api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{}, nil).Once()
+	p.SetAPI(api)
+
+	require.NoError(t, p.postReconnect("user-id"))
+	api.AssertNotCalled(t, "KVSet", mock.Anything, mock.Anything)
+	api.AssertExpectations(t)
+}
+
+// tokenResponse returns a transport answering every request with the given token endpoint
+// response.
+func tokenResponse(statusCode int, body string) func(r *http.Request) (*http.Response, error) {
+	return func(r *http.Request) (*http.Response, error) {
+		w := httptest.NewRecorder()
+		writeGraphJSON(w, statusCode, body)
+		res := w.Result()
+		res.Request = r
+		return res, nil
+	}
+}
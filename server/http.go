@@ -4,10 +4,11 @@
 package main
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin"
@@ -17,8 +18,16 @@ import (
 const (
 	postTypeStarted = "STARTED"
 	postTypeConfirm = "RECENTLY_CREATED"
+	postTypeEnded   = "ENDED"
 
 	msteamsProviderName = "Microsoft Teams Meetings"
+
+	autocompleteUsersLimit = 10
+
+	confirmMeetingActionPath = "/api/v1/meetings/confirm"
+	// Post action IDs must be alphanumeric.
+	confirmActionJoin   = "join"
+	confirmActionCreate = "create"
 )
 
 func (p *Plugin) ServeHTTP(_ *plugin.Context, w http.ResponseWriter, r *http.Request) {
@@ -32,11 +41,32 @@ func (p *Plugin) ServeHTTP(_ *plugin.Context, w http.ResponseWriter, r *http.Req
 	switch path := r.URL.Path; path {
 	case "/api/v1/meetings":
 		p.handleStartMeeting(w, r)
+	case "/api/v1/meetings/post":
+		p.handleStartPostMeeting(w, r)
+	case newMeetingDialogPath:
+		p.handleNewMeetingDialog(w, r)
+	case newMeetingDialogSubmitPath:
+		p.handleSubmitNewMeetingDialog(w, r)
+	case meetingCardActionPath:
+		p.handleMeetingCardAction(w, r)
+	case confirmMeetingActionPath:
+		p.handleConfirmMeetingAction(w, r)
+	case findTimeActionPath:
+		p.handleFindTimeAction(w, r)
+	case notificationsPath:
+		p.handleNotifications(w, r)
+	case "/api/v1/autocomplete/users":
+		p.handleAutocompleteUsers(w, r)
 	case "/oauth2/connect":
 		p.connectUser(w, r)
 	case "/oauth2/complete":
 		p.completeUserOAuth(w, r)
 	default:
+		if match := meetingPathPattern.FindStringSubmatch(path); match != nil && r.Method == http.MethodPatch {
+			meetingID, _ := strconv.Atoi(match[1])
+			p.handleUpdateMeeting(w, r, meetingID)
+			return
+		}
 		http.NotFound(w, r)
 	}
 }
@@ -70,7 +100,12 @@ func (p *Plugin) connectUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	url := conf.AuthCodeURL(state, oauth2.AccessTypeOffline)
+	opts := []oauth2.AuthCodeOption{oauth2.AccessTypeOffline}
+	if user, appErr := p.API.GetUser(userID); appErr == nil {
+		opts = append(opts, p.applySSOAccountHints(conf, user)...)
+	}
+
+	url := conf.AuthCodeURL(state, opts...)
 	http.Redirect(w, r, url, http.StatusFound)
 }
 
@@ -82,13 +117,13 @@ func (p *Plugin) completeUserOAuth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx := context.Background()
 	conf, err := p.getOAuthConfig()
 	if err != nil {
 		p.API.LogError("completeUserOAuth, failed to get oauth config", "Error", err.Error())
 		http.Error(w, "error in oauth config", http.StatusInternalServerError)
 		return
 	}
+	ctx := p.getOAuthContext(conf.Endpoint.TokenURL)
 
 	code := r.URL.Query().Get("code")
 	if len(code) == 0 {
@@ -159,12 +194,49 @@ func (p *Plugin) completeUserOAuth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil {
+		p.API.LogError("complete oauth, error getting MM user", "error", appErr.Error())
+		http.Error(w, appErr.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err = p.verifySSOAccount(user, *remoteUser.ID); err != nil {
+		p.API.LogWarn("complete oauth, rejected Microsoft account", "UserID", userID, "RemoteID", *remoteUser.ID, "error", err.Error())
+		http.Error(w, "The Microsoft account does not match the account you use to sign in to Mattermost. Please sign in with that account and try again.", http.StatusForbidden)
+		return
+	}
+
+	if err = p.verifyRemoteAccount(user, remoteUser); err != nil {
+		p.API.LogWarn("complete oauth, rejected Microsoft account", "UserID", userID, "RemoteID", *remoteUser.ID, "error", err.Error())
+		http.Error(w, fmt.Sprintf("Unable to connect this Microsoft account: %s.", err.Error()), http.StatusForbidden)
+		return
+	}
+
+	tenantID := getTenantIDFromToken(tok)
+	if tenantID == "" && !p.getConfiguration().isMultiTenant() {
+		tenantID = p.getConfiguration().OAuth2Authority
+	}
+
+	if err = p.verifyTenant(tenantID); err != nil {
+		p.API.LogWarn("complete oauth, rejected Microsoft tenant", "UserID", userID, "TenantID", tenantID, "error", err.Error())
+		http.Error(w, "Your Microsoft organization is not allowed to connect to this Mattermost server.", http.StatusForbidden)
+		return
+	}
+
+	if linkedUserID := p.getRemoteUserOwner(*remoteUser.ID); linkedUserID != "" && linkedUserID != userID {
+		p.API.LogWarn("complete oauth, Microsoft account already linked to another user", "UserID", userID, "LinkedUserID", linkedUserID, "RemoteID", *remoteUser.ID)
+		http.Error(w, "This Microsoft account is already connected to another Mattermost user.", http.StatusConflict)
+		return
+	}
+
 	userInfo := &UserInfo{
 		UserID:     userID,
 		OAuthToken: tok,
 		Email:      *remoteUser.Mail,
 		RemoteID:   *remoteUser.ID,
 		UPN:        *remoteUser.UserPrincipalName,
+		TenantID:   tenantID,
 	}
 
 	err = p.StoreUserInfo(userInfo)
@@ -198,14 +270,7 @@ func (p *Plugin) completeUserOAuth(w http.ResponseWriter, r *http.Request) {
 
 		p.API.SendEphemeralPost(userID, post)
 	} else {
-		user, appErr := p.API.GetUser(userID)
-		if appErr != nil {
-			p.API.LogError("complete oauth, error getting MM user", "error", appErr.Error())
-			http.Error(w, appErr.Error(), http.StatusInternalServerError)
-			return
-		}
-
-		_, _, err = p.postMeeting(user, channelID, "")
+		_, _, err = p.postMeeting(user, meetingOptions{ChannelID: channelID})
 		if err != nil {
 			p.API.LogDebug("complete oauth, error posting meeting", "error", err.Error())
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -219,9 +284,13 @@ func (p *Plugin) completeUserOAuth(w http.ResponseWriter, r *http.Request) {
 
 type startMeetingRequest struct {
 	ChannelID string `json:"channel_id"`
-	Personal  bool   `json:"personal"`
-	Topic     string `json:"topic"`
-	MeetingID int    `json:"meeting_id"`
+	RootID    string `json:"root_id"`
+	// Personal meetings are posted in the channel, and default to true when omitted. Otherwise
+	// the meeting is only sent to the requester.
+	Personal *bool  `json:"personal"`
+	Topic    string `json:"topic"`
+	// MeetingID posts an existing meeting from the registry instead of creating a new one.
+	MeetingID int `json:"meeting_id"`
 }
 
 func (p *Plugin) handleStartMeeting(w http.ResponseWriter, r *http.Request) {
@@ -254,8 +323,23 @@ func (p *Plugin) handleStartMeeting(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.MeetingID != 0 {
+		_, meeting, repostErr := p.repostMeeting(user, req.ChannelID, req.RootID, req.MeetingID)
+		if repostErr != nil {
+			p.API.LogError("handleStartMeeting, failed to repost meeting", "UserID", userID, "MeetingID", req.MeetingID, "Error", repostErr.Error())
+			http.Error(w, repostErr.Error(), http.StatusBadRequest)
+			return
+		}
+
+		_, err = w.Write([]byte(fmt.Sprintf(`{"meeting_url": "%s"}`, meeting.JoinURL)))
+		if err != nil {
+			p.API.LogWarn("failed to write response", "error", err.Error())
+		}
+		return
+	}
+
 	if r.URL.Query().Get("force") == "" {
-		recentMeeting, recentMeetingURL, creatorName, provider, cpmErr := p.checkPreviousMessages(req.ChannelID)
+		recentMeeting, recentMeetingURL, creatorName, provider, cpmErr := p.checkPreviousMessages(req.ChannelID, req.RootID)
 		if cpmErr != nil {
 			p.API.LogError("handleStartMeeting, error occurred while checking previous messages in channel", "ChannelID", req.ChannelID, "Error", cpmErr.Message)
 			http.Error(w, cpmErr.Error(), cpmErr.StatusCode)
@@ -267,13 +351,13 @@ func (p *Plugin) handleStartMeeting(w http.ResponseWriter, r *http.Request) {
 			if err != nil {
 				p.API.LogWarn("failed to write response", "error", err.Error())
 			}
-			p.postConfirmCreateOrJoin(recentMeetingURL, req.ChannelID, req.Topic, userID, creatorName, provider)
+			p.postConfirmCreateOrJoin(recentMeetingURL, req.ChannelID, req.RootID, "", req.Topic, userID, creatorName, provider)
 			p.trackMeetingDuplication(userID)
 			return
 		}
 	}
 
-	_, authErr := p.authenticateAndFetchUser(userID, req.ChannelID)
+	remoteUser, authErr := p.authenticateOrganizer(userID, req.ChannelID)
 	if authErr != nil {
 		if _, err = w.Write([]byte(`{"meeting_url": ""}`)); err != nil {
 			p.API.LogWarn("failed to write response", "error", err.Error())
@@ -293,7 +377,13 @@ func (p *Plugin) handleStartMeeting(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, meeting, err := p.postMeeting(user, req.ChannelID, req.Topic)
+	_, meeting, err := p.postMeeting(user, meetingOptions{
+		ChannelID:  req.ChannelID,
+		RootID:     req.RootID,
+		Topic:      req.Topic,
+		Private:    req.Personal != nil && !*req.Personal,
+		RemoteUser: remoteUser,
+	})
 	if err != nil {
 		p.API.LogError("handleStartMeeting, failed to post meeting", "UserID", user.Id, "Error", err.Error())
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -310,3 +400,299 @@ func (p *Plugin) handleStartMeeting(w http.ResponseWriter, r *http.Request) {
 		p.API.LogWarn("failed to write response", "error", err.Error())
 	}
 }
+
+type startPostMeetingRequest struct {
+	PostID string `json:"post_id"`
+	Topic  string `json:"topic"`
+}
+
+// handleStartPostMeeting starts a meeting about an existing post, posting the meeting in the
+// post's thread and inviting its author.
+func (p *Plugin) handleStartPostMeeting(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		p.API.LogError("handleStartPostMeeting, unauthorized user")
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req startPostMeetingRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		p.API.LogError("handleStartPostMeeting, failed to decode start meeting payload", "Error", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	post, appErr := p.API.GetPost(req.PostID)
+	if appErr != nil {
+		p.API.LogError("handleStartPostMeeting, failed to get post", "PostID", req.PostID, "Error", appErr.Message)
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	if !p.API.HasPermissionToChannel(userID, post.ChannelId, model.PermissionReadChannel) {
+		p.API.LogError("handleStartPostMeeting, user cannot read post", "UserID", userID, "PostID", post.Id)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil {
+		p.API.LogError("handleStartPostMeeting, failed to get user", "UserID", userID, "Error", appErr.Message)
+		http.Error(w, appErr.Error(), appErr.StatusCode)
+		return
+	}
+
+	topic := req.Topic
+	if topic == "" {
+		topic = getPostTopic(post.Message)
+	}
+
+	rootID := post.RootId
+	if rootID == "" {
+		rootID = post.Id
+	}
+
+	if r.URL.Query().Get("force") == "" {
+		recentMeeting, recentMeetingURL, creatorName, provider, cpmErr := p.checkPreviousMessages(post.ChannelId, rootID)
+		if cpmErr != nil {
+			p.API.LogError("handleStartPostMeeting, error occurred while checking previous messages in thread", "RootID", rootID, "Error", cpmErr.Message)
+			http.Error(w, cpmErr.Error(), cpmErr.StatusCode)
+			return
+		}
+
+		if recentMeeting {
+			_, err = w.Write([]byte(`{"meeting_url": ""}`))
+			if err != nil {
+				p.API.LogWarn("failed to write response", "error", err.Error())
+			}
+			p.postConfirmCreateOrJoin(recentMeetingURL, post.ChannelId, rootID, post.Id, topic, userID, creatorName, provider)
+			p.trackMeetingDuplication(userID)
+			return
+		}
+	}
+
+	remoteUser, authErr := p.authenticateOrganizer(userID, post.ChannelId)
+	if authErr != nil {
+		if _, err = w.Write([]byte(`{"meeting_url": ""}`)); err != nil {
+			p.API.LogWarn("failed to write response", "error", err.Error())
+		}
+
+		if _, err = p.postConnect(post.ChannelId, userID); err != nil {
+			p.API.LogWarn("failed to create connect post", "error", err.Error())
+			return
+		}
+
+		// the user state will be needed later while connecting the user to MS teams meeting via OAuth
+		if _, err = p.StoreState(userID, post.ChannelId, false); err != nil {
+			p.API.LogWarn("failed to store user state", "error", err.Error())
+		}
+
+		return
+	}
+
+	_, meeting, err := p.postMeeting(user, meetingOptions{
+		ChannelID:  post.ChannelId,
+		RootID:     rootID,
+		Topic:      topic,
+		Invitees:   p.getPostMeetingInvitees(userID, post),
+		RemoteUser: remoteUser,
+	})
+	if err != nil {
+		p.API.LogError("handleStartPostMeeting, failed to post meeting", "UserID", user.Id, "Error", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	p.trackMeetingStart(userID, telemetryStartSourcePost)
+
+	_, err = w.Write([]byte(fmt.Sprintf(`{"meeting_url": "%s"}`, *meeting.JoinURL)))
+	if err != nil {
+		p.API.LogWarn("failed to write response", "error", err.Error())
+	}
+}
+
+// getPostMeetingInvitees invites the author of the post a meeting is started about, unless they
+// start the meeting themselves.
+func (p *Plugin) getPostMeetingInvitees(userID string, post *model.Post) *meetingInvitees {
+	invitees := &meetingInvitees{}
+	if author, appErr := p.API.GetUser(post.UserId); appErr == nil && !author.IsBot && author.Id != userID {
+		invitees.Users = append(invitees.Users, author)
+	}
+	return invitees
+}
+
+// handleConfirmMeetingAction answers the buttons of the post asking whether to join a recent
+// meeting or to create a new one anyway.
+func (p *Plugin) handleConfirmMeetingAction(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		p.API.LogError("handleConfirmMeetingAction, unauthorized user")
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req model.PostActionIntegrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		p.API.LogError("handleConfirmMeetingAction, failed to decode payload", "Error", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	channelID := getString("channel_id", req.Context)
+	rootID := getString("root_id", req.Context)
+	meetingURL := getString("meeting_link", req.Context)
+
+	if _, appErr := p.API.GetChannelMember(channelID, userID); appErr != nil {
+		p.API.LogError("handleConfirmMeetingAction, failed to get channel member", "UserID", userID, "Error", appErr.Message)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	response := &model.PostActionIntegrationResponse{}
+	switch getString("action", req.Context) {
+	case confirmActionJoin:
+		response.Update = &model.Post{
+			Id:        req.PostId,
+			UserId:    p.botUserID,
+			ChannelId: channelID,
+			RootId:    rootID,
+			Message:   fmt.Sprintf("Join the existing meeting at [this link](%s).", meetingURL),
+		}
+
+	case confirmActionCreate:
+		// Meetings started about a post keep inviting its author.
+		var invitees *meetingInvitees
+		if postID := getString("post_id", req.Context); postID != "" {
+			post, postErr := p.API.GetPost(postID)
+			if postErr != nil || !p.API.HasPermissionToChannel(userID, post.ChannelId, model.PermissionReadChannel) {
+				p.API.LogError("handleConfirmMeetingAction, user cannot read post", "UserID", userID, "PostID", postID)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			invitees = p.getPostMeetingInvitees(userID, post)
+		}
+
+		p.API.DeleteEphemeralPost(userID, req.PostId)
+
+		user, appErr := p.API.GetUser(userID)
+		if appErr != nil {
+			p.API.LogError("handleConfirmMeetingAction, failed to get user", "UserID", userID, "Error", appErr.Message)
+			http.Error(w, appErr.Error(), appErr.StatusCode)
+			return
+		}
+
+		remoteUser, authErr := p.authenticateOrganizer(userID, channelID)
+		if authErr != nil {
+			if _, err := p.postConnect(channelID, userID); err != nil {
+				p.API.LogWarn("failed to create connect post", "error", err.Error())
+			}
+
+			// the user state will be needed later while connecting the user to MS teams meeting via OAuth
+			if _, err := p.StoreState(userID, channelID, false); err != nil {
+				p.API.LogWarn("failed to store user state", "error", err.Error())
+			}
+			break
+		}
+
+		_, _, err := p.postMeeting(user, meetingOptions{
+			ChannelID:  channelID,
+			RootID:     rootID,
+			Topic:      getString("topic", req.Context),
+			Invitees:   invitees,
+			RemoteUser: remoteUser,
+		})
+		if err != nil {
+			p.API.LogError("handleConfirmMeetingAction, failed to post meeting", "UserID", userID, "Error", err.Error())
+			response.EphemeralText = "Failed to create the meeting. Please try again."
+			break
+		}
+
+		p.trackMeetingStart(userID, telemetryStartSourceConfirmation)
+		p.trackMeetingForced(userID)
+
+	default:
+		http.Error(w, "unknown action", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		p.API.LogWarn("failed to write response", "error", err.Error())
+	}
+}
+
+// handleAutocompleteUsers suggests users to invite while typing the start command.
+func (p *Plugin) handleAutocompleteUsers(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		p.API.LogError("handleAutocompleteUsers, unauthorized user")
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	words := strings.Fields(query.Get("user_input"))
+	term := ""
+	if len(words) > 0 {
+		term = strings.TrimPrefix(words[len(words)-1], "@")
+	}
+
+	// Only the members of a team the user belongs to are suggested, as the server doesn't
+	// apply the user's view restrictions to searches made by plugins.
+	items := []model.AutocompleteListItem{}
+	teamID := query.Get("team_id")
+	if teamID == "" {
+		p.writeAutocompleteItems(w, items)
+		return
+	}
+	if !p.isActiveTeamMember(teamID, userID) {
+		p.writeAutocompleteItems(w, items)
+		return
+	}
+
+	users, appErr := p.API.SearchUsers(&model.UserSearch{
+		Term:   term,
+		TeamId: teamID,
+		Limit:  autocompleteUsersLimit,
+	})
+	if appErr != nil {
+		p.API.LogError("handleAutocompleteUsers, failed to search users", "Error", appErr.Error())
+		http.Error(w, appErr.Error(), appErr.StatusCode)
+		return
+	}
+
+	nameFormat := model.ShowUsername
+	if showFullName := p.API.GetConfig().PrivacySettings.ShowFullName; showFullName == nil || *showFullName {
+		nameFormat = model.ShowFullName
+	}
+
+	for _, user := range users {
+		if user.IsBot || user.Id == userID {
+			continue
+		}
+		items = append(items, model.AutocompleteListItem{
+			Item:     "@" + user.Username,
+			HelpText: user.GetDisplayName(nameFormat),
+		})
+	}
+
+	p.writeAutocompleteItems(w, items)
+}
+
+// isActiveTeamMember reports whether the user belongs to the team.
+func (p *Plugin) isActiveTeamMember(teamID, userID string) bool {
+	if teamID == "" {
+		return false
+	}
+	member, appErr := p.API.GetTeamMember(teamID, userID)
+	return appErr == nil && member.DeleteAt == 0
+}
+
+func (p *Plugin) writeAutocompleteItems(w http.ResponseWriter, items []model.AutocompleteListItem) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(items); err != nil {
+		p.API.LogWarn("failed to write response", "error", err.Error())
+	}
+}
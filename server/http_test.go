@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/mattermost/mattermost/server/public/pluginapi/experimental/telemetry"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestHandleAutocompleteUsers(t *testing.T) {
+	users := []*model.User{
+		{Id: "user-id", Username: "me"},
+		{Id: "alice-id", Username: "alice", FirstName: "Alice", LastName: "Smith"},
+		{Id: "bot-id", Username: "bot", IsBot: true},
+	}
+
+	for _, testCase := range []struct {
+		description  string
+		teamID       string
+		member       *model.TeamMember
+		showFullName bool
+		expected     []model.AutocompleteListItem
+	}{
+		{
+			description:  "team member",
+			teamID:       "team-id",
+			member:       &model.TeamMember{TeamId: "team-id", UserId: "user-id"},
+			showFullName: true,
+			expected:     []model.AutocompleteListItem{{Item: "@alice", HelpText: "Alice Smith"}},
+		},
+		{
+			description: "full names hidden",
+			teamID:      "team-id",
+			member:      &model.TeamMember{TeamId: "team-id", UserId: "user-id"},
+			expected:    []model.AutocompleteListItem{{Item: "@alice", HelpText: "alice"}},
+		},
+		{
+			description: "left the team",
+			teamID:      "team-id",
+			member:      &model.TeamMember{TeamId: "team-id", UserId: "user-id", DeleteAt: 1},
+			expected:    []model.AutocompleteListItem{},
+		},
+		{
+			description: "not a team member",
+			teamID:      "team-id",
+			expected:    []model.AutocompleteListItem{},
+		},
+		{
+			description: "no team",
+			expected:    []model.AutocompleteListItem{},
+		},
+	} {
+		t.Run(testCase.description, func(t *testing.T) {
+			p := &Plugin{}
+			api := &plugintest.API{}
+			if testCase.member != nil {
+				api.On("GetTeamMember", testCase.teamID, "user-id").Return(testCase.member, nil)
+			} else {
+				api.On("GetTeamMember", testCase.teamID, "user-id").Return(nil, model.NewAppError("GetTeamMember", "not_found", nil, "", http.StatusNotFound))
+			}
+			api.On("SearchUsers", &model.UserSearch{Term: "al", TeamId: testCase.teamID, Limit: autocompleteUsersLimit}).Return(users, nil)
+			api.On("GetConfig").Return(&model.Config{
+				PrivacySettings: model.PrivacySettings{ShowFullName: model.NewBool(testCase.showFullName)},
+			})
+			p.SetAPI(api)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/autocomplete/users?user_input=start+%40al&team_id="+testCase.teamID, nil)
+			r.Header.Set("Mattermost-User-Id", "user-id")
+			p.handleAutocompleteUsers(w, r)
+
+			require.Equal(t, http.StatusOK, w.Code)
+			items := []model.AutocompleteListItem{}
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&items))
+			require.Equal(t, testCase.expected, items)
+			if testCase.member == nil || testCase.member.DeleteAt != 0 {
+				api.AssertNotCalled(t, "SearchUsers", &model.UserSearch{Term: "al", TeamId: testCase.teamID, Limit: autocompleteUsersLimit})
+			}
+		})
+	}
+}
+
+func TestHandleConfirmMeetingAction(t *testing.T) {
+	actionContext := func(action, postID string) map[string]interface{} {
+		return map[string]interface{}{
+			"action":       action,
+			"channel_id":   "channel-id",
+			"root_id":      "root-id",
+			"post_id":      postID,
+			"meeting_link": "https://teams.microsoft.com/l/meetup-join/recent",
+			"topic":        "Weekly sync",
+		}
+	}
+
+	for _, testCase := range []struct {
+		description    string
+		action         string
+		postID         string
+		member         bool
+		expectStatus   int
+		expectCreate   bool
+		expectAttendee string
+	}{
+		{
+			description:  "not a channel member",
+			action:       confirmActionCreate,
+			expectStatus: http.StatusForbidden,
+		},
+		{
+			description:  "join",
+			action:       confirmActionJoin,
+			member:       true,
+			expectStatus: http.StatusOK,
+		},
+		{
+			description:  "create in a thread",
+			action:       confirmActionCreate,
+			member:       true,
+			expectStatus: http.StatusOK,
+			expectCreate: true,
+		},
+		{
+			description:    "create about a post",
+			action:         confirmActionCreate,
+			postID:         "root-id",
+			member:         true,
+			expectStatus:   http.StatusOK,
+			expectCreate:   true,
+			expectAttendee: "bob-remote-id",
+		},
+		{
+			description:  "unknown action",
+			action:       "unknown",
+			member:       true,
+			expectStatus: http.StatusBadRequest,
+		},
+	} {
+		t.Run(testCase.description, func(t *testing.T) {
+			created := ""
+			mockGraph(t, func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/beta/me":
+					writeGraphJSON(w, http.StatusOK, `{"id":"remote-id","userPrincipalName":"alice@example.com"}`)
+				case "/beta/users/remote-id/onlineMeetings":
+					require.Equal(t, http.MethodPost, r.Method)
+					body, err := io.ReadAll(r.Body)
+					require.NoError(t, err)
+					created = string(body)
+					writeGraphJSON(w, http.StatusCreated, `{"id":"meeting-id","subject":"Weekly sync","joinUrl":"https://teams.microsoft.com/l/meetup-join/new","joinWebUrl":"https://teams.microsoft.com/l/meetup-join/new"}`)
+				default:
+					t.Errorf("unexpected request to %s", r.URL.Path)
+				}
+			})
+
+			p := &Plugin{botUserID: "bot-id"}
+			p.setConfiguration(&configuration{OAuth2Authority: "tenant-id", OAuth2ClientID: "client-id", OAuth2ClientSecret: "secret"})
+			p.tracker = telemetry.NewTracker(nil, "", "", manifest.Id, "", "", telemetry.TrackerConfig{}, nil)
+			api := &plugintest.API{}
+			if testCase.member {
+				api.On("GetChannelMember", "channel-id", "user-id").Return(&model.ChannelMember{ChannelId: "channel-id", UserId: "user-id"}, nil)
+			} else {
+				api.On("GetChannelMember", "channel-id", "user-id").Return(nil, model.NewAppError("GetChannelMember", "not_found", nil, "", http.StatusNotFound))
+			}
+			api.On("LogError", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return()
+			api.On("DeleteEphemeralPost", "user-id", "confirm-post-id").Return()
+			api.On("GetUser", "user-id").Return(&model.User{Id: "user-id", Username: "alice"}, nil)
+			api.On("GetConfig").Return(&model.Config{
+				ServiceSettings: model.ServiceSettings{
+					SiteURL: model.NewString("https://example.com"),
+				},
+			})
+			data, err := (&UserInfo{
+				UserID:     "user-id",
+				RemoteID:   "remote-id",
+				UPN:        "alice@example.com",
+				OAuthToken: &oauth2.Token{AccessToken: "access-token", Expiry: time.Now().Add(time.Hour)},
+			}).EncryptedJSON(nil)
+			require.NoError(t, err)
+			api.On("KVGet", tokenKey+"user-id").Return(data, nil)
+			api.On("GetPost", "root-id").Return(&model.Post{Id: "root-id", ChannelId: "channel-id", UserId: "bob-id"}, nil)
+			api.On("HasPermissionToChannel", "user-id", "channel-id", model.PermissionReadChannel).Return(true)
+			api.On("HasPermissionToChannel", "user-id", "channel-id", model.PermissionCreatePost).Return(true)
+			api.On("GetUser", "bob-id").Return(&model.User{Id: "bob-id", Username: "bob"}, nil)
+			bobData, err := (&UserInfo{UserID: "bob-id", RemoteID: "bob-remote-id", UPN: "bob@example.com"}).EncryptedJSON(nil)
+			require.NoError(t, err)
+			api.On("KVGet", tokenKey+"bob-id").Return(bobData, nil)
+			api.On("GetChannel", "channel-id").Return(&model.Channel{Id: "channel-id", Type: model.ChannelTypeOpen}, nil)
+			api.On("KVGet", meetingSequenceKey).Return(nil, model.NewAppError("KVGet", "unavailable", nil, "", http.StatusInternalServerError))
+			api.On("LogWarn", "postMeeting, failed to assign meeting number", "error", mock.AnythingOfType("string")).Return()
+			api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{Id: "meeting-post-id"}, nil)
+			p.SetAPI(api)
+
+			body, err := json.Marshal(&model.PostActionIntegrationRequest{
+				UserId:  "user-id",
+				PostId:  "confirm-post-id",
+				Context: actionContext(testCase.action, testCase.postID),
+			})
+			require.NoError(t, err)
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, confirmMeetingActionPath, bytes.NewReader(body))
+			r.Header.Set("Mattermost-User-Id", "user-id")
+			p.handleConfirmMeetingAction(w, r)
+
+			require.Equal(t, testCase.expectStatus, w.Code)
+			if testCase.expectStatus != http.StatusOK {
+				api.AssertNotCalled(t, "DeleteEphemeralPost", mock.Anything, mock.Anything)
+				api.AssertNotCalled(t, "CreatePost", mock.Anything)
+				return
+			}
+
+			response := &model.PostActionIntegrationResponse{}
+			require.NoError(t, json.NewDecoder(w.Body).Decode(response))
+			if !testCase.expectCreate {
+				require.NotNil(t, response.Update)
+				require.Equal(t, "root-id", response.Update.RootId)
+				api.AssertNotCalled(t, "CreatePost", mock.Anything)
+				return
+			}
+
+			api.AssertCalled(t, "DeleteEphemeralPost", "user-id", "confirm-post-id")
+			api.AssertCalled(t, "CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+				return post.ChannelId == "channel-id" && post.RootId == "root-id" &&
+					post.GetProp("meeting_link") == "https://teams.microsoft.com/l/meetup-join/new"
+			}))
+			require.Empty(t, response.EphemeralText)
+			if testCase.expectAttendee != "" {
+				require.Contains(t, created, testCase.expectAttendee)
+			} else {
+				require.NotContains(t, created, "bob-remote-id")
+			}
+		})
+	}
+}
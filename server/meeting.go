@@ -5,30 +5,45 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
 	msgraph "github.com/yaegashi/msgraph.go/beta"
 )
 
-func (c *Client) CreateMeeting(creator *UserInfo, attendeesIDs []*UserInfo, subject string) (*msgraph.OnlineMeeting, error) {
+// defaultMeetingDuration is how long meetings last when no duration is given.
+const defaultMeetingDuration = 1 * time.Hour
+
+// meetingSettings are the optional settings of a new online meeting.
+type meetingSettings struct {
+	// StartAt is when the meeting starts, now if zero.
+	StartAt time.Time
+	// Duration is how long the meeting lasts, defaultMeetingDuration if zero.
+	Duration time.Duration
+	// LobbyBypassScope is who skips the lobby, the organization's policy if empty.
+	LobbyBypassScope string
+}
+
+func (c *Client) CreateMeeting(creator *UserInfo, attendeesIDs []*UserInfo, subject string, settings meetingSettings) (*msgraph.OnlineMeeting, error) {
 	ctx := context.Background()
-	start := time.Now()
-	end := start.Add(1 * time.Hour)
+	start := settings.StartAt
+	if start.IsZero() {
+		start = time.Now()
+	}
+	duration := settings.Duration
+	if duration == 0 {
+		duration = defaultMeetingDuration
+	}
+	end := start.Add(duration)
 	attendees := []msgraph.MeetingParticipantInfo{}
 	if subject == "" {
 		subject = "MS Teams Meeting"
 	}
 	for _, attendee := range attendeesIDs {
-		attendees = append(attendees, msgraph.MeetingParticipantInfo{
-			Identity: &msgraph.IdentitySet{
-				User: &msgraph.Identity{
-					ID: &attendee.RemoteID,
-				},
-			},
-			Upn: &attendee.UPN,
-		})
+		attendees = append(attendees, newMeetingParticipant(attendee))
 	}
 
 	in := msgraph.OnlineMeeting{
@@ -47,6 +62,12 @@ func (c *Client) CreateMeeting(creator *UserInfo, attendeesIDs []*UserInfo, subj
 			Attendees: attendees,
 		},
 	}
+	if settings.LobbyBypassScope != "" {
+		// The beta client predates the lobby settings of online meetings.
+		in.SetAdditionalData("lobbyBypassSettings", map[string]interface{}{
+			"scope": settings.LobbyBypassScope,
+		})
+	}
 	out := msgraph.OnlineMeeting{}
 
 	err := c.builder.Users().ID(creator.RemoteID).OnlineMeetings().Request().JSONRequest(ctx, http.MethodPost, "", &in, &out)
@@ -55,3 +76,85 @@ func (c *Client) CreateMeeting(creator *UserInfo, attendeesIDs []*UserInfo, subj
 	}
 	return &out, nil
 }
+
+// newMeetingParticipant returns the meeting participant of a user.
+func newMeetingParticipant(attendee *UserInfo) msgraph.MeetingParticipantInfo {
+	upn := attendee.UPN
+	participant := msgraph.MeetingParticipantInfo{
+		Upn: &upn,
+	}
+	// Guests outside the directory are only known by their email.
+	if attendee.RemoteID != "" {
+		remoteID := attendee.RemoteID
+		participant.Identity = &msgraph.IdentitySet{
+			User: &msgraph.Identity{
+				ID: &remoteID,
+			},
+		}
+	}
+	return participant
+}
+
+// GetOnlineMeeting returns an online meeting of the organizer.
+func (c *Client) GetOnlineMeeting(organizerRemoteID, meetingRemoteID string) (*msgraph.OnlineMeeting, error) {
+	meeting, err := c.builder.Users().ID(organizerRemoteID).OnlineMeetings().ID(meetingRemoteID).Request().Get(context.Background())
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot get meeting")
+	}
+	return meeting, nil
+}
+
+// UpdateMeeting changes the given properties of an online meeting of the organizer.
+func (c *Client) UpdateMeeting(organizerRemoteID, meetingRemoteID string, in *msgraph.OnlineMeeting) error {
+	err := c.builder.Users().ID(organizerRemoteID).OnlineMeetings().ID(meetingRemoteID).Request().Update(context.Background(), in)
+	if err != nil {
+		return errors.Wrap(err, "cannot update meeting")
+	}
+	return nil
+}
+
+// AddMeetingAttendee invites a user to an online meeting of the organizer, returning false if
+// they were already invited.
+func (c *Client) AddMeetingAttendee(organizerRemoteID, meetingRemoteID string, attendee *UserInfo) (bool, error) {
+	meeting, err := c.GetOnlineMeeting(organizerRemoteID, meetingRemoteID)
+	if err != nil {
+		return false, err
+	}
+
+	attendees := []msgraph.MeetingParticipantInfo{}
+	if meeting.Participants != nil {
+		attendees = append(attendees, meeting.Participants.Attendees...)
+	}
+	for _, existing := range attendees {
+		if existing.Identity != nil && existing.Identity.User != nil && existing.Identity.User.ID != nil &&
+			*existing.Identity.User.ID == attendee.RemoteID {
+			return false, nil
+		}
+	}
+	attendees = append(attendees, newMeetingParticipant(attendee))
+
+	err = c.UpdateMeeting(organizerRemoteID, meetingRemoteID, &msgraph.OnlineMeeting{
+		Participants: &msgraph.MeetingParticipants{
+			Attendees: attendees,
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// GetMeetingByJoinURL finds an online meeting of the user by its join URL.
+func (c *Client) GetMeetingByJoinURL(ctx context.Context, userRemoteID, joinURL string) (*msgraph.OnlineMeeting, error) {
+	req := c.builder.Users().ID(userRemoteID).OnlineMeetings().Request()
+	req.Filter(fmt.Sprintf("JoinWebUrl eq '%s'", strings.ReplaceAll(joinURL, "'", "''")))
+	meetings, err := req.Get(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot get meeting")
+	}
+
+	if len(meetings) == 0 {
+		return nil, errors.New("meeting not found")
+	}
+	return &meetings[0], nil
+}
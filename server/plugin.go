@@ -11,8 +11,10 @@ import (
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin"
 	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
 	"github.com/mattermost/mattermost/server/public/pluginapi/experimental/telemetry"
 	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
 )
 
 const (
@@ -35,8 +37,26 @@ type Plugin struct {
 	// setConfiguration for usage.
 	configuration *configuration
 
+	// appTokenSourceLock synchronizes access to appTokenSource.
+	appTokenSourceLock sync.Mutex
+	// appTokenSource is the application token shared by the clients of getAppClient.
+	appTokenSource oauth2.TokenSource
+
 	telemetryClient telemetry.Client
 	tracker         telemetry.Tracker
+
+	// tokenHealthCheckJob periodically validates the stored OAuth2 tokens.
+	tokenHealthCheckJob *cluster.Job
+	// attendanceReportJob periodically posts the attendance reports of ended meetings.
+	attendanceReportJob *cluster.Job
+	// meetingArtifactsJob periodically posts the transcripts and recordings of meetings.
+	meetingArtifactsJob *cluster.Job
+	// meetingSubscriptionJob periodically renews the subscriptions to meeting notifications.
+	meetingSubscriptionJob *cluster.Job
+	// meetingStatusJob periodically sets and restores the custom status of users in meetings.
+	meetingStatusJob *cluster.Job
+	// presenceSyncJob periodically syncs the Teams presence of users into their status.
+	presenceSyncJob *cluster.Job
 }
 
 // OnActivate checks if the configurations is valid and ensures the bot account exists
@@ -85,10 +105,76 @@ func (p *Plugin) OnActivate() error {
 		p.API.LogWarn("telemetry client not started", "error", err.Error())
 	}
 
+	p.tokenHealthCheckJob, err = cluster.Schedule(p.API, tokenHealthCheckJobKey, cluster.MakeWaitForRoundedInterval(tokenHealthCheckInterval), p.checkTokenHealth)
+	if err != nil {
+		return errors.Wrap(err, "failed to schedule token health check job")
+	}
+
+	p.attendanceReportJob, err = cluster.Schedule(p.API, attendanceReportJobKey, cluster.MakeWaitForRoundedInterval(attendanceReportInterval), p.postPendingAttendanceReports)
+	if err != nil {
+		return errors.Wrap(err, "failed to schedule attendance report job")
+	}
+
+	p.meetingArtifactsJob, err = cluster.Schedule(p.API, meetingArtifactsJobKey, cluster.MakeWaitForRoundedInterval(meetingArtifactsInterval), p.postNewMeetingArtifacts)
+	if err != nil {
+		return errors.Wrap(err, "failed to schedule meeting artifacts job")
+	}
+
+	p.meetingSubscriptionJob, err = cluster.Schedule(p.API, meetingSubscriptionJobKey, cluster.MakeWaitForRoundedInterval(meetingSubscriptionInterval), p.renewMeetingSubscriptions)
+	if err != nil {
+		return errors.Wrap(err, "failed to schedule meeting subscription job")
+	}
+
+	p.meetingStatusJob, err = cluster.Schedule(p.API, meetingStatusJobKey, cluster.MakeWaitForRoundedInterval(meetingStatusInterval), p.updateMeetingStatuses)
+	if err != nil {
+		return errors.Wrap(err, "failed to schedule meeting status job")
+	}
+
+	p.presenceSyncJob, err = cluster.Schedule(p.API, presenceSyncJobKey, cluster.MakeWaitForRoundedInterval(presenceSyncInterval), p.syncPresences)
+	if err != nil {
+		return errors.Wrap(err, "failed to schedule presence sync job")
+	}
+
 	return nil
 }
 
 func (p *Plugin) OnDeactivate() error {
+	if p.tokenHealthCheckJob != nil {
+		if err := p.tokenHealthCheckJob.Close(); err != nil {
+			p.API.LogWarn("OnDeactivate: failed to close token health check job", "error", err.Error())
+		}
+	}
+
+	if p.attendanceReportJob != nil {
+		if err := p.attendanceReportJob.Close(); err != nil {
+			p.API.LogWarn("OnDeactivate: failed to close attendance report job", "error", err.Error())
+		}
+	}
+
+	if p.meetingArtifactsJob != nil {
+		if err := p.meetingArtifactsJob.Close(); err != nil {
+			p.API.LogWarn("OnDeactivate: failed to close meeting artifacts job", "error", err.Error())
+		}
+	}
+
+	if p.meetingSubscriptionJob != nil {
+		if err := p.meetingSubscriptionJob.Close(); err != nil {
+			p.API.LogWarn("OnDeactivate: failed to close meeting subscription job", "error", err.Error())
+		}
+	}
+
+	if p.meetingStatusJob != nil {
+		if err := p.meetingStatusJob.Close(); err != nil {
+			p.API.LogWarn("OnDeactivate: failed to close meeting status job", "error", err.Error())
+		}
+	}
+
+	if p.presenceSyncJob != nil {
+		if err := p.presenceSyncJob.Close(); err != nil {
+			p.API.LogWarn("OnDeactivate: failed to close presence sync job", "error", err.Error())
+		}
+	}
+
 	if p.telemetryClient != nil {
 		err := p.telemetryClient.Close()
 		if err != nil {
@@ -98,3 +184,20 @@ func (p *Plugin) OnDeactivate() error {
 
 	return nil
 }
+
+// UserHasBeenDeactivated removes the stored Microsoft credentials of deactivated users.
+// Microsoft offers no endpoint to revoke a single refresh token, so deleting our copy is the
+// only cleanup possible; the token expires on its own once it stops being used.
+func (p *Plugin) UserHasBeenDeactivated(_ *plugin.Context, user *model.User) {
+	if err := p.stopPresenceSync(user.Id); err != nil {
+		p.API.LogWarn("UserHasBeenDeactivated, failed to stop presence sync", "UserID", user.Id, "error", err.Error())
+	}
+
+	if _, err := p.GetUserInfo(user.Id); err != nil {
+		return
+	}
+
+	if err := p.RemoveUser(user.Id); err != nil {
+		p.API.LogWarn("UserHasBeenDeactivated, failed to remove user", "UserID", user.Id, "error", err.Error())
+	}
+}
@@ -1,19 +1,72 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
 	"github.com/pkg/errors"
 	msgraph "github.com/yaegashi/msgraph.go/beta"
 )
 
-func (p *Plugin) postMeeting(creator *model.User, channelID string, topic string) (*model.Post, *msgraph.OnlineMeeting, error) {
-	conf, err := p.getOAuthConfig()
+// meetingTimeFormat is how meeting times are written in post messages. The zone is always
+// written, as readers may be in another timezone than the user the time is formatted for.
+const meetingTimeFormat = "Mon, Jan 2 2006 at 15:04 MST"
+
+// formatMeetingTime writes a meeting time in the given timezone.
+func formatMeetingTime(t time.Time, location *time.Location) string {
+	return t.In(location).Format(meetingTimeFormat)
+}
+
+// getUserLocation returns the timezone of a user, or UTC when the user can't be found.
+func (p *Plugin) getUserLocation(userID string) *time.Location {
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil {
+		p.API.LogWarn("getUserLocation, failed to get user", "user_id", userID, "error", appErr.Error())
+		return time.UTC
+	}
+	return user.GetTimezoneLocation()
+}
+
+// meetingInvitees are the people explicitly invited to a meeting, in addition to the members of
+// a direct or group message channel.
+type meetingInvitees struct {
+	// Users are Mattermost users to invite.
+	Users []*model.User
+	// Guests are emails of people without a Mattermost account.
+	Guests []string
+}
+
+// meetingOptions describes the meeting to create and where to post it.
+type meetingOptions struct {
+	ChannelID string
+	// RootID is the thread to post the meeting in, if any.
+	RootID   string
+	Topic    string
+	Invitees *meetingInvitees
+	// Private meetings are only sent to the creator instead of being posted in the channel.
+	Private bool
+	// Settings schedule the meeting and set its lobby policy.
+	Settings meetingSettings
+	// RemoteUser is the creator's account found in the directory by authenticateOrganizer, if
+	// any.
+	RemoteUser *msgraph.User
+}
+
+func (p *Plugin) postMeeting(creator *model.User, opts meetingOptions) (*model.Post, *msgraph.OnlineMeeting, error) {
+	channelID := opts.ChannelID
+	topic := opts.Topic
+
+	client, userInfo, err := p.getOrganizerClient(creator, opts.RemoteUser)
 	if err != nil {
 		return nil, nil, err
 	}
-	userInfo, err := p.GetUserInfo(creator.Id)
+
+	rootID, err := p.getThreadRootID(channelID, opts.RootID)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -38,39 +91,312 @@ func (p *Plugin) postMeeting(creator *model.User, channelID string, topic string
 		if members == nil {
 			return nil, nil, errors.New("returned members is nil")
 		}
+		unconnectedIDs := []string{}
 		for _, member := range members {
 			var attendeeInfo *UserInfo
 			attendeeInfo, err = p.GetUserInfo(member.UserId)
 			if err != nil {
+				if member.UserId != creator.Id {
+					unconnectedIDs = append(unconnectedIDs, member.UserId)
+				}
 				continue
 			}
 			attendees = append(attendees, attendeeInfo)
 		}
+
+		if p.getConfiguration().InviteUnconnectedMembers && len(unconnectedIDs) > 0 {
+			attendees = append(attendees, p.resolveUnconnectedAttendees(client, unconnectedIDs)...)
+		}
 	}
 
-	client := p.NewClient(conf, userInfo.OAuthToken)
+	notInvited := []*model.User{}
+	if opts.Invitees != nil {
+		var invited []*UserInfo
+		invited, notInvited = p.resolveInvitees(client, creator.Id, opts.Invitees)
+		attendees = append(attendees, invited...)
+	}
 
-	meeting, err := client.CreateMeeting(userInfo, attendees, topic)
+	meeting, err := client.CreateMeeting(userInfo, uniqueAttendees(attendees), topic, opts.Settings)
 	if err != nil {
 		return nil, nil, err
 	}
 
+	record := newMeetingRecord(meeting)
+	record.Topic = topic
+	record.OrganizerID = creator.Id
+	record.OrganizerRemoteID = userInfo.RemoteID
+	record.ChannelID = channelID
+	record.RootID = rootID
+	record.Private = opts.Private
+
+	if record.ID, err = p.nextMeetingID(); err != nil {
+		p.API.LogWarn("postMeeting, failed to assign meeting number", "error", err.Error())
+	}
+
+	var post *model.Post
+	if opts.Private {
+		post, err = p.sendPrivateMeeting(creator, record)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else {
+		post = p.newMeetingPost(creator.Id, channelID, rootID, record, creator.Username)
+		if !opts.Settings.StartAt.IsZero() {
+			setMeetingSchedule(post, record, creator.GetTimezoneLocation())
+		}
+		post, appErr = p.API.CreatePost(post)
+		if appErr != nil {
+			return nil, nil, appErr
+		}
+		record.PostID = post.Id
+	}
+
+	if record.ID != 0 {
+		if err = p.StoreMeeting(record); err != nil {
+			p.API.LogWarn("postMeeting, failed to store meeting", "error", err.Error())
+		} else {
+			p.scheduleAttendanceReport(record)
+			p.scheduleMeetingArtifacts(record)
+			p.subscribeToMeeting(record)
+			p.scheduleMeetingStatus(record, append([]string{creator.Id}, getConnectedUserIDs(attendees)...))
+		}
+	}
+
+	if len(notInvited) > 0 {
+		p.sendNotInvited(creator.Id, channelID, rootID, notInvited)
+	}
+
+	return post, meeting, nil
+}
+
+// sendNotInvited lets the creator of a meeting know which of the Mattermost users they invited
+// could not be added to it.
+func (p *Plugin) sendNotInvited(userID, channelID, rootID string, users []*model.User) {
+	usernames := make([]string, 0, len(users))
+	for _, user := range users {
+		usernames = append(usernames, "@"+user.Username)
+	}
+
+	p.API.SendEphemeralPost(userID, &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: channelID,
+		RootId:    rootID,
+		Message: fmt.Sprintf("%s could not be invited as their Microsoft account was not found. They can still join from the meeting link, or connect their account with `/mstmeetings connect` to be invited next time.",
+			strings.Join(usernames, ", ")),
+	})
+}
+
+// newMeetingRecord returns the registry record of an online meeting.
+func newMeetingRecord(meeting *msgraph.OnlineMeeting) *Meeting {
+	record := &Meeting{}
+	if meeting.ID != nil {
+		record.RemoteID = *meeting.ID
+	}
+	if meeting.JoinURL != nil {
+		record.JoinURL = *meeting.JoinURL
+	}
+	if meeting.Subject != nil {
+		record.Topic = *meeting.Subject
+	}
+	if meeting.StartDateTime != nil {
+		record.StartAt = model.GetMillisForTime(*meeting.StartDateTime)
+	}
+	if meeting.EndDateTime != nil {
+		record.EndAt = model.GetMillisForTime(*meeting.EndDateTime)
+	}
+	if participants := meeting.Participants; participants != nil && participants.Organizer != nil &&
+		participants.Organizer.Identity != nil && participants.Organizer.Identity.User != nil && participants.Organizer.Identity.User.ID != nil {
+		record.OrganizerRemoteID = *participants.Organizer.Identity.User.ID
+	}
+	return record
+}
+
+// shareMeeting posts the card of an existing meeting, found by its join URL through the
+// user's connection, for example one scheduled in Outlook.
+func (p *Plugin) shareMeeting(user *model.User, remoteUser *msgraph.User, channelID, rootID, joinURL string) (*model.Post, error) {
+	if !p.API.HasPermissionToChannel(user.Id, channelID, model.PermissionCreatePost) {
+		return nil, errors.New("cannot create post in this channel")
+	}
+
+	rootID, err := p.getThreadRootID(channelID, rootID)
+	if err != nil {
+		return nil, err
+	}
+
+	client, userInfo, err := p.getOrganizerClient(user, remoteUser)
+	if err != nil {
+		return nil, err
+	}
+
+	meeting, err := client.GetMeetingByJoinURL(context.Background(), userInfo.RemoteID, joinURL)
+	if err != nil {
+		return nil, err
+	}
+
+	record := newMeetingRecord(meeting)
+	record.JoinURL = joinURL
+	record.ChannelID = channelID
+	record.RootID = rootID
+
+	organizerName := p.getMeetingOrganizerName(record, meeting, user, userInfo)
+
+	if record.ID, err = p.nextMeetingID(); err != nil {
+		p.API.LogWarn("shareMeeting, failed to assign meeting number", "error", err.Error())
+	}
+
+	post := p.newMeetingPost(user.Id, channelID, rootID, record, organizerName)
+	post.Message = fmt.Sprintf("Meeting shared at [this link](%s).", joinURL)
+	if record.StartAt != 0 {
+		setMeetingSchedule(post, record, user.GetTimezoneLocation())
+	}
+
+	post, appErr := p.API.CreatePost(post)
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	record.PostID = post.Id
+	if record.ID != 0 {
+		if err = p.StoreMeeting(record); err != nil {
+			p.API.LogWarn("shareMeeting, failed to store meeting", "error", err.Error())
+		}
+	}
+
+	return post, nil
+}
+
+// getMeetingOrganizerName returns the name to show as the organizer of an existing meeting,
+// filling in the Mattermost organizer of the record when they connected their account.
+func (p *Plugin) getMeetingOrganizerName(record *Meeting, meeting *msgraph.OnlineMeeting, user *model.User, userInfo *UserInfo) string {
+	if record.OrganizerRemoteID == "" {
+		return user.Username
+	}
+
+	if record.OrganizerRemoteID == userInfo.RemoteID {
+		record.OrganizerID = user.Id
+		return user.Username
+	}
+
+	if organizerInfo, err := p.GetUserInfoByRemoteID(record.OrganizerRemoteID); err == nil {
+		if organizer, appErr := p.API.GetUser(organizerInfo.UserID); appErr == nil {
+			record.OrganizerID = organizer.Id
+			return organizer.Username
+		}
+	}
+
+	if identity := meeting.Participants.Organizer.Identity.User; identity.DisplayName != nil {
+		return *identity.DisplayName
+	}
+	if upn := meeting.Participants.Organizer.Upn; upn != nil {
+		return *upn
+	}
+	return ""
+}
+
+// newMeetingPost returns the meeting card post of a meeting.
+func (p *Plugin) newMeetingPost(userID, channelID, rootID string, meeting *Meeting, creatorUsername string) *model.Post {
 	post := &model.Post{
-		UserId:    creator.Id,
+		UserId:    userID,
 		ChannelId: channelID,
-		Message:   fmt.Sprintf("Meeting started at [this link](%s).", *meeting.JoinURL),
+		RootId:    rootID,
+		Message:   fmt.Sprintf("Meeting started at [this link](%s).", meeting.JoinURL),
 		Type:      "custom_mstmeetings",
 		Props: map[string]interface{}{
-			"meeting_link":             *meeting.JoinURL,
+			"meeting_id":               meeting.ID,
+			"meeting_link":             meeting.JoinURL,
 			"meeting_status":           postTypeStarted,
-			"meeting_personal":         true,
-			"meeting_topic":            topic,
-			"meeting_creator_username": creator.Username,
+			"meeting_personal":         !meeting.Private,
+			"meeting_topic":            meeting.Topic,
+			"meeting_creator_username": creatorUsername,
 			"meeting_provider":         msteamsProviderName,
 		},
 	}
 
-	post, appErr = p.API.CreatePost(post)
+	// Actions need the meeting to be in the registry.
+	if meeting.ID != 0 {
+		if actions := p.getMeetingCardActions(meeting); actions != nil {
+			post.AddProp("attachments", actions)
+		}
+	}
+	return post
+}
+
+// setMeetingSchedule tells in the meeting post when the meeting is scheduled for, in the
+// timezone of the organizer.
+func setMeetingSchedule(post *model.Post, meeting *Meeting, location *time.Location) {
+	start := formatMeetingTime(time.UnixMilli(meeting.StartAt), location)
+	post.Message = fmt.Sprintf("Meeting scheduled for %s at [this link](%s).", start, meeting.JoinURL)
+	post.AddProp("meeting_start_at", meeting.StartAt)
+	post.AddProp("meeting_end_at", meeting.EndAt)
+}
+
+// newMeetingReply returns a bot reply in the thread of a meeting post.
+func (p *Plugin) newMeetingReply(meeting *Meeting, message string) *model.Post {
+	rootID := meeting.RootID
+	if rootID == "" {
+		rootID = meeting.PostID
+	}
+
+	return &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: meeting.ChannelID,
+		RootId:    rootID,
+		Message:   message,
+		Props: map[string]interface{}{
+			"meeting_id": meeting.ID,
+		},
+	}
+}
+
+// sendPrivateMeeting shows a private meeting only to its creator, as an ephemeral post in the
+// channel it was started from and as a direct message from the bot to keep the link around.
+func (p *Plugin) sendPrivateMeeting(creator *model.User, meeting *Meeting) (*model.Post, error) {
+	post := p.newMeetingPost(p.botUserID, meeting.ChannelID, meeting.RootID, meeting, creator.Username)
+	p.API.SendEphemeralPost(creator.Id, post)
+
+	channel, appErr := p.API.GetDirectChannel(creator.Id, p.botUserID)
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	dm := p.newMeetingPost(p.botUserID, channel.Id, "", meeting, creator.Username)
+	dm, appErr = p.API.CreatePost(dm)
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	return dm, nil
+}
+
+// repostMeeting posts the card of an existing meeting from the registry again. Private meetings
+// can only be reposted by their organizer, and other meetings by anyone able to read the channel
+// they were originally posted in.
+func (p *Plugin) repostMeeting(user *model.User, channelID, rootID string, meetingID int) (*model.Post, *Meeting, error) {
+	meeting, err := p.GetMeeting(meetingID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if meeting.OrganizerID != user.Id &&
+		(meeting.Private || !p.API.HasPermissionToChannel(user.Id, meeting.ChannelID, model.PermissionReadChannel)) {
+		return nil, nil, errors.New("cannot access this meeting")
+	}
+
+	if !p.API.HasPermissionToChannel(user.Id, channelID, model.PermissionCreatePost) {
+		return nil, nil, errors.New("cannot create post in this channel")
+	}
+
+	rootID, err = p.getThreadRootID(channelID, rootID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	creatorUsername := ""
+	if organizer, appErr := p.API.GetUser(meeting.OrganizerID); appErr == nil {
+		creatorUsername = organizer.Username
+	}
+
+	post, appErr := p.API.CreatePost(p.newMeetingPost(user.Id, channelID, rootID, meeting, creatorUsername))
 	if appErr != nil {
 		return nil, nil, appErr
 	}
@@ -78,7 +404,135 @@ func (p *Plugin) postMeeting(creator *model.User, channelID string, topic string
 	return post, meeting, nil
 }
 
-func (p *Plugin) postConfirmCreateOrJoin(meetingURL string, channelID string, topic string, userID string, creatorName string, provider string) *model.Post {
+// resolveUnconnectedAttendees finds the Microsoft accounts of Mattermost users who never
+// connected by looking up their Mattermost email in the directory. Users that cannot be resolved
+// are skipped.
+func (p *Plugin) resolveUnconnectedAttendees(client *Client, userIDs []string) []*UserInfo {
+	users, appErr := p.API.GetUsersByIds(userIDs)
+	if appErr != nil {
+		p.API.LogWarn("resolveUnconnectedAttendees, failed to get users", "error", appErr.Error())
+		return nil
+	}
+
+	emails := []string{}
+	for _, user := range users {
+		if user.Email != "" && !user.IsBot {
+			emails = append(emails, user.Email)
+		}
+	}
+
+	remoteUsers, err := client.GetUsersByEmails(emails)
+	if err != nil {
+		p.API.LogWarn("resolveUnconnectedAttendees, failed to look up users", "error", err.Error())
+		return nil
+	}
+
+	attendees := []*UserInfo{}
+	for _, user := range users {
+		remoteUser, ok := remoteUsers[strings.ToLower(user.Email)]
+		if !ok || remoteUser.ID == nil || remoteUser.UserPrincipalName == nil {
+			continue
+		}
+
+		attendees = append(attendees, &UserInfo{
+			UserID:   user.Id,
+			Email:    user.Email,
+			RemoteID: *remoteUser.ID,
+			UPN:      *remoteUser.UserPrincipalName,
+		})
+	}
+
+	return attendees
+}
+
+// getThreadRootID returns the root of the thread a post belongs to, making sure it is in the
+// given channel.
+func (p *Plugin) getThreadRootID(channelID, postID string) (string, error) {
+	if postID == "" {
+		return "", nil
+	}
+
+	post, appErr := p.API.GetPost(postID)
+	if appErr != nil {
+		return "", errors.Wrap(appErr, "cannot get thread root post")
+	}
+
+	if post.ChannelId != channelID {
+		return "", errors.New("thread root post is not in this channel")
+	}
+
+	if post.RootId != "" {
+		return post.RootId, nil
+	}
+	return post.Id, nil
+}
+
+// resolveInvitees returns the attendee info of explicitly invited people. Mattermost users who
+// never connected are looked up in the directory, and those not found are returned separately
+// rather than invited by their Mattermost email.
+func (p *Plugin) resolveInvitees(client *Client, creatorID string, invitees *meetingInvitees) ([]*UserInfo, []*model.User) {
+	attendees := []*UserInfo{}
+	unconnected := map[string]*model.User{}
+	unconnectedIDs := []string{}
+	for _, user := range invitees.Users {
+		if user.Id == creatorID {
+			continue
+		}
+
+		attendeeInfo, err := p.GetUserInfo(user.Id)
+		if err != nil {
+			unconnected[user.Id] = user
+			unconnectedIDs = append(unconnectedIDs, user.Id)
+			continue
+		}
+		attendees = append(attendees, attendeeInfo)
+	}
+
+	notInvited := []*model.User{}
+	if len(unconnectedIDs) > 0 {
+		for _, attendeeInfo := range p.resolveUnconnectedAttendees(client, unconnectedIDs) {
+			attendees = append(attendees, attendeeInfo)
+			delete(unconnected, attendeeInfo.UserID)
+		}
+		for _, userID := range unconnectedIDs {
+			if user, ok := unconnected[userID]; ok {
+				notInvited = append(notInvited, user)
+			}
+		}
+	}
+
+	for _, email := range invitees.Guests {
+		attendees = append(attendees, &UserInfo{
+			Email: email,
+			UPN:   email,
+		})
+	}
+
+	return attendees, notInvited
+}
+
+// uniqueAttendees removes attendees listed more than once, such as invited channel members.
+func uniqueAttendees(attendees []*UserInfo) []*UserInfo {
+	seen := map[string]bool{}
+	unique := []*UserInfo{}
+	for _, attendee := range attendees {
+		key := attendee.RemoteID
+		if key == "" {
+			key = strings.ToLower(attendee.UPN)
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, attendee)
+	}
+	return unique
+}
+
+// postConfirmCreateOrJoin asks the user whether to join a recent meeting or to create a new one
+// anyway. postID is the post the meeting is started about, if any, so a new meeting still
+// invites its author.
+func (p *Plugin) postConfirmCreateOrJoin(meetingURL string, channelID string, rootID string, postID string, topic string, userID string, creatorName string, provider string) *model.Post {
 	message := "There is another recent meeting created on this channel."
 	if provider != msteamsProviderName {
 		message = fmt.Sprintf("There is another recent meeting created on this channel with %s.", provider)
@@ -86,6 +540,7 @@ func (p *Plugin) postConfirmCreateOrJoin(meetingURL string, channelID string, to
 	post := &model.Post{
 		UserId:    p.botUserID,
 		ChannelId: channelID,
+		RootId:    rootID,
 		Message:   message,
 		Type:      "custom_mstmeetings",
 		Props: map[string]interface{}{
@@ -98,10 +553,49 @@ func (p *Plugin) postConfirmCreateOrJoin(meetingURL string, channelID string, to
 			"meeting_provider":         provider,
 		},
 	}
+	if postID != "" {
+		post.AddProp("meeting_post_id", postID)
+	}
+
+	// The webapp renders its own buttons, actions let the other clients answer too.
+	if pluginURL, err := p.getPluginURL(); err == nil {
+		actionContext := map[string]interface{}{
+			"channel_id":   channelID,
+			"root_id":      rootID,
+			"post_id":      postID,
+			"topic":        topic,
+			"meeting_link": meetingURL,
+		}
+		post.AddProp("attachments", []*model.SlackAttachment{{
+			Actions: []*model.PostAction{
+				newConfirmMeetingAction("Join existing", confirmActionJoin, pluginURL, actionContext),
+				newConfirmMeetingAction("Create new anyway", confirmActionCreate, pluginURL, actionContext),
+			},
+		}})
+	} else {
+		p.API.LogWarn("postConfirmCreateOrJoin, failed to get plugin URL", "error", err.Error())
+	}
 
 	return p.API.SendEphemeralPost(userID, post)
 }
 
+func newConfirmMeetingAction(name, action, pluginURL string, actionContext map[string]interface{}) *model.PostAction {
+	integrationContext := map[string]interface{}{"action": action}
+	for key, value := range actionContext {
+		integrationContext[key] = value
+	}
+
+	return &model.PostAction{
+		Id:   action,
+		Name: name,
+		Type: model.PostActionTypeButton,
+		Integration: &model.PostActionIntegration{
+			URL:     pluginURL + confirmMeetingActionPath,
+			Context: integrationContext,
+		},
+	}
+}
+
 func (p *Plugin) postConnect(channelID string, userID string) (*model.Post, error) {
 	oauthMsg, err := p.getOauthMessage(channelID)
 	if err != nil {
@@ -117,3 +611,99 @@ func (p *Plugin) postConnect(channelID string, userID string) (*model.Post, erro
 
 	return p.API.SendEphemeralPost(userID, post), nil
 }
+
+// linkedMeetingLookupTimeout bounds looking up the details of a meeting linked in a post.
+const linkedMeetingLookupTimeout = 10 * time.Second
+
+// teamsMeetingURLPattern matches links in a message that could be Teams meeting join links.
+var teamsMeetingURLPattern = regexp.MustCompile(`https://[^\s<>()\[\]]+`)
+
+// MessageWillBePosted turns pasted Teams meeting join links into meeting posts, so they are
+// considered when detecting duplicate meetings. Posts made of just the link are rendered as a
+// meeting card, while longer messages keep their text and only gain the meeting props.
+func (p *Plugin) MessageWillBePosted(_ *plugin.Context, post *model.Post) (*model.Post, string) {
+	if post.UserId == p.botUserID || post.Type != "" || getString("meeting_provider", post.Props) != "" {
+		return nil, ""
+	}
+
+	joinURL := ""
+	for _, link := range teamsMeetingURLPattern.FindAllString(post.Message, -1) {
+		if isTeamsMeetingURL(link) {
+			joinURL = link
+			break
+		}
+	}
+	if joinURL == "" {
+		return nil, ""
+	}
+
+	// The meeting details are filled in once the post is saved, so posting isn't held up by
+	// requests to Microsoft.
+	post.AddProp("meeting_link", joinURL)
+	post.AddProp("meeting_status", postTypeStarted)
+	post.AddProp("meeting_personal", true)
+	post.AddProp("meeting_provider", msteamsProviderName)
+
+	if strings.TrimSpace(post.Message) == joinURL {
+		post.Type = "custom_mstmeetings"
+	}
+
+	return post, ""
+}
+
+// MessageHasBeenPosted fills in the details of Teams meeting links posted by users.
+func (p *Plugin) MessageHasBeenPosted(_ *plugin.Context, post *model.Post) {
+	joinURL := getString("meeting_link", post.Props)
+	if post.UserId == p.botUserID || joinURL == "" || getString("meeting_provider", post.Props) != msteamsProviderName {
+		return
+	}
+	if _, ok := post.GetProps()["meeting_topic"]; ok {
+		// Meeting posts of the plugin have their details already.
+		return
+	}
+
+	go p.fillLinkedMeeting(post.UserId, post.Id, joinURL)
+}
+
+// fillLinkedMeeting looks up a linked meeting through the poster's own connection, setting its
+// topic on the post, and the poster as its creator when they organized it.
+func (p *Plugin) fillLinkedMeeting(userID, postID, joinURL string) {
+	userInfo, err := p.GetUserInfo(userID)
+	if err != nil || userInfo.ConnectionBroken {
+		return
+	}
+
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil {
+		p.API.LogWarn("fillLinkedMeeting, failed to get user", "UserID", userID, "error", appErr.Error())
+		return
+	}
+
+	conf, err := p.getOAuthConfig()
+	if err != nil {
+		p.API.LogWarn("fillLinkedMeeting, failed to get OAuth config", "error", err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), linkedMeetingLookupTimeout)
+	defer cancel()
+
+	meeting, err := p.NewClient(conf, userInfo.OAuthToken).GetMeetingByJoinURL(ctx, userInfo.RemoteID, joinURL)
+	if err != nil {
+		p.API.LogDebug("fillLinkedMeeting, failed to get meeting", "UserID", userID, "error", err.Error())
+		return
+	}
+
+	topic := ""
+	if meeting.Subject != nil {
+		topic = *meeting.Subject
+	}
+	organizer := newMeetingRecord(meeting).OrganizerRemoteID == userInfo.RemoteID
+
+	p.updateMeetingPost(postID, func(post *model.Post) {
+		post.AddProp("meeting_topic", topic)
+		if organizer {
+			post.AddProp("meeting_creator_username", user.Username)
+		}
+	})
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestResolveUnconnectedAttendees(t *testing.T) {
+	users := []*model.User{
+		{Id: "alice-id", Email: "Alice@example.com"},
+		{Id: "bob-id", Email: "bob@example.com"},
+		{Id: "bot-id", Email: "bot@example.com", IsBot: true},
+		{Id: "noemail-id"},
+	}
+
+	for _, testCase := range []struct {
+		description     string
+		statusCode      int
+		body            string
+		expectAttendees []*UserInfo
+		expectWarning   string
+	}{
+		{
+			description: "partially found",
+			statusCode:  http.StatusOK,
+			body:        `{"value":[{"id":"remote-alice","mail":"alice@example.com","userPrincipalName":"alice@corp.example.com"}]}`,
+			expectAttendees: []*UserInfo{
+				{UserID: "alice-id", Email: "Alice@example.com", RemoteID: "remote-alice", UPN: "alice@corp.example.com"},
+			},
+		},
+		{
+			description:     "user principal name missing",
+			statusCode:      http.StatusOK,
+			body:            `{"value":[{"id":"remote-alice","mail":"alice@example.com"}]}`,
+			expectAttendees: []*UserInfo{},
+		},
+		{
+			description:   "lookup denied",
+			statusCode:    http.StatusForbidden,
+			body:          `{"error":{"code":"Authorization_RequestDenied","message":"Insufficient privileges"}}`,
+			expectWarning: "resolveUnconnectedAttendees, failed to look up users",
+		},
+	} {
+		t.Run(testCase.description, func(t *testing.T) {
+			mockGraph(t, func(w http.ResponseWriter, r *http.Request) {
+				filter := r.URL.Query().Get("$filter")
+				require.Contains(t, filter, "'Alice@example.com'")
+				require.Contains(t, filter, "'bob@example.com'")
+				require.NotContains(t, filter, "bot@example.com")
+				writeGraphJSON(w, testCase.statusCode, testCase.body)
+			})
+
+			p := &Plugin{}
+			p.setConfiguration(&configuration{})
+			api := &plugintest.API{}
+			api.On("GetUsersByIds", []string{"alice-id", "bob-id", "bot-id", "noemail-id"}).Return(users, nil)
+			if testCase.expectWarning != "" {
+				api.On("LogWarn", testCase.expectWarning, "error", mock.AnythingOfType("string")).Return().Once()
+			}
+			p.SetAPI(api)
+
+			attendees := p.resolveUnconnectedAttendees(p.newClient(&http.Client{}), []string{"alice-id", "bob-id", "bot-id", "noemail-id"})
+			require.Equal(t, testCase.expectAttendees, attendees)
+			api.AssertExpectations(t)
+		})
+	}
+}
+
+func TestResolveInvitees(t *testing.T) {
+	creator := &model.User{Id: "creator-id", Username: "creator", Email: "creator@example.com"}
+	connected := &model.User{Id: "connected-id", Username: "connected", Email: "connected@example.com"}
+	found := &model.User{Id: "found-id", Username: "found", Email: "found@example.com"}
+	missing := &model.User{Id: "missing-id", Username: "missing", Email: "missing@example.com"}
+
+	mockGraph(t, func(w http.ResponseWriter, r *http.Request) {
+		writeGraphJSON(w, http.StatusOK, `{"value":[{"id":"remote-found","mail":"found@example.com","userPrincipalName":"found@corp.example.com"}]}`)
+	})
+
+	p := &Plugin{}
+	p.setConfiguration(&configuration{})
+	api := &plugintest.API{}
+	data, err := (&UserInfo{UserID: "connected-id", RemoteID: "remote-connected", UPN: "connected@corp.example.com"}).EncryptedJSON(nil)
+	require.NoError(t, err)
+	api.On("KVGet", tokenKey+"connected-id").Return(data, nil)
+	api.On("KVGet", tokenKey+"found-id").Return(nil, nil)
+	api.On("KVGet", tokenKey+"missing-id").Return(nil, nil)
+	api.On("GetUsersByIds", []string{"found-id", "missing-id"}).Return([]*model.User{found, missing}, nil)
+	p.SetAPI(api)
+
+	attendees, notInvited := p.resolveInvitees(p.newClient(&http.Client{}), creator.Id, &meetingInvitees{
+		Users:  []*model.User{creator, connected, found, missing},
+		Guests: []string{"guest@external.com"},
+	})
+
+	upns := []string{}
+	for _, attendee := range attendees {
+		upns = append(upns, attendee.UPN)
+	}
+	require.Equal(t, []string{"connected@corp.example.com", "found@corp.example.com", "guest@external.com"}, upns)
+	require.Equal(t, []*model.User{missing}, notInvited)
+}
+
+func TestSendNotInvited(t *testing.T) {
+	p := &Plugin{botUserID: "bot-id"}
+	api := &plugintest.API{}
+	api.On("SendEphemeralPost", "creator-id", mock.MatchedBy(func(post *model.Post) bool {
+		return post.UserId == "bot-id" && post.ChannelId == "channel-id" && post.RootId == "root-id" &&
+			strings.HasPrefix(post.Message, "@alice, @bob could not be invited") &&
+			!strings.Contains(post.Message, "@example.com")
+	})).Return(&model.Post{}).Once()
+	p.SetAPI(api)
+
+	p.sendNotInvited("creator-id", "channel-id", "root-id", []*model.User{
+		{Id: "alice-id", Username: "alice", Email: "alice@example.com"},
+		{Id: "bob-id", Username: "bob", Email: "bob@example.com"},
+	})
+	api.AssertExpectations(t)
+}
+
+func TestPostConfirmCreateOrJoin(t *testing.T) {
+	for _, postID := range []string{"", "post-id"} {
+		p := &Plugin{botUserID: "bot-id"}
+		p.setConfiguration(&configuration{})
+		api := &plugintest.API{}
+		api.On("GetConfig").Return(&model.Config{
+			ServiceSettings: model.ServiceSettings{
+				SiteURL: model.NewString("https://example.com"),
+			},
+		})
+		var sent *model.Post
+		api.On("SendEphemeralPost", "user-id", mock.AnythingOfType("*model.Post")).Run(func(args mock.Arguments) {
+			sent = args.Get(1).(*model.Post)
+		}).Return(&model.Post{})
+		p.SetAPI(api)
+
+		p.postConfirmCreateOrJoin("https://teams.microsoft.com/l/meetup-join/recent", "channel-id", "root-id", postID, "Weekly sync", "user-id", "alice", msteamsProviderName)
+
+		require.NotNil(t, sent)
+		require.Equal(t, "root-id", sent.RootId)
+		if postID == "" {
+			require.Nil(t, sent.GetProp("meeting_post_id"))
+		} else {
+			require.Equal(t, postID, sent.GetProp("meeting_post_id"))
+		}
+
+		attachments := sent.GetProp("attachments").([]*model.SlackAttachment)
+		for _, action := range attachments[0].Actions {
+			require.Equal(t, postID, action.Integration.Context["post_id"])
+		}
+	}
+}
+
+func TestMessageWillBePosted(t *testing.T) {
+	joinURL := "https://teams.microsoft.com/l/meetup-join/thread"
+
+	for _, testCase := range []struct {
+		description string
+		post        *model.Post
+		expectType  string
+		expectLink  bool
+	}{
+		{
+			description: "link only",
+			post:        &model.Post{UserId: "user-id", Message: joinURL},
+			expectType:  "custom_mstmeetings",
+			expectLink:  true,
+		},
+		{
+			description: "link in a message",
+			post:        &model.Post{UserId: "user-id", Message: "Join us at " + joinURL + " today"},
+			expectLink:  true,
+		},
+		{
+			description: "other link",
+			post:        &model.Post{UserId: "user-id", Message: "https://example.com/l/meetup-join/thread"},
+		},
+		{
+			description: "bot post",
+			post:        &model.Post{UserId: "bot-id", Message: joinURL},
+		},
+		{
+			description: "meeting post",
+			post: &model.Post{UserId: "user-id", Message: joinURL, Props: model.StringInterface{
+				"meeting_provider": msteamsProviderName,
+			}},
+		},
+	} {
+		t.Run(testCase.description, func(t *testing.T) {
+			p := &Plugin{botUserID: "bot-id"}
+			// The hook doesn't call Microsoft or the server while the post is being saved.
+			p.SetAPI(&plugintest.API{})
+
+			post, rejection := p.MessageWillBePosted(nil, testCase.post)
+			require.Empty(t, rejection)
+			if !testCase.expectLink {
+				require.Nil(t, post)
+				return
+			}
+
+			require.Equal(t, testCase.expectType, post.Type)
+			require.Equal(t, joinURL, post.GetProp("meeting_link"))
+			require.Equal(t, msteamsProviderName, post.GetProp("meeting_provider"))
+			require.Equal(t, postTypeStarted, post.GetProp("meeting_status"))
+			require.Nil(t, post.GetProp("meeting_topic"))
+			require.Nil(t, post.GetProp("meeting_creator_username"))
+		})
+	}
+}
+
+func TestMessageHasBeenPostedSkipsMeetingPosts(t *testing.T) {
+	joinURL := "https://teams.microsoft.com/l/meetup-join/thread"
+
+	p := &Plugin{botUserID: "bot-id"}
+	// Any lookup would call the unmocked API and fail the test.
+	p.SetAPI(&plugintest.API{})
+
+	p.MessageHasBeenPosted(nil, &model.Post{UserId: "user-id", Message: "no link"})
+	p.MessageHasBeenPosted(nil, &model.Post{UserId: "bot-id", Props: model.StringInterface{
+		"meeting_link":     joinURL,
+		"meeting_provider": msteamsProviderName,
+	}})
+	p.MessageHasBeenPosted(nil, &model.Post{UserId: "user-id", Props: model.StringInterface{
+		"meeting_link":     joinURL,
+		"meeting_provider": msteamsProviderName,
+		"meeting_topic":    "",
+	}})
+	p.MessageHasBeenPosted(nil, &model.Post{UserId: "user-id", Props: model.StringInterface{
+		"meeting_link":     joinURL,
+		"meeting_provider": "zoom",
+	}})
+}
+
+func TestFillLinkedMeeting(t *testing.T) {
+	joinURL := "https://teams.microsoft.com/l/meetup-join/thread"
+
+	for _, testCase := range []struct {
+		description   string
+		connected     bool
+		organizerID   string
+		expectUpdate  bool
+		expectCreator bool
+	}{
+		{
+			description:   "organizer",
+			connected:     true,
+			organizerID:   "remote-id",
+			expectUpdate:  true,
+			expectCreator: true,
+		},
+		{
+			description:  "attendee",
+			connected:    true,
+			organizerID:  "other-remote-id",
+			expectUpdate: true,
+		},
+		{
+			description: "not connected",
+		},
+	} {
+		t.Run(testCase.description, func(t *testing.T) {
+			mockGraph(t, func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "/beta/users/remote-id/onlineMeetings", r.URL.Path)
+				require.Equal(t, "Bearer access-token", r.Header.Get("Authorization"))
+				writeGraphJSON(w, http.StatusOK, `{"value":[{"subject":"Weekly sync","participants":{"organizer":{"identity":{"user":{"id":"`+testCase.organizerID+`"}}}}}]}`)
+			})
+
+			p := &Plugin{}
+			p.setConfiguration(&configuration{OAuth2Authority: "tenant-id", OAuth2ClientID: "client-id", OAuth2ClientSecret: "secret"})
+			api := &plugintest.API{}
+			if testCase.connected {
+				data, err := (&UserInfo{
+					UserID:     "user-id",
+					RemoteID:   "remote-id",
+					OAuthToken: &oauth2.Token{AccessToken: "access-token", Expiry: time.Now().Add(time.Hour)},
+				}).EncryptedJSON(nil)
+				require.NoError(t, err)
+				api.On("KVGet", tokenKey+"user-id").Return(data, nil)
+			} else {
+				api.On("KVGet", tokenKey+"user-id").Return(nil, nil)
+			}
+			api.On("GetUser", "user-id").Return(&model.User{Id: "user-id", Username: "alice"}, nil)
+			api.On("GetConfig").Return(&model.Config{
+				ServiceSettings: model.ServiceSettings{
+					SiteURL: model.NewString("https://example.com"),
+				},
+			})
+			api.On("GetPost", "post-id").Return(&model.Post{Id: "post-id", Props: model.StringInterface{"meeting_link": joinURL}}, nil)
+			var updated *model.Post
+			api.On("UpdatePost", mock.AnythingOfType("*model.Post")).Run(func(args mock.Arguments) {
+				updated = args.Get(0).(*model.Post)
+			}).Return(&model.Post{}, nil)
+			p.SetAPI(api)
+
+			p.fillLinkedMeeting("user-id", "post-id", joinURL)
+
+			if !testCase.expectUpdate {
+				require.Nil(t, updated)
+				return
+			}
+			require.Equal(t, "Weekly sync", updated.GetProp("meeting_topic"))
+			if testCase.expectCreator {
+				require.Equal(t, "alice", updated.GetProp("meeting_creator_username"))
+			} else {
+				require.Nil(t, updated.GetProp("meeting_creator_username"))
+			}
+		})
+	}
+}
+
+func TestSetMeetingSchedule(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	require.NoError(t, err)
+
+	start := time.Date(2024, time.January, 15, 9, 30, 0, 0, time.UTC)
+	meeting := &Meeting{
+		JoinURL: "https://teams.microsoft.com/l/meetup-join/meeting",
+		StartAt: start.UnixMilli(),
+		EndAt:   start.Add(time.Hour).UnixMilli(),
+	}
+
+	post := &model.Post{}
+	setMeetingSchedule(post, meeting, paris)
+	require.Equal(t, "Meeting scheduled for Mon, Jan 15 2024 at 10:30 CET at [this link](https://teams.microsoft.com/l/meetup-join/meeting).", post.Message)
+	require.Equal(t, meeting.StartAt, post.GetProp("meeting_start_at"))
+	require.Equal(t, meeting.EndAt, post.GetProp("meeting_end_at"))
+}
+
+func TestGetUserLocation(t *testing.T) {
+	api := &plugintest.API{}
+	api.On("GetUser", "user-id").Return(&model.User{
+		Id:       "user-id",
+		Timezone: model.StringMap{"useAutomaticTimezone": "false", "manualTimezone": "Europe/Paris"},
+	}, nil)
+	api.On("GetUser", "unknown-id").Return(nil, model.NewAppError("GetUser", "not_found", nil, "", http.StatusNotFound))
+	api.On("LogWarn", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	require.Equal(t, "Europe/Paris", p.getUserLocation("user-id").String())
+	require.Equal(t, time.UTC, p.getUserLocation("unknown-id"))
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+	msgraph "github.com/yaegashi/msgraph.go/beta"
+	"golang.org/x/oauth2"
+)
+
+const (
+	presenceSyncKey       = "presencesync_"
+	presenceSyncCursorKey = "presencesynccursor"
+
+	presenceSyncJobKey   = "presence_sync"
+	presenceSyncInterval = 2 * time.Minute
+
+	// The presence of users is read in batches, pausing between batches and between requests
+	// to stay well under the Microsoft Graph throttling limits.
+	presenceSyncBatchSize    = 20
+	presenceSyncBatchPause   = 2 * time.Second
+	presenceSyncRequestPause = 200 * time.Millisecond
+
+	// A run syncs at most presenceSyncMaxUsers users, about half a minute of pauses, and stops
+	// after presenceSyncMaxDuration so it is over before the next run. The next run carries on
+	// with the users that were left.
+	presenceSyncMaxUsers    = 100
+	presenceSyncMaxDuration = presenceSyncInterval / 2
+)
+
+// presenceSync is the state of the presence sync of a user who opted in.
+type presenceSync struct {
+	// Set is the Mattermost status the sync set, if any.
+	Set string `json:",omitempty"`
+	// Previous is the Mattermost status the user had before, to restore it once they are
+	// available again in Teams.
+	Previous string `json:",omitempty"`
+	// PreviousManual is whether the user set the previous status themselves.
+	PreviousManual bool `json:",omitempty"`
+}
+
+// GetMyPresence returns the Teams presence of the signed-in user.
+func (c *Client) GetMyPresence() (*msgraph.Presence, error) {
+	presence, err := c.builder.Me().Presence().Request().Get(context.Background())
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot get presence")
+	}
+	return presence, nil
+}
+
+// getPresenceStatus maps a Teams presence to the Mattermost status to set, or an empty string
+// when the user's status should be left to Mattermost.
+func getPresenceStatus(presence *msgraph.Presence) string {
+	if presence == nil {
+		return ""
+	}
+
+	if presence.Availability != nil {
+		switch *presence.Availability {
+		case "Busy", "BusyIdle", "DoNotDisturb":
+			return model.StatusDnd
+		}
+	}
+
+	if presence.Activity != nil {
+		switch *presence.Activity {
+		case "InACall", "InAConferenceCall", "InAMeeting", "Presenting", "DoNotDisturb", "UrgentInterruptionsOnly":
+			return model.StatusDnd
+		}
+	}
+
+	return ""
+}
+
+func getPresenceSyncKey(userID string) string {
+	return presenceSyncKey + userID
+}
+
+func (p *Plugin) getPresenceSync(userID string) (*presenceSync, error) {
+	data, appErr := p.API.KVGet(getPresenceSyncKey(userID))
+	if appErr != nil {
+		return nil, appErr
+	}
+	if data == nil {
+		return nil, nil
+	}
+
+	state := &presenceSync{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func (p *Plugin) storePresenceSync(userID string, state *presenceSync) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	if appErr := p.API.KVSet(getPresenceSyncKey(userID), data); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+// startPresenceSync includes the user in the presence sync.
+func (p *Plugin) startPresenceSync(userID string) error {
+	state, err := p.getPresenceSync(userID)
+	if err != nil {
+		return err
+	}
+	if state != nil {
+		return nil
+	}
+	return p.storePresenceSync(userID, &presenceSync{})
+}
+
+// stopPresenceSync removes the user from the presence sync, restoring their status if the sync
+// set it.
+func (p *Plugin) stopPresenceSync(userID string) error {
+	state, err := p.getPresenceSync(userID)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return nil
+	}
+
+	if state.Set != "" {
+		if err = p.restorePresenceStatus(userID, state); err != nil {
+			return err
+		}
+	}
+
+	if appErr := p.API.KVDelete(getPresenceSyncKey(userID)); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+// listPresenceSyncUserIDs returns the Mattermost IDs of the users who opted in to the presence
+// sync.
+func (p *Plugin) listPresenceSyncUserIDs() ([]string, error) {
+	userIDs := []string{}
+	for page := 0; ; page++ {
+		keys, appErr := p.API.KVList(page, kvListPageSize)
+		if appErr != nil {
+			return nil, appErr
+		}
+
+		for _, key := range keys {
+			if strings.HasPrefix(key, presenceSyncKey) {
+				userIDs = append(userIDs, strings.TrimPrefix(key, presenceSyncKey))
+			}
+		}
+
+		if len(keys) < kvListPageSize {
+			return userIDs, nil
+		}
+	}
+}
+
+// syncPresences updates the Mattermost status of the users who opted in from their presence in
+// Teams.
+func (p *Plugin) syncPresences() {
+	if !p.getConfiguration().SyncTeamsPresence {
+		return
+	}
+
+	userIDs, err := p.listPresenceSyncUserIDs()
+	if err != nil {
+		p.API.LogError("syncPresences, failed to list users", "error", err.Error())
+		return
+	}
+
+	if len(userIDs) == 0 {
+		return
+	}
+
+	conf, err := p.getOAuthConfig()
+	if err != nil {
+		p.API.LogError("syncPresences, failed to get OAuth config", "error", err.Error())
+		return
+	}
+
+	start := time.Now()
+	cursor := p.getPresenceSyncCursor() % len(userIDs)
+	synced := 0
+	for ; synced < len(userIDs) && synced < presenceSyncMaxUsers; synced++ {
+		if synced > 0 && time.Since(start) > presenceSyncMaxDuration {
+			break
+		}
+
+		switch {
+		case synced > 0 && synced%presenceSyncBatchSize == 0:
+			time.Sleep(presenceSyncBatchPause)
+		case synced > 0:
+			time.Sleep(presenceSyncRequestPause)
+		}
+
+		userID := userIDs[(cursor+synced)%len(userIDs)]
+		if err = p.syncUserPresence(conf, userID); err != nil {
+			var errRes *msgraph.ErrorResponse
+			if errors.As(err, &errRes) && errRes.StatusCode() == http.StatusTooManyRequests {
+				// The remaining users are synced on the next run.
+				p.API.LogWarn("syncPresences, throttled by Microsoft Graph", "synced", synced)
+				break
+			}
+			p.API.LogWarn("syncPresences, failed to sync presence", "UserID", userID, "error", err.Error())
+		}
+	}
+
+	if appErr := p.API.KVSet(presenceSyncCursorKey, []byte(strconv.Itoa((cursor+synced)%len(userIDs)))); appErr != nil {
+		p.API.LogWarn("syncPresences, failed to store cursor", "error", appErr.Error())
+	}
+}
+
+// getPresenceSyncCursor returns the position in the list of users the last run stopped at.
+func (p *Plugin) getPresenceSyncCursor() int {
+	data, appErr := p.API.KVGet(presenceSyncCursorKey)
+	if appErr != nil || data == nil {
+		return 0
+	}
+
+	cursor, err := strconv.Atoi(string(data))
+	if err != nil || cursor < 0 {
+		return 0
+	}
+	return cursor
+}
+
+// restorePresenceStatuses sets back the statuses the sync changed, once it is turned off.
+func (p *Plugin) restorePresenceStatuses() {
+	userIDs, err := p.listPresenceSyncUserIDs()
+	if err != nil {
+		p.API.LogError("restorePresenceStatuses, failed to list users", "error", err.Error())
+		return
+	}
+
+	for _, userID := range userIDs {
+		if restoreErr := p.restoreUserPresenceStatus(userID); restoreErr != nil {
+			p.API.LogWarn("restorePresenceStatuses, failed to restore status", "UserID", userID, "error", restoreErr.Error())
+		}
+	}
+}
+
+// restoreUserPresenceStatus sets back the status of the user if the sync changed it, keeping
+// them opted in.
+func (p *Plugin) restoreUserPresenceStatus(userID string) error {
+	state, err := p.getPresenceSync(userID)
+	if err != nil {
+		return err
+	}
+	if state == nil || state.Set == "" {
+		return nil
+	}
+	return p.restorePresenceStatus(userID, state)
+}
+
+func (p *Plugin) syncUserPresence(conf *oauth2.Config, userID string) error {
+	userInfo, data, err := p.getStoredUserInfo(userID)
+	if err != nil || userInfo.ConnectionBroken {
+		// The presence can't be read until the user connects again, which resumes the sync.
+		return p.restoreUserPresenceStatus(userID)
+	}
+
+	presence, err := p.NewUserClient(conf, userInfo, data).GetMyPresence()
+	if err != nil {
+		return err
+	}
+
+	state, err := p.getPresenceSync(userID)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return nil
+	}
+
+	status, appErr := p.API.GetUserStatus(userID)
+	if appErr != nil {
+		return appErr
+	}
+
+	target := getPresenceStatus(presence)
+	if target == "" {
+		if state.Set == "" {
+			return nil
+		}
+		return p.restorePresenceStatus(userID, state)
+	}
+
+	if state.Set != "" && status.Status != state.Set {
+		// The user changed their status themselves while busy, keep it.
+		return nil
+	}
+
+	if status.Status == target {
+		return nil
+	}
+
+	if _, appErr = p.API.UpdateUserStatus(userID, target); appErr != nil {
+		return appErr
+	}
+
+	if state.Set == "" {
+		state.Previous = status.Status
+		state.PreviousManual = status.Manual
+	}
+	state.Set = target
+	return p.storePresenceSync(userID, state)
+}
+
+// restorePresenceStatus sets back the status the user had before the sync changed it, unless
+// they changed it themselves since.
+func (p *Plugin) restorePresenceStatus(userID string, state *presenceSync) error {
+	status, appErr := p.API.GetUserStatus(userID)
+	if appErr != nil {
+		return appErr
+	}
+
+	if status.Status == state.Set {
+		// A status the user set themselves is set back as is. Otherwise the status is set to
+		// online, which Mattermost never keeps as a manual status, so it goes back to following
+		// the user's activity.
+		previous := model.StatusOnline
+		if state.PreviousManual && state.Previous != "" {
+			previous = state.Previous
+		}
+		if _, appErr = p.API.UpdateUserStatus(userID, previous); appErr != nil {
+			return appErr
+		}
+	}
+
+	state.Set = ""
+	state.Previous = ""
+	state.PreviousManual = false
+	return p.storePresenceSync(userID, state)
+}
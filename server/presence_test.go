@@ -0,0 +1,248 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	msgraph "github.com/yaegashi/msgraph.go/beta"
+	"golang.org/x/oauth2"
+)
+
+func TestGetPresenceStatus(t *testing.T) {
+	newPresence := func(availability, activity string) *msgraph.Presence {
+		return &msgraph.Presence{Availability: &availability, Activity: &activity}
+	}
+
+	require.Equal(t, model.StatusDnd, getPresenceStatus(newPresence("Busy", "Busy")))
+	require.Equal(t, model.StatusDnd, getPresenceStatus(newPresence("Busy", "InACall")))
+	require.Equal(t, model.StatusDnd, getPresenceStatus(newPresence("DoNotDisturb", "Presenting")))
+	require.Equal(t, model.StatusDnd, getPresenceStatus(newPresence("Available", "InAMeeting")))
+	require.Equal(t, "", getPresenceStatus(newPresence("Available", "Available")))
+	require.Equal(t, "", getPresenceStatus(newPresence("Away", "BeRightBack")))
+	require.Equal(t, "", getPresenceStatus(&msgraph.Presence{}))
+	require.Equal(t, "", getPresenceStatus(nil))
+}
+
+func TestRestorePresenceStatus(t *testing.T) {
+	t.Run("restores the previous manual status", func(t *testing.T) {
+		p := &Plugin{}
+		api := &plugintest.API{}
+		p.SetAPI(api)
+
+		api.On("GetUserStatus", "alice").Return(&model.Status{UserId: "alice", Status: model.StatusDnd}, nil)
+		api.On("UpdateUserStatus", "alice", model.StatusOffline).Return(&model.Status{}, nil)
+		api.On("KVSet", "presencesync_alice", []byte(`{}`)).Return(nil)
+
+		require.NoError(t, p.restorePresenceStatus("alice", &presenceSync{Set: model.StatusDnd, Previous: model.StatusOffline, PreviousManual: true}))
+		api.AssertExpectations(t)
+	})
+
+	t.Run("lets an automatic status follow the user's activity again", func(t *testing.T) {
+		p := &Plugin{}
+		api := &plugintest.API{}
+		p.SetAPI(api)
+
+		api.On("GetUserStatus", "alice").Return(&model.Status{UserId: "alice", Status: model.StatusDnd}, nil)
+		api.On("UpdateUserStatus", "alice", model.StatusOnline).Return(&model.Status{}, nil)
+		api.On("KVSet", "presencesync_alice", []byte(`{}`)).Return(nil)
+
+		require.NoError(t, p.restorePresenceStatus("alice", &presenceSync{Set: model.StatusDnd, Previous: model.StatusAway}))
+		api.AssertExpectations(t)
+	})
+
+	t.Run("keeps a status the user changed", func(t *testing.T) {
+		p := &Plugin{}
+		api := &plugintest.API{}
+		p.SetAPI(api)
+
+		api.On("GetUserStatus", "alice").Return(&model.Status{UserId: "alice", Status: model.StatusOnline}, nil)
+		api.On("KVSet", "presencesync_alice", []byte(`{}`)).Return(nil)
+
+		require.NoError(t, p.restorePresenceStatus("alice", &presenceSync{Set: model.StatusDnd, Previous: model.StatusAway}))
+		api.AssertExpectations(t)
+		api.AssertNotCalled(t, "UpdateUserStatus", "alice", model.StatusAway)
+	})
+}
+
+func newPresenceSyncTestPlugin(api *plugintest.API) *Plugin {
+	p := &Plugin{}
+	p.setConfiguration(&configuration{
+		OAuth2Authority:    "tenant-id",
+		OAuth2ClientID:     "client-id",
+		OAuth2ClientSecret: "secret",
+		SyncTeamsPresence:  true,
+	})
+	api.On("GetConfig").Return(&model.Config{
+		ServiceSettings: model.ServiceSettings{
+			SiteURL: model.NewString("https://example.com"),
+		},
+	})
+	p.SetAPI(api)
+	return p
+}
+
+func TestSyncPresencesStoresRefreshedToken(t *testing.T) {
+	var tokenRequests int
+	mockTransport(t, roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.URL.Path == "/tenant-id/oauth2/v2.0/token" {
+			tokenRequests++
+			return tokenResponse(http.StatusOK, `{"access_token":"new-access-token","refresh_token":"new-refresh-token","token_type":"Bearer","expires_in":3600}`)(r)
+		}
+		require.Equal(t, "/beta/me/presence", r.URL.Path)
+		require.Equal(t, "Bearer new-access-token", r.Header.Get("Authorization"))
+		return tokenResponse(http.StatusOK, `{"availability":"Available","activity":"Available"}`)(r)
+	}))
+
+	userInfo := &UserInfo{
+		UserID:     "user-id",
+		RemoteID:   "remote-id",
+		OAuthToken: &oauth2.Token{AccessToken: "expired-access-token", RefreshToken: "refresh-token", Expiry: time.Now().Add(-time.Hour)},
+	}
+	data, err := userInfo.EncryptedJSON(nil)
+	require.NoError(t, err)
+
+	hasAccessToken := mock.MatchedBy(func(stored []byte) bool {
+		info, decryptErr := DecryptUserInfo(stored, nil)
+		return decryptErr == nil && info.OAuthToken.AccessToken == "new-access-token" && info.OAuthToken.RefreshToken == "new-refresh-token"
+	})
+
+	api := &plugintest.API{}
+	api.On("KVList", 0, kvListPageSize).Return([]string{getPresenceSyncKey("user-id")}, nil)
+	api.On("KVGet", presenceSyncCursorKey).Return(nil, nil)
+	api.On("KVGet", tokenKey+"user-id").Return(data, nil)
+	api.On("KVCompareAndSet", tokenKey+"user-id", data, hasAccessToken).Return(true, nil)
+	api.On("KVSet", tokenKeyByRemoteID+"remote-id", hasAccessToken).Return(nil)
+	api.On("KVGet", getPresenceSyncKey("user-id")).Return([]byte(`{}`), nil)
+	api.On("GetUserStatus", "user-id").Return(&model.Status{Status: model.StatusOnline}, nil)
+	api.On("KVSet", presenceSyncCursorKey, []byte("0")).Return(nil)
+	p := newPresenceSyncTestPlugin(api)
+
+	p.syncPresences()
+
+	require.Equal(t, 1, tokenRequests)
+	api.AssertCalled(t, "KVCompareAndSet", tokenKey+"user-id", data, hasAccessToken)
+	api.AssertCalled(t, "KVSet", tokenKeyByRemoteID+"remote-id", hasAccessToken)
+}
+
+func TestSyncPresencesResumesFromCursor(t *testing.T) {
+	mockGraph(t, func(w http.ResponseWriter, r *http.Request) {
+		writeGraphJSON(w, http.StatusTooManyRequests, `{"error":{"code":"TooManyRequests","message":"Too many requests"}}`)
+	})
+
+	userInfo := &UserInfo{
+		UserID:     "bob",
+		RemoteID:   "bob-remote-id",
+		OAuthToken: &oauth2.Token{AccessToken: "access-token", Expiry: time.Now().Add(time.Hour)},
+	}
+	data, err := userInfo.EncryptedJSON(nil)
+	require.NoError(t, err)
+
+	api := &plugintest.API{}
+	api.On("KVList", 0, kvListPageSize).Return([]string{getPresenceSyncKey("alice"), getPresenceSyncKey("bob"), getPresenceSyncKey("carol")}, nil)
+	// The last run stopped at bob.
+	api.On("KVGet", presenceSyncCursorKey).Return([]byte("4"), nil)
+	api.On("KVGet", tokenKey+"bob").Return(data, nil)
+	api.On("LogWarn", "syncPresences, throttled by Microsoft Graph", "synced", 0).Return()
+	api.On("KVSet", presenceSyncCursorKey, []byte("1")).Return(nil)
+	p := newPresenceSyncTestPlugin(api)
+
+	p.syncPresences()
+
+	// Throttled on bob, the next run starts with him again.
+	api.AssertCalled(t, "KVSet", presenceSyncCursorKey, []byte("1"))
+	api.AssertNotCalled(t, "KVGet", tokenKey+"alice")
+	api.AssertNotCalled(t, "KVGet", tokenKey+"carol")
+}
+
+func TestSyncUserPresence(t *testing.T) {
+	userInfo := &UserInfo{
+		UserID:     "alice",
+		RemoteID:   "alice-remote-id",
+		OAuthToken: &oauth2.Token{AccessToken: "access-token", Expiry: time.Now().Add(time.Hour)},
+	}
+	data, err := userInfo.EncryptedJSON(nil)
+	require.NoError(t, err)
+
+	t.Run("records whether the previous status was manual", func(t *testing.T) {
+		mockGraph(t, func(w http.ResponseWriter, r *http.Request) {
+			writeGraphJSON(w, http.StatusOK, `{"availability":"Busy","activity":"InAMeeting"}`)
+		})
+
+		api := &plugintest.API{}
+		api.On("KVGet", tokenKey+"alice").Return(data, nil)
+		api.On("KVGet", getPresenceSyncKey("alice")).Return([]byte(`{}`), nil)
+		api.On("GetUserStatus", "alice").Return(&model.Status{UserId: "alice", Status: model.StatusAway, Manual: true}, nil)
+		api.On("UpdateUserStatus", "alice", model.StatusDnd).Return(&model.Status{}, nil)
+		api.On("KVSet", getPresenceSyncKey("alice"), []byte(`{"Set":"dnd","Previous":"away","PreviousManual":true}`)).Return(nil)
+		p := newPresenceSyncTestPlugin(api)
+
+		conf, err := p.getOAuthConfig()
+		require.NoError(t, err)
+		require.NoError(t, p.syncUserPresence(conf, "alice"))
+		api.AssertExpectations(t)
+	})
+
+	t.Run("keeps a status the user changed while busy", func(t *testing.T) {
+		mockGraph(t, func(w http.ResponseWriter, r *http.Request) {
+			writeGraphJSON(w, http.StatusOK, `{"availability":"Busy","activity":"InAMeeting"}`)
+		})
+
+		api := &plugintest.API{}
+		api.On("KVGet", tokenKey+"alice").Return(data, nil)
+		api.On("KVGet", getPresenceSyncKey("alice")).Return([]byte(`{"Set":"dnd","Previous":"online"}`), nil)
+		api.On("GetUserStatus", "alice").Return(&model.Status{UserId: "alice", Status: model.StatusAway, Manual: true}, nil)
+		p := newPresenceSyncTestPlugin(api)
+
+		conf, err := p.getOAuthConfig()
+		require.NoError(t, err)
+		require.NoError(t, p.syncUserPresence(conf, "alice"))
+		api.AssertNotCalled(t, "UpdateUserStatus", mock.Anything, mock.Anything)
+		api.AssertNotCalled(t, "KVSet", mock.Anything, mock.Anything)
+	})
+
+	t.Run("restores the status once the connection is broken", func(t *testing.T) {
+		broken := *userInfo
+		broken.ConnectionBroken = true
+		brokenData, err := broken.EncryptedJSON(nil)
+		require.NoError(t, err)
+
+		api := &plugintest.API{}
+		api.On("KVGet", tokenKey+"alice").Return(brokenData, nil)
+		api.On("KVGet", getPresenceSyncKey("alice")).Return([]byte(`{"Set":"dnd","Previous":"online"}`), nil)
+		api.On("GetUserStatus", "alice").Return(&model.Status{UserId: "alice", Status: model.StatusDnd, Manual: true}, nil)
+		api.On("UpdateUserStatus", "alice", model.StatusOnline).Return(&model.Status{}, nil)
+		api.On("KVSet", getPresenceSyncKey("alice"), []byte(`{}`)).Return(nil)
+		p := newPresenceSyncTestPlugin(api)
+
+		conf, err := p.getOAuthConfig()
+		require.NoError(t, err)
+		require.NoError(t, p.syncUserPresence(conf, "alice"))
+		api.AssertExpectations(t)
+	})
+}
+
+func TestRemoveUserStopsPresenceSync(t *testing.T) {
+	data, err := (&UserInfo{UserID: "alice", RemoteID: "alice-remote-id"}).EncryptedJSON(nil)
+	require.NoError(t, err)
+
+	p := &Plugin{}
+	p.setConfiguration(&configuration{})
+	api := &plugintest.API{}
+	api.On("KVGet", getPresenceSyncKey("alice")).Return([]byte(`{"Set":"dnd","Previous":"online"}`), nil)
+	api.On("GetUserStatus", "alice").Return(&model.Status{UserId: "alice", Status: model.StatusDnd, Manual: true}, nil)
+	api.On("UpdateUserStatus", "alice", model.StatusOnline).Return(&model.Status{}, nil)
+	api.On("KVSet", getPresenceSyncKey("alice"), []byte(`{}`)).Return(nil)
+	api.On("KVDelete", getPresenceSyncKey("alice")).Return(nil)
+	api.On("KVGet", tokenKey+"alice").Return(data, nil)
+	api.On("KVDelete", tokenKey+"alice").Return(nil)
+	api.On("KVDelete", tokenKeyByRemoteID+"alice-remote-id").Return(nil)
+	p.SetAPI(api)
+
+	require.NoError(t, p.RemoveUser("alice"))
+	api.AssertExpectations(t)
+}
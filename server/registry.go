@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	meetingKey         = "meeting_"
+	meetingSequenceKey = "meetingsequence"
+
+	maxMeetingSequenceAttempts = 10
+)
+
+// Meeting is what the plugin remembers about each meeting it created.
+type Meeting struct {
+	// ID is the plugin assigned meeting number
+	ID int
+	// Remote online meeting ID
+	RemoteID string
+	JoinURL  string
+	Topic    string
+
+	// Mattermost userID of the organizer
+	OrganizerID string
+	// Remote userID of the organizer
+	OrganizerRemoteID string
+
+	ChannelID string
+	RootID    string `json:",omitempty"`
+	PostID    string `json:",omitempty"`
+
+	// Private meetings are only sent to the organizer instead of being posted in the channel
+	Private bool `json:",omitempty"`
+
+	StartAt int64
+	EndAt   int64
+}
+
+func getMeetingKey(meetingID int) string {
+	return meetingKey + strconv.Itoa(meetingID)
+}
+
+// nextMeetingID atomically assigns the next meeting number.
+func (p *Plugin) nextMeetingID() (int, error) {
+	for i := 0; i < maxMeetingSequenceAttempts; i++ {
+		current, appErr := p.API.KVGet(meetingSequenceKey)
+		if appErr != nil {
+			return 0, appErr
+		}
+
+		next := 1
+		if current != nil {
+			previous, err := strconv.Atoi(string(current))
+			if err != nil {
+				return 0, errors.Wrap(err, "invalid meeting sequence")
+			}
+			next = previous + 1
+		}
+
+		ok, appErr := p.API.KVCompareAndSet(meetingSequenceKey, current, []byte(strconv.Itoa(next)))
+		if appErr != nil {
+			return 0, appErr
+		}
+		if ok {
+			return next, nil
+		}
+	}
+
+	return 0, errors.New("failed to assign a meeting number")
+}
+
+// StoreMeeting saves the meeting in the registry, assigning it a number if it has none.
+func (p *Plugin) StoreMeeting(meeting *Meeting) error {
+	if meeting.ID == 0 {
+		id, err := p.nextMeetingID()
+		if err != nil {
+			return err
+		}
+		meeting.ID = id
+	}
+
+	data, err := json.Marshal(meeting)
+	if err != nil {
+		return err
+	}
+
+	if appErr := p.API.KVSet(getMeetingKey(meeting.ID), data); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+// GetMeeting returns a meeting from the registry.
+func (p *Plugin) GetMeeting(meetingID int) (*Meeting, error) {
+	data, appErr := p.API.KVGet(getMeetingKey(meetingID))
+	if appErr != nil {
+		return nil, appErr
+	}
+	if data == nil {
+		return nil, errors.Errorf("meeting %d not found", meetingID)
+	}
+
+	meeting := &Meeting{}
+	if err := json.Unmarshal(data, meeting); err != nil {
+		return nil, err
+	}
+	return meeting, nil
+}
+
+// listMeetingIDs returns the meetings tracked by keys made of the prefix and the meeting number.
+func (p *Plugin) listMeetingIDs(prefix string) ([]int, error) {
+	meetingIDs := []int{}
+	for page := 0; ; page++ {
+		keys, appErr := p.API.KVList(page, kvListPageSize)
+		if appErr != nil {
+			return nil, appErr
+		}
+
+		for _, key := range keys {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			if meetingID, err := strconv.Atoi(strings.TrimPrefix(key, prefix)); err == nil {
+				meetingIDs = append(meetingIDs, meetingID)
+			}
+		}
+
+		if len(keys) < kvListPageSize {
+			return meetingIDs, nil
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNextMeetingID(t *testing.T) {
+	t.Run("first meeting", func(t *testing.T) {
+		p := &Plugin{}
+		api := &plugintest.API{}
+		p.SetAPI(api)
+		api.On("KVGet", meetingSequenceKey).Return(nil, nil)
+		api.On("KVCompareAndSet", meetingSequenceKey, []byte(nil), []byte("1")).Return(true, nil)
+
+		id, err := p.nextMeetingID()
+		require.NoError(t, err)
+		require.Equal(t, 1, id)
+	})
+
+	t.Run("retries on concurrent update", func(t *testing.T) {
+		p := &Plugin{}
+		api := &plugintest.API{}
+		p.SetAPI(api)
+		api.On("KVGet", meetingSequenceKey).Return([]byte("4"), nil).Once()
+		api.On("KVCompareAndSet", meetingSequenceKey, []byte("4"), []byte("5")).Return(false, nil).Once()
+		api.On("KVGet", meetingSequenceKey).Return([]byte("5"), nil).Once()
+		api.On("KVCompareAndSet", meetingSequenceKey, []byte("5"), []byte("6")).Return(true, nil).Once()
+
+		id, err := p.nextMeetingID()
+		require.NoError(t, err)
+		require.Equal(t, 6, id)
+		api.AssertExpectations(t)
+	})
+}
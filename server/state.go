@@ -24,6 +24,18 @@ func (p *Plugin) StoreState(userID, channelID string, justConnect bool) (string,
 	return state, nil
 }
 
+// StoreStateIfAbsent stores the OAuth user state unless the user already has a connection
+// pending, which is then kept so completing it still does what the user started it for.
+func (p *Plugin) StoreStateIfAbsent(userID, channelID string, justConnect bool) error {
+	key := getOAuthUserStateKey(userID)
+	state := fmt.Sprintf("%v_%v_%v", key, channelID, justConnect)
+
+	if _, appErr := p.API.KVCompareAndSet(key, nil, []byte(state)); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
 func (p *Plugin) GetState(key string) (string, error) {
 	storedState, appErr := p.API.KVGet(key)
 	if appErr != nil {
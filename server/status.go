@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	userPreferencesKey = "userpreferences_"
+	meetingStatusKey   = "meetingstatus_"
+
+	meetingStatusJobKey   = "meeting_status"
+	meetingStatusInterval = 5 * time.Minute
+
+	meetingStatusEmoji = "calendar"
+	meetingStatusText  = "In a Teams meeting"
+)
+
+// userPreferences are the settings each user chooses for themselves.
+type userPreferences struct {
+	// MeetingStatus sets the user's custom status while they are in a meeting.
+	MeetingStatus bool `json:",omitempty"`
+}
+
+// meetingStatus tracks the custom status of the users of a meeting who opted in.
+type meetingStatus struct {
+	UserIDs []string
+	Started bool `json:",omitempty"`
+	// Previous are the custom statuses the users had before theirs was set for the meeting, to
+	// restore them when it ends.
+	Previous map[string]*model.CustomStatus `json:",omitempty"`
+}
+
+func getUserPreferencesKey(userID string) string {
+	return userPreferencesKey + userID
+}
+
+func getMeetingStatusKey(meetingID int) string {
+	return meetingStatusKey + strconv.Itoa(meetingID)
+}
+
+// getUserPreferences returns the preferences of the user, or the defaults if they never set any.
+func (p *Plugin) getUserPreferences(userID string) (*userPreferences, error) {
+	preferences := &userPreferences{}
+	data, appErr := p.API.KVGet(getUserPreferencesKey(userID))
+	if appErr != nil {
+		return nil, appErr
+	}
+	if data == nil {
+		return preferences, nil
+	}
+
+	if err := json.Unmarshal(data, preferences); err != nil {
+		return nil, err
+	}
+	return preferences, nil
+}
+
+func (p *Plugin) storeUserPreferences(userID string, preferences *userPreferences) error {
+	data, err := json.Marshal(preferences)
+	if err != nil {
+		return err
+	}
+
+	if appErr := p.API.KVSet(getUserPreferencesKey(userID), data); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+func (p *Plugin) getMeetingStatus(meetingID int) (*meetingStatus, error) {
+	data, appErr := p.API.KVGet(getMeetingStatusKey(meetingID))
+	if appErr != nil {
+		return nil, appErr
+	}
+	if data == nil {
+		return nil, nil
+	}
+
+	status := &meetingStatus{}
+	if err := json.Unmarshal(data, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+func (p *Plugin) storeMeetingStatus(meetingID int, status *meetingStatus) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+
+	if appErr := p.API.KVSet(getMeetingStatusKey(meetingID), data); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+// scheduleMeetingStatus remembers to set the custom status of the users who opted in while the
+// meeting is in progress, setting it right away for meetings starting now.
+func (p *Plugin) scheduleMeetingStatus(meeting *Meeting, userIDs []string) {
+	if meeting.ID == 0 {
+		return
+	}
+
+	status, err := p.getMeetingStatus(meeting.ID)
+	if err != nil {
+		p.API.LogWarn("scheduleMeetingStatus, failed to get meeting status", "MeetingID", meeting.ID, "error", err.Error())
+		return
+	}
+	if status == nil {
+		status = &meetingStatus{}
+	}
+
+	added := false
+	for _, userID := range userIDs {
+		if slices.Contains(status.UserIDs, userID) {
+			continue
+		}
+
+		preferences, prefErr := p.getUserPreferences(userID)
+		if prefErr != nil {
+			p.API.LogWarn("scheduleMeetingStatus, failed to get user preferences", "UserID", userID, "error", prefErr.Error())
+			continue
+		}
+		if preferences.MeetingStatus {
+			status.UserIDs = append(status.UserIDs, userID)
+			added = true
+		}
+	}
+	if !added {
+		return
+	}
+
+	if err = p.storeMeetingStatus(meeting.ID, status); err != nil {
+		p.API.LogWarn("scheduleMeetingStatus, failed to store meeting status", "MeetingID", meeting.ID, "error", err.Error())
+		return
+	}
+
+	now := time.Now()
+	if status.Started || (!time.UnixMilli(meeting.StartAt).After(now) && time.UnixMilli(meeting.EndAt).After(now)) {
+		p.startMeetingStatus(meeting)
+	}
+}
+
+// updateMeetingStatuses sets the custom status of the users in the meetings that started, and
+// restores it for the meetings that ended.
+func (p *Plugin) updateMeetingStatuses() {
+	meetingIDs, err := p.listMeetingIDs(meetingStatusKey)
+	if err != nil {
+		p.API.LogError("updateMeetingStatuses, failed to list meetings", "error", err.Error())
+		return
+	}
+
+	now := time.Now()
+	for _, meetingID := range meetingIDs {
+		meeting, getErr := p.GetMeeting(meetingID)
+		if getErr != nil {
+			p.API.LogWarn("updateMeetingStatuses, failed to get meeting", "MeetingID", meetingID, "error", getErr.Error())
+			if appErr := p.API.KVDelete(getMeetingStatusKey(meetingID)); appErr != nil {
+				p.API.LogWarn("updateMeetingStatuses, failed to delete meeting status", "MeetingID", meetingID, "error", appErr.Error())
+			}
+			continue
+		}
+
+		if !time.UnixMilli(meeting.EndAt).After(now) {
+			p.endMeetingStatus(meeting)
+			continue
+		}
+		if time.UnixMilli(meeting.StartAt).After(now) {
+			continue
+		}
+
+		status, statusErr := p.getMeetingStatus(meetingID)
+		if statusErr != nil {
+			p.API.LogWarn("updateMeetingStatuses, failed to get meeting status", "MeetingID", meetingID, "error", statusErr.Error())
+			continue
+		}
+		if status != nil && !status.Started {
+			p.startMeetingStatus(meeting)
+		}
+	}
+}
+
+// startMeetingStatus sets the custom status of the users of the meeting until it ends. It is
+// also called when the meeting end changes, to move the expiry of the status.
+func (p *Plugin) startMeetingStatus(meeting *Meeting) {
+	status, err := p.getMeetingStatus(meeting.ID)
+	if err != nil {
+		p.API.LogWarn("startMeetingStatus, failed to get meeting status", "MeetingID", meeting.ID, "error", err.Error())
+		return
+	}
+	if status == nil {
+		return
+	}
+
+	if status.Previous == nil {
+		status.Previous = map[string]*model.CustomStatus{}
+	}
+
+	expiresAt := time.UnixMilli(meeting.EndAt)
+	for _, userID := range status.UserIDs {
+		user, appErr := p.API.GetUser(userID)
+		if appErr != nil {
+			p.API.LogWarn("startMeetingStatus, failed to get user", "UserID", userID, "error", appErr.Error())
+			continue
+		}
+
+		current := user.GetCustomStatus()
+		if _, set := status.Previous[userID]; set {
+			if !isMeetingCustomStatus(current) {
+				// The user changed their status during the meeting, keep it.
+				continue
+			}
+		} else if isMeetingCustomStatus(current) {
+			// The status is set for another meeting in progress, which restores it when it ends.
+			continue
+		} else {
+			status.Previous[userID] = current
+		}
+
+		appErr = p.API.UpdateUserCustomStatus(userID, &model.CustomStatus{
+			Emoji:     meetingStatusEmoji,
+			Text:      meetingStatusText,
+			Duration:  "date_and_time",
+			ExpiresAt: expiresAt,
+		})
+		if appErr != nil {
+			p.API.LogWarn("startMeetingStatus, failed to set custom status", "UserID", userID, "error", appErr.Error())
+		}
+	}
+
+	status.Started = true
+	if err = p.storeMeetingStatus(meeting.ID, status); err != nil {
+		p.API.LogWarn("startMeetingStatus, failed to store meeting status", "MeetingID", meeting.ID, "error", err.Error())
+	}
+}
+
+// endMeetingStatus restores the custom status the users of the meeting had before it started,
+// unless they changed it since.
+func (p *Plugin) endMeetingStatus(meeting *Meeting) {
+	status, err := p.getMeetingStatus(meeting.ID)
+	if err != nil {
+		p.API.LogWarn("endMeetingStatus, failed to get meeting status", "MeetingID", meeting.ID, "error", err.Error())
+		return
+	}
+	if status == nil {
+		return
+	}
+
+	if appErr := p.API.KVDelete(getMeetingStatusKey(meeting.ID)); appErr != nil {
+		p.API.LogWarn("endMeetingStatus, failed to delete meeting status", "MeetingID", meeting.ID, "error", appErr.Error())
+		return
+	}
+
+	for userID, previous := range status.Previous {
+		user, appErr := p.API.GetUser(userID)
+		if appErr != nil {
+			p.API.LogWarn("endMeetingStatus, failed to get user", "UserID", userID, "error", appErr.Error())
+			continue
+		}
+
+		if !isMeetingCustomStatus(user.GetCustomStatus()) {
+			continue
+		}
+
+		if previous != nil && previous.AreDurationAndExpirationTimeValid() {
+			appErr = p.API.UpdateUserCustomStatus(userID, previous)
+		} else {
+			appErr = p.API.RemoveUserCustomStatus(userID)
+		}
+		if appErr != nil {
+			p.API.LogWarn("endMeetingStatus, failed to restore custom status", "UserID", userID, "error", appErr.Error())
+		}
+	}
+}
+
+func isMeetingCustomStatus(status *model.CustomStatus) bool {
+	return status != nil && status.Emoji == meetingStatusEmoji && status.Text == meetingStatusText
+}
+
+// getConnectedUserIDs returns the Mattermost users among the attendees who connected their
+// Microsoft account.
+func getConnectedUserIDs(attendees []*UserInfo) []string {
+	userIDs := []string{}
+	for _, attendee := range attendees {
+		if attendee.UserID == "" || (attendee.OAuthToken == nil && attendee.EncryptedOAuthToken == "") {
+			continue
+		}
+		if !slices.Contains(userIDs, attendee.UserID) {
+			userIDs = append(userIDs, attendee.UserID)
+		}
+	}
+	return userIDs
+}
+
+// refreshMeetingStatus moves the expiry of the custom statuses set for a meeting in progress
+// after its end changed.
+func (p *Plugin) refreshMeetingStatus(meeting *Meeting) {
+	status, err := p.getMeetingStatus(meeting.ID)
+	if err != nil {
+		p.API.LogWarn("refreshMeetingStatus, failed to get meeting status", "MeetingID", meeting.ID, "error", err.Error())
+		return
+	}
+
+	if status != nil && status.Started && time.UnixMilli(meeting.EndAt).After(time.Now()) {
+		p.startMeetingStatus(meeting)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestGetConnectedUserIDs(t *testing.T) {
+	require.Equal(t, []string{"alice", "bob"}, getConnectedUserIDs([]*UserInfo{
+		{UserID: "alice", OAuthToken: &oauth2.Token{}},
+		{UserID: "bob", EncryptedOAuthToken: "token"},
+		{UserID: "alice", OAuthToken: &oauth2.Token{}},
+		{UserID: "carol", Email: "carol@example.com"},
+		{Email: "guest@example.com"},
+	}))
+}
+
+func TestStartMeetingStatus(t *testing.T) {
+	p := &Plugin{}
+	api := &plugintest.API{}
+	p.SetAPI(api)
+
+	meeting := &Meeting{ID: 7, EndAt: time.Now().Add(time.Hour).UnixMilli()}
+	status, err := json.Marshal(&meetingStatus{
+		UserIDs: []string{"alice", "bob", "carol"},
+		Previous: map[string]*model.CustomStatus{
+			"carol": nil,
+		},
+	})
+	require.NoError(t, err)
+
+	previous := &model.CustomStatus{Emoji: "palm_tree", Text: "On vacation"}
+	alice := &model.User{Id: "alice"}
+	require.NoError(t, alice.SetCustomStatus(previous))
+	// Bob is in another meeting, and Carol's status was set by this meeting already.
+	bob := &model.User{Id: "bob"}
+	require.NoError(t, bob.SetCustomStatus(&model.CustomStatus{Emoji: meetingStatusEmoji, Text: meetingStatusText, ExpiresAt: time.Now().Add(time.Hour)}))
+	carol := &model.User{Id: "carol", Props: bob.Props}
+
+	api.On("KVGet", "meetingstatus_7").Return(status, nil)
+	api.On("GetUser", "alice").Return(alice, nil)
+	api.On("GetUser", "bob").Return(bob, nil)
+	api.On("GetUser", "carol").Return(carol, nil)
+	api.On("UpdateUserCustomStatus", "alice", mock.AnythingOfType("*model.CustomStatus")).Return(nil)
+	api.On("UpdateUserCustomStatus", "carol", mock.AnythingOfType("*model.CustomStatus")).Return(nil)
+	var stored meetingStatus
+	api.On("KVSet", "meetingstatus_7", mock.Anything).Run(func(args mock.Arguments) {
+		require.NoError(t, json.Unmarshal(args.Get(1).([]byte), &stored))
+	}).Return(nil)
+
+	p.startMeetingStatus(meeting)
+
+	api.AssertExpectations(t)
+	api.AssertNotCalled(t, "UpdateUserCustomStatus", "bob", mock.Anything)
+	require.True(t, stored.Started)
+	require.Equal(t, previous.Text, stored.Previous["alice"].Text)
+	require.Contains(t, stored.Previous, "carol")
+	require.NotContains(t, stored.Previous, "bob")
+}
+
+func TestEndMeetingStatus(t *testing.T) {
+	p := &Plugin{}
+	api := &plugintest.API{}
+	p.SetAPI(api)
+
+	meeting := &Meeting{ID: 7}
+	previous := &model.CustomStatus{Emoji: "palm_tree", Text: "On vacation"}
+	status, err := json.Marshal(&meetingStatus{
+		UserIDs: []string{"alice", "bob", "carol"},
+		Started: true,
+		Previous: map[string]*model.CustomStatus{
+			"alice": previous,
+			"bob":   nil,
+			"carol": nil,
+		},
+	})
+	require.NoError(t, err)
+
+	inMeeting := &model.User{Id: "alice"}
+	require.NoError(t, inMeeting.SetCustomStatus(&model.CustomStatus{Emoji: meetingStatusEmoji, Text: meetingStatusText, ExpiresAt: time.Now().Add(time.Hour)}))
+	bob := &model.User{Id: "bob", Props: inMeeting.Props}
+	carol := &model.User{Id: "carol"}
+	require.NoError(t, carol.SetCustomStatus(&model.CustomStatus{Emoji: "coffee", Text: "Break"}))
+
+	api.On("KVGet", "meetingstatus_7").Return(status, nil)
+	api.On("KVDelete", "meetingstatus_7").Return(nil)
+	api.On("GetUser", "alice").Return(inMeeting, nil)
+	api.On("GetUser", "bob").Return(bob, nil)
+	api.On("GetUser", "carol").Return(carol, nil)
+	api.On("UpdateUserCustomStatus", "alice", previous).Return(nil)
+	api.On("RemoveUserCustomStatus", "bob").Return(nil)
+
+	p.endMeetingStatus(meeting)
+
+	api.AssertExpectations(t)
+	api.AssertNumberOfCalls(t, "UpdateUserCustomStatus", 1)
+	api.AssertNotCalled(t, "RemoveUserCustomStatus", "carol")
+}
@@ -3,8 +3,12 @@ package main
 type TelemetrySource string
 
 const (
-	telemetryStartSourceWebapp  TelemetrySource = "webapp"
-	telemetryStartSourceCommand TelemetrySource = "command"
+	telemetryStartSourceWebapp       TelemetrySource = "webapp"
+	telemetryStartSourceCommand      TelemetrySource = "command"
+	telemetryStartSourcePost         TelemetrySource = "post"
+	telemetryStartSourceDialog       TelemetrySource = "dialog"
+	telemetryStartSourceConfirmation TelemetrySource = "confirmation"
+	telemetryStartSourceFindTime     TelemetrySource = "findtime"
 )
 
 func (p *Plugin) trackConnect(userID string) {
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	meetingArtifactsKey = "meetingartifacts_"
+
+	meetingArtifactsJobKey   = "meeting_artifacts"
+	meetingArtifactsInterval = 15 * time.Minute
+
+	// meetingArtifactsRetention is how long after a meeting ends new transcripts and recordings
+	// are looked for.
+	meetingArtifactsRetention = 2 * 24 * time.Hour
+
+	// maxTranscriptSize is the largest transcript attached to a post.
+	maxTranscriptSize = 10 * 1024 * 1024
+)
+
+// meetingArtifact is a transcript or a recording of an online meeting.
+type meetingArtifact struct {
+	ID              string     `json:"id"`
+	CreatedDateTime *time.Time `json:"createdDateTime"`
+}
+
+// postedMeetingArtifacts are the transcripts and recordings of a meeting already posted.
+type postedMeetingArtifacts struct {
+	Transcripts []string
+	Recordings  []string
+}
+
+// GetMeetingArtifacts lists the transcripts or the recordings of an online meeting of the
+// organizer, depending on the kind given.
+func (c *Client) GetMeetingArtifacts(organizerRemoteID, meetingRemoteID, kind string) ([]meetingArtifact, error) {
+	req := c.builder.Users().ID(organizerRemoteID).OnlineMeetings().ID(meetingRemoteID).Request()
+
+	artifacts := struct {
+		Value []meetingArtifact `json:"value"`
+	}{}
+	if err := req.JSONRequest(context.Background(), http.MethodGet, "/"+kind, nil, &artifacts); err != nil {
+		return nil, errors.Wrapf(err, "cannot get meeting %s", kind)
+	}
+	return artifacts.Value, nil
+}
+
+// GetTranscriptContent downloads a transcript of an online meeting of the organizer in the
+// WebVTT format.
+func (c *Client) GetTranscriptContent(organizerRemoteID, meetingRemoteID, transcriptID string) ([]byte, error) {
+	req := c.builder.Users().ID(organizerRemoteID).OnlineMeetings().ID(meetingRemoteID).Request()
+
+	httpReq, err := req.NewRequest(http.MethodGet, "/transcripts/"+transcriptID+"/content?$format=text/vtt", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := req.Client().Do(httpReq)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot get transcript")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("cannot get transcript: %s", res.Status)
+	}
+
+	content, err := io.ReadAll(io.LimitReader(res.Body, maxTranscriptSize+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read transcript")
+	}
+	if len(content) > maxTranscriptSize {
+		return nil, errors.New("transcript is too large")
+	}
+	return content, nil
+}
+
+func getMeetingArtifactsKey(meetingID int) string {
+	return meetingArtifactsKey + strconv.Itoa(meetingID)
+}
+
+// storeMeetingArtifacts saves the posted transcripts and recordings of the meeting, until new
+// ones are no longer looked for.
+func (p *Plugin) storeMeetingArtifacts(meeting *Meeting, posted *postedMeetingArtifacts) error {
+	data, err := json.Marshal(posted)
+	if err != nil {
+		return err
+	}
+
+	expiry := time.Until(time.UnixMilli(meeting.EndAt).Add(meetingArtifactsRetention))
+	if expiry <= 0 {
+		return p.deleteMeetingArtifacts(meeting.ID)
+	}
+
+	if appErr := p.API.KVSetWithExpiry(getMeetingArtifactsKey(meeting.ID), data, int64(expiry.Seconds())); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+func (p *Plugin) deleteMeetingArtifacts(meetingID int) error {
+	if appErr := p.API.KVDelete(getMeetingArtifactsKey(meetingID)); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+// scheduleMeetingArtifacts starts looking for the transcripts and recordings of the meeting.
+func (p *Plugin) scheduleMeetingArtifacts(meeting *Meeting) {
+	// Transcripts are shared with the whole channel the meeting was posted in, which private
+	// meetings are not.
+	if !p.getConfiguration().PostTranscriptsAndRecordings || meeting.ID == 0 || meeting.PostID == "" || meeting.Private {
+		return
+	}
+
+	if err := p.storeMeetingArtifacts(meeting, &postedMeetingArtifacts{}); err != nil {
+		p.API.LogWarn("scheduleMeetingArtifacts, failed to store meeting artifacts", "MeetingID", meeting.ID, "error", err.Error())
+	}
+}
+
+// rescheduleMeetingArtifacts moves the expiry of the posted transcripts and recordings of a
+// meeting after its end changed.
+func (p *Plugin) rescheduleMeetingArtifacts(meeting *Meeting) {
+	data, appErr := p.API.KVGet(getMeetingArtifactsKey(meeting.ID))
+	if appErr != nil {
+		p.API.LogWarn("rescheduleMeetingArtifacts, failed to get meeting artifacts", "MeetingID", meeting.ID, "error", appErr.Error())
+		return
+	}
+	if data == nil {
+		return
+	}
+
+	posted := &postedMeetingArtifacts{}
+	if err := json.Unmarshal(data, posted); err != nil {
+		p.API.LogWarn("rescheduleMeetingArtifacts, failed to decode meeting artifacts", "MeetingID", meeting.ID, "error", err.Error())
+		return
+	}
+
+	if err := p.storeMeetingArtifacts(meeting, posted); err != nil {
+		p.API.LogWarn("rescheduleMeetingArtifacts, failed to store meeting artifacts", "MeetingID", meeting.ID, "error", err.Error())
+	}
+}
+
+// postNewMeetingArtifacts posts the transcripts and recordings of the meetings that became
+// available since the last run.
+func (p *Plugin) postNewMeetingArtifacts() {
+	if !p.getConfiguration().PostTranscriptsAndRecordings {
+		return
+	}
+
+	meetingIDs, err := p.listMeetingIDs(meetingArtifactsKey)
+	if err != nil {
+		p.API.LogError("postNewMeetingArtifacts, failed to list meetings", "error", err.Error())
+		return
+	}
+
+	for _, meetingID := range meetingIDs {
+		if postErr := p.postMeetingArtifacts(meetingID); postErr != nil {
+			p.API.LogWarn("postNewMeetingArtifacts, failed to post meeting artifacts", "MeetingID", meetingID, "error", postErr.Error())
+		}
+	}
+}
+
+func (p *Plugin) postMeetingArtifacts(meetingID int) error {
+	data, appErr := p.API.KVGet(getMeetingArtifactsKey(meetingID))
+	if appErr != nil {
+		return appErr
+	}
+	if data == nil {
+		return nil
+	}
+
+	posted := &postedMeetingArtifacts{}
+	if err := json.Unmarshal(data, posted); err != nil {
+		return err
+	}
+
+	meeting, err := p.GetMeeting(meetingID)
+	if err != nil {
+		return err
+	}
+
+	if meeting.Private || meeting.PostID == "" {
+		return p.deleteMeetingArtifacts(meetingID)
+	}
+
+	if time.UnixMilli(meeting.StartAt).After(time.Now()) {
+		return nil
+	}
+
+	client, err := p.getMeetingOrganizerClient(meeting)
+	if err != nil {
+		return err
+	}
+
+	transcripts, err := client.GetMeetingArtifacts(meeting.OrganizerRemoteID, meeting.RemoteID, "transcripts")
+	if err != nil {
+		return err
+	}
+	for _, transcript := range transcripts {
+		if slices.Contains(posted.Transcripts, transcript.ID) {
+			continue
+		}
+
+		if err = p.postTranscript(client, meeting, transcript); err != nil {
+			return err
+		}
+		posted.Transcripts = append(posted.Transcripts, transcript.ID)
+		if err = p.storeMeetingArtifacts(meeting, posted); err != nil {
+			return err
+		}
+	}
+
+	recordings, err := client.GetMeetingArtifacts(meeting.OrganizerRemoteID, meeting.RemoteID, "recordings")
+	if err != nil {
+		return err
+	}
+	for _, recording := range recordings {
+		if slices.Contains(posted.Recordings, recording.ID) {
+			continue
+		}
+
+		if err = p.postRecording(meeting, recording); err != nil {
+			return err
+		}
+		posted.Recordings = append(posted.Recordings, recording.ID)
+		if err = p.storeMeetingArtifacts(meeting, posted); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// postTranscript attaches a transcript to a reply in the thread of the meeting post, making it
+// available to the channel members the meeting post was shared with.
+func (p *Plugin) postTranscript(client *Client, meeting *Meeting, transcript meetingArtifact) error {
+	content, err := client.GetTranscriptContent(meeting.OrganizerRemoteID, meeting.RemoteID, transcript.ID)
+	if err != nil {
+		return err
+	}
+
+	createdAt := time.UnixMilli(meeting.StartAt)
+	if transcript.CreatedDateTime != nil {
+		createdAt = *transcript.CreatedDateTime
+	}
+
+	fileInfo, appErr := p.API.UploadFile(content, meeting.ChannelID, fmt.Sprintf("transcript-%s.vtt", createdAt.UTC().Format("2006-01-02-1504")))
+	if appErr != nil {
+		return appErr
+	}
+
+	post := p.newMeetingReply(meeting, fmt.Sprintf("The transcript of the meeting of %s is available.", formatMeetingTime(createdAt, p.getUserLocation(meeting.OrganizerID))))
+	post.FileIds = model.StringArray{fileInfo.Id}
+	if _, appErr = p.API.CreatePost(post); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+// postRecording announces a recording in the thread of the meeting post. Recordings are too
+// large to attach, and are watched from the meeting in Teams.
+func (p *Plugin) postRecording(meeting *Meeting, recording meetingArtifact) error {
+	createdAt := time.UnixMilli(meeting.StartAt)
+	if recording.CreatedDateTime != nil {
+		createdAt = *recording.CreatedDateTime
+	}
+
+	post := p.newMeetingReply(meeting, fmt.Sprintf("The recording of the meeting of %s is available in the [meeting chat in Teams](%s).", formatMeetingTime(createdAt, p.getUserLocation(meeting.OrganizerID)), meeting.JoinURL))
+	if _, appErr := p.API.CreatePost(post); appErr != nil {
+		return appErr
+	}
+	return nil
+}
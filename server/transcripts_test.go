@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestScheduleMeetingArtifacts(t *testing.T) {
+	end := time.Now().Add(time.Hour)
+
+	for _, testCase := range []struct {
+		description string
+		enabled     bool
+		meeting     *Meeting
+		expectStore bool
+	}{
+		{
+			description: "meeting post",
+			enabled:     true,
+			meeting:     &Meeting{ID: 7, PostID: "post-id", EndAt: end.UnixMilli()},
+			expectStore: true,
+		},
+		{
+			description: "disabled",
+			meeting:     &Meeting{ID: 7, PostID: "post-id", EndAt: end.UnixMilli()},
+		},
+		{
+			description: "private meeting",
+			enabled:     true,
+			meeting:     &Meeting{ID: 7, PostID: "post-id", EndAt: end.UnixMilli(), Private: true},
+		},
+		{
+			description: "no post",
+			enabled:     true,
+			meeting:     &Meeting{ID: 7, EndAt: end.UnixMilli()},
+		},
+	} {
+		t.Run(testCase.description, func(t *testing.T) {
+			p := &Plugin{}
+			p.setConfiguration(&configuration{PostTranscriptsAndRecordings: testCase.enabled})
+			api := &plugintest.API{}
+			api.On("KVSetWithExpiry", getMeetingArtifactsKey(7), []byte(`{"Transcripts":null,"Recordings":null}`), mock.MatchedBy(func(seconds int64) bool {
+				expected := time.Until(end.Add(meetingArtifactsRetention)).Seconds()
+				return float64(seconds) > expected-60 && float64(seconds) <= expected
+			})).Return(nil)
+			p.SetAPI(api)
+
+			p.scheduleMeetingArtifacts(testCase.meeting)
+			if testCase.expectStore {
+				api.AssertNumberOfCalls(t, "KVSetWithExpiry", 1)
+			} else {
+				api.AssertNotCalled(t, "KVSetWithExpiry", mock.Anything, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestStoreMeetingArtifactsAfterRetention(t *testing.T) {
+	p := &Plugin{}
+	api := &plugintest.API{}
+	api.On("KVDelete", getMeetingArtifactsKey(7)).Return(nil).Once()
+	p.SetAPI(api)
+
+	meeting := &Meeting{ID: 7, EndAt: time.Now().Add(-meetingArtifactsRetention - time.Minute).UnixMilli()}
+	require.NoError(t, p.storeMeetingArtifacts(meeting, &postedMeetingArtifacts{}))
+	api.AssertNotCalled(t, "KVSetWithExpiry", mock.Anything, mock.Anything, mock.Anything)
+	api.AssertExpectations(t)
+}
+
+func TestPostNewMeetingArtifactsDisabled(t *testing.T) {
+	p := &Plugin{}
+	p.setConfiguration(&configuration{})
+	// Nothing is listed nor posted while the setting is off.
+	p.SetAPI(&plugintest.API{})
+
+	p.postNewMeetingArtifacts()
+}
+
+func TestPostMeetingArtifacts(t *testing.T) {
+	start := time.Now().Add(-time.Hour)
+	meeting := &Meeting{
+		ID:                7,
+		RemoteID:          "meeting-remote-id",
+		JoinURL:           "https://teams.microsoft.com/l/meetup-join/thread",
+		ChannelID:         "channel-id",
+		PostID:            "post-id",
+		OrganizerID:       "organizer-id",
+		OrganizerRemoteID: "organizer-remote-id",
+		StartAt:           start.UnixMilli(),
+		EndAt:             start.Add(30 * time.Minute).UnixMilli(),
+	}
+
+	for _, testCase := range []struct {
+		description       string
+		private           bool
+		expectTranscripts []string
+		expectRecordings  []string
+	}{
+		{
+			description:       "new artifacts",
+			expectTranscripts: []string{"transcript-1", "transcript-2"},
+			expectRecordings:  []string{"recording-1"},
+		},
+		{
+			description: "private meeting",
+			private:     true,
+		},
+	} {
+		t.Run(testCase.description, func(t *testing.T) {
+			contentRequests := []string{}
+			mockGraph(t, func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/beta/users/organizer-remote-id/onlineMeetings/meeting-remote-id/transcripts":
+					writeGraphJSON(w, http.StatusOK, `{"value":[{"id":"transcript-1"},{"id":"transcript-2","createdDateTime":"2024-05-06T07:08:09Z"}]}`)
+				case "/beta/users/organizer-remote-id/onlineMeetings/meeting-remote-id/recordings":
+					writeGraphJSON(w, http.StatusOK, `{"value":[{"id":"recording-1"}]}`)
+				case "/beta/users/organizer-remote-id/onlineMeetings/meeting-remote-id/transcripts/transcript-2/content":
+					contentRequests = append(contentRequests, r.URL.Path)
+					_, _ = w.Write([]byte("WEBVTT"))
+				default:
+					t.Errorf("unexpected request to %s", r.URL.Path)
+				}
+			})
+
+			record := *meeting
+			record.Private = testCase.private
+			meetingData, err := json.Marshal(&record)
+			require.NoError(t, err)
+
+			p := &Plugin{botUserID: "bot-id"}
+			p.setConfiguration(&configuration{
+				OAuth2Authority:              "tenant-id",
+				OAuth2ClientID:               "client-id",
+				OAuth2ClientSecret:           "secret",
+				PostTranscriptsAndRecordings: true,
+			})
+			api := &plugintest.API{}
+			// The first transcript was posted by a previous run.
+			api.On("KVGet", getMeetingArtifactsKey(7)).Return([]byte(`{"Transcripts":["transcript-1"]}`), nil)
+			api.On("KVGet", getMeetingKey(7)).Return(meetingData, nil)
+			api.On("KVDelete", getMeetingArtifactsKey(7)).Return(nil)
+			api.On("GetUser", "organizer-id").Return(&model.User{
+				Id:       "organizer-id",
+				Timezone: model.StringMap{"useAutomaticTimezone": "false", "manualTimezone": "Europe/Paris"},
+			}, nil)
+			api.On("GetConfig").Return(&model.Config{
+				ServiceSettings: model.ServiceSettings{
+					SiteURL: model.NewString("https://example.com"),
+				},
+			})
+			tokenData, err := (&UserInfo{
+				UserID:     "organizer-id",
+				RemoteID:   "organizer-remote-id",
+				OAuthToken: &oauth2.Token{AccessToken: "access-token", Expiry: time.Now().Add(time.Hour)},
+			}).EncryptedJSON(nil)
+			require.NoError(t, err)
+			api.On("KVGet", tokenKey+"organizer-id").Return(tokenData, nil)
+			api.On("UploadFile", []byte("WEBVTT"), "channel-id", "transcript-2024-05-06-0708.vtt").Return(&model.FileInfo{Id: "file-id"}, nil)
+			api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{}, nil)
+			var stored postedMeetingArtifacts
+			api.On("KVSetWithExpiry", getMeetingArtifactsKey(7), mock.Anything, mock.AnythingOfType("int64")).Run(func(args mock.Arguments) {
+				require.NoError(t, json.Unmarshal(args.Get(1).([]byte), &stored))
+			}).Return(nil)
+			p.SetAPI(api)
+
+			require.NoError(t, p.postMeetingArtifacts(7))
+
+			if testCase.private {
+				api.AssertCalled(t, "KVDelete", getMeetingArtifactsKey(7))
+				api.AssertNotCalled(t, "UploadFile", mock.Anything, mock.Anything, mock.Anything)
+				api.AssertNotCalled(t, "CreatePost", mock.Anything)
+				return
+			}
+
+			require.Len(t, contentRequests, 1)
+			api.AssertNumberOfCalls(t, "UploadFile", 1)
+			api.AssertCalled(t, "CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+				return post.RootId == "post-id" && len(post.FileIds) == 1 && post.FileIds[0] == "file-id" &&
+					post.Message == "The transcript of the meeting of Mon, May 6 2024 at 09:08 CEST is available."
+			}))
+			api.AssertCalled(t, "CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+				return post.RootId == "post-id" && len(post.FileIds) == 0
+			}))
+			api.AssertNumberOfCalls(t, "CreatePost", 2)
+			require.Equal(t, testCase.expectTranscripts, stored.Transcripts)
+			require.Equal(t, testCase.expectRecordings, stored.Recordings)
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+	msgraph "github.com/yaegashi/msgraph.go/beta"
+)
+
+// meetingPathPattern matches the path of a meeting of the registry.
+var meetingPathPattern = regexp.MustCompile(`^/api/v1/meetings/([0-9]+)$`)
+
+var errNotOrganizer = errors.New("only the organizer can change this meeting")
+
+// meetingUpdate is a change to a meeting. Empty fields are left unchanged.
+type meetingUpdate struct {
+	Topic   string
+	StartAt time.Time
+	EndAt   time.Time
+}
+
+// updateMeeting lets the organizer change the topic or times of a meeting, updating the online
+// meeting, the registry and the meeting post.
+func (p *Plugin) updateMeeting(userID string, meeting *Meeting, postID string, update meetingUpdate) error {
+	if meeting.OrganizerID != userID {
+		return errNotOrganizer
+	}
+
+	client, err := p.getMeetingOrganizerClient(meeting)
+	if err != nil {
+		return err
+	}
+
+	in := &msgraph.OnlineMeeting{}
+	if update.Topic != "" {
+		in.Subject = &update.Topic
+	}
+	if !update.StartAt.IsZero() || !update.EndAt.IsZero() {
+		start := time.UnixMilli(meeting.StartAt)
+		if !update.StartAt.IsZero() {
+			start = update.StartAt
+		}
+		end := time.UnixMilli(meeting.EndAt)
+		if !update.EndAt.IsZero() {
+			end = update.EndAt
+		}
+		if end.Before(start) {
+			return errors.New("the meeting must end after it starts")
+		}
+		in.StartDateTime = &start
+		in.EndDateTime = &end
+	}
+
+	if err = client.UpdateMeeting(meeting.OrganizerRemoteID, meeting.RemoteID, in); err != nil {
+		return err
+	}
+
+	if in.Subject != nil {
+		meeting.Topic = *in.Subject
+	}
+	if in.StartDateTime != nil {
+		meeting.StartAt = model.GetMillisForTime(*in.StartDateTime)
+		meeting.EndAt = model.GetMillisForTime(*in.EndDateTime)
+	}
+	if err = p.StoreMeeting(meeting); err != nil {
+		return err
+	}
+	if in.EndDateTime != nil {
+		p.refreshMeetingStatus(meeting)
+		p.rescheduleAttendanceReport(meeting)
+		p.rescheduleMeetingArtifacts(meeting)
+		p.resubscribeToMeeting(meeting)
+	}
+
+	p.updateMeetingPost(postID, func(post *model.Post) {
+		if in.Subject != nil {
+			post.AddProp("meeting_topic", meeting.Topic)
+		}
+		if !update.StartAt.IsZero() {
+			setMeetingSchedule(post, meeting, p.getUserLocation(userID))
+		} else if !update.EndAt.IsZero() {
+			post.AddProp("meeting_end_at", meeting.EndAt)
+		}
+	})
+
+	return nil
+}
+
+// parseMeetingUpdateArgs reads the flags of the update command. The start time is either a time
+// in the user's timezone or an offset from the current start, such as +30m, and the duration is
+// in minutes or a duration such as 1h30m.
+func parseMeetingUpdateArgs(args []string, meeting *Meeting, location *time.Location) (meetingUpdate, error) {
+	update := meetingUpdate{}
+	values := map[string]string{}
+	flag := ""
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--") {
+			flag = strings.TrimPrefix(arg, "--")
+			if flag != "topic" && flag != "start" && flag != "duration" {
+				return update, errors.Errorf("unknown option %s", arg)
+			}
+			if _, ok := values[flag]; ok {
+				return update, errors.Errorf("option %s is given twice", arg)
+			}
+			values[flag] = ""
+			continue
+		}
+		if flag == "" {
+			return update, errors.Errorf("unexpected %s, options start with --", arg)
+		}
+		values[flag] = strings.TrimSpace(values[flag] + " " + arg)
+	}
+
+	if len(values) == 0 {
+		return update, errors.New("nothing to update")
+	}
+
+	if topic, ok := values["topic"]; ok {
+		if topic == "" {
+			return update, errors.New("the topic cannot be empty")
+		}
+		update.Topic = topic
+	}
+
+	start := time.UnixMilli(meeting.StartAt)
+	if value, ok := values["start"]; ok {
+		if strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-") {
+			offset, err := time.ParseDuration(value)
+			if err != nil {
+				return update, errors.Errorf("invalid start offset %s", value)
+			}
+			start = start.Add(offset)
+		} else {
+			parsed, err := time.ParseInLocation(dialogTimeFormat, value, location)
+			if err != nil {
+				return update, errors.Errorf("invalid start time %s, use YYYY-MM-DD HH:MM", value)
+			}
+			start = parsed
+		}
+		update.StartAt = start
+		// Moving a meeting keeps its duration.
+		update.EndAt = start.Add(time.Duration(meeting.EndAt-meeting.StartAt) * time.Millisecond)
+	}
+
+	if value, ok := values["duration"]; ok {
+		duration, err := parseMeetingDuration(value)
+		if err != nil {
+			return update, err
+		}
+		update.EndAt = start.Add(duration)
+	}
+
+	return update, nil
+}
+
+func parseMeetingDuration(value string) (time.Duration, error) {
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		minutes, atoiErr := strconv.Atoi(value)
+		if atoiErr != nil {
+			return 0, errors.Errorf("invalid duration %s", value)
+		}
+		duration = time.Duration(minutes) * time.Minute
+	}
+	if duration <= 0 {
+		return 0, errors.New("the duration must be positive")
+	}
+	return duration, nil
+}
+
+type updateMeetingRequest struct {
+	Topic string `json:"topic"`
+	// StartAt is the new start time in milliseconds.
+	StartAt int64 `json:"start_at"`
+	// Duration is the new duration in minutes.
+	Duration int `json:"duration"`
+}
+
+// handleUpdateMeeting changes the topic or times of a meeting of the registry.
+func (p *Plugin) handleUpdateMeeting(w http.ResponseWriter, r *http.Request, meetingID int) {
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		p.API.LogError("handleUpdateMeeting, unauthorized user")
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req updateMeetingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		p.API.LogError("handleUpdateMeeting, failed to decode payload", "Error", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Duration < 0 {
+		http.Error(w, "the duration must be positive", http.StatusBadRequest)
+		return
+	}
+
+	meeting, err := p.GetMeeting(meetingID)
+	if err != nil {
+		p.API.LogError("handleUpdateMeeting, failed to get meeting", "MeetingID", meetingID, "Error", err.Error())
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	update := meetingUpdate{Topic: strings.TrimSpace(req.Topic)}
+	start := time.UnixMilli(meeting.StartAt)
+	if req.StartAt != 0 {
+		start = time.UnixMilli(req.StartAt)
+		update.StartAt = start
+		update.EndAt = start.Add(time.Duration(meeting.EndAt-meeting.StartAt) * time.Millisecond)
+	}
+	if req.Duration != 0 {
+		update.EndAt = start.Add(time.Duration(req.Duration) * time.Minute)
+	}
+	if update.Topic == "" && update.StartAt.IsZero() && update.EndAt.IsZero() {
+		http.Error(w, "nothing to update", http.StatusBadRequest)
+		return
+	}
+
+	if err = p.updateMeeting(userID, meeting, meeting.PostID, update); err != nil {
+		if err == errNotOrganizer {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		p.API.LogError("handleUpdateMeeting, failed to update meeting", "MeetingID", meetingID, "Error", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(meeting); err != nil {
+		p.API.LogWarn("failed to write response", "error", err.Error())
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestParseMeetingUpdateArgs(t *testing.T) {
+	start := time.Date(2024, time.March, 4, 10, 0, 0, 0, time.UTC)
+	meeting := &Meeting{
+		StartAt: start.UnixMilli(),
+		EndAt:   start.Add(time.Hour).UnixMilli(),
+	}
+
+	update, err := parseMeetingUpdateArgs([]string{"--topic", "Weekly", "sync", "--start", "+30m"}, meeting, time.UTC)
+	require.NoError(t, err)
+	require.Equal(t, "Weekly sync", update.Topic)
+	require.True(t, start.Add(30*time.Minute).Equal(update.StartAt))
+	require.True(t, start.Add(90*time.Minute).Equal(update.EndAt))
+
+	update, err = parseMeetingUpdateArgs([]string{"--start", "2024-03-05", "14:00", "--duration", "45"}, meeting, time.UTC)
+	require.NoError(t, err)
+	require.Empty(t, update.Topic)
+	require.Equal(t, time.Date(2024, time.March, 5, 14, 0, 0, 0, time.UTC), update.StartAt)
+	require.Equal(t, time.Date(2024, time.March, 5, 14, 45, 0, 0, time.UTC), update.EndAt)
+
+	update, err = parseMeetingUpdateArgs([]string{"--duration", "1h30m"}, meeting, time.UTC)
+	require.NoError(t, err)
+	require.True(t, update.StartAt.IsZero())
+	require.True(t, start.Add(90*time.Minute).Equal(update.EndAt))
+
+	for _, args := range [][]string{
+		{},
+		{"topic"},
+		{"--topic"},
+		{"--room", "A"},
+		{"--start", "tomorrow"},
+		{"--duration", "0"},
+		{"--topic", "a", "--topic", "b"},
+	} {
+		_, err = parseMeetingUpdateArgs(args, meeting, time.UTC)
+		require.Error(t, err, args)
+	}
+}
+
+func TestUpdateMeetingReschedulesFollowUps(t *testing.T) {
+	mockGraph(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPatch, r.Method)
+		require.Equal(t, "/beta/users/organizer-remote-id/onlineMeetings/meeting-remote-id", r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	start := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	meeting := &Meeting{
+		ID:                7,
+		RemoteID:          "meeting-remote-id",
+		PostID:            "post-id",
+		OrganizerID:       "organizer-id",
+		OrganizerRemoteID: "organizer-remote-id",
+		StartAt:           start.UnixMilli(),
+		EndAt:             start.Add(30 * time.Minute).UnixMilli(),
+	}
+	newEnd := start.Add(3 * time.Hour)
+
+	p := &Plugin{}
+	p.setConfiguration(&configuration{
+		OAuth2Authority:              "tenant-id",
+		OAuth2ClientID:               "client-id",
+		OAuth2ClientSecret:           "secret",
+		PostAttendanceReports:        true,
+		PostTranscriptsAndRecordings: true,
+	})
+	api := &plugintest.API{}
+	api.On("GetUser", "organizer-id").Return(&model.User{Id: "organizer-id"}, nil)
+	api.On("GetConfig").Return(&model.Config{
+		ServiceSettings: model.ServiceSettings{
+			SiteURL: model.NewString("https://example.com"),
+		},
+	})
+	data, err := (&UserInfo{
+		UserID:     "organizer-id",
+		RemoteID:   "organizer-remote-id",
+		OAuthToken: &oauth2.Token{AccessToken: "access-token", Expiry: time.Now().Add(time.Hour)},
+	}).EncryptedJSON(nil)
+	require.NoError(t, err)
+	api.On("KVGet", tokenKey+"organizer-id").Return(data, nil)
+	api.On("KVSet", getMeetingKey(7), mock.Anything).Return(nil)
+	api.On("KVGet", getMeetingStatusKey(7)).Return(nil, nil)
+	api.On("KVGet", getAttendanceReportKey(7)).Return([]byte{1}, nil)
+	api.On("KVGet", getMeetingArtifactsKey(7)).Return([]byte(`{"Transcripts":["transcript-id"]}`), nil)
+	expiresAround := func(retention time.Duration) interface{} {
+		return mock.MatchedBy(func(seconds int64) bool {
+			expected := time.Until(newEnd.Add(retention)).Seconds()
+			return float64(seconds) > expected-60 && float64(seconds) <= expected
+		})
+	}
+	api.On("KVSetWithExpiry", getAttendanceReportKey(7), []byte{1}, expiresAround(attendanceReportRetention)).Return(nil).Once()
+	api.On("KVSetWithExpiry", getMeetingArtifactsKey(7), mock.MatchedBy(func(data []byte) bool {
+		return strings.Contains(string(data), "transcript-id")
+	}), expiresAround(meetingArtifactsRetention)).Return(nil).Once()
+	api.On("GetPost", "post-id").Return(&model.Post{Id: "post-id"}, nil)
+	api.On("UpdatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{}, nil)
+	p.SetAPI(api)
+
+	require.NoError(t, p.updateMeeting("organizer-id", meeting, "post-id", meetingUpdate{EndAt: newEnd}))
+	require.Equal(t, newEnd.UnixMilli(), meeting.EndAt)
+	api.AssertExpectations(t)
+}
+
+func TestHandleUpdateMeetingNothingToUpdate(t *testing.T) {
+	p := &Plugin{}
+	api := &plugintest.API{}
+	data, err := json.Marshal(&Meeting{ID: 7, OrganizerID: "organizer-id", StartAt: 1, EndAt: 2})
+	require.NoError(t, err)
+	api.On("KVGet", getMeetingKey(7)).Return(data, nil)
+	p.SetAPI(api)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPatch, "/api/v1/meetings/7", strings.NewReader(`{"topic":"  "}`))
+	r.Header.Set("Mattermost-User-Id", "organizer-id")
+	p.handleUpdateMeeting(w, r, 7)
+
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Equal(t, "nothing to update\n", w.Body.String())
+}
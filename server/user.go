@@ -8,7 +8,11 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"io"
+	"net/http"
+	"strings"
+	"sync"
 
 	"github.com/pkg/errors"
 	msgraph "github.com/yaegashi/msgraph.go/beta"
@@ -28,6 +32,10 @@ type UserInfo struct {
 	RemoteID string
 	// Remote UPN
 	UPN string
+	// Remote tenant the user linked their account against
+	TenantID string `json:",omitempty"`
+	// Whether Microsoft rejected the stored token during the last health check
+	ConnectionBroken bool `json:",omitempty"`
 }
 
 func DecryptUserInfo(data, key []byte) (*UserInfo, error) {
@@ -79,6 +87,11 @@ func (i *UserInfo) EncryptedJSON(key []byte) ([]byte, error) {
 const (
 	tokenKey           = "token_"
 	tokenKeyByRemoteID = "tbyrid_"
+
+	kvListPageSize = 100
+
+	// userLookupBatchSize is the number of values MS Graph accepts in a single "in" filter.
+	userLookupBatchSize = 15
 )
 
 func (c *Client) GetMe() (*msgraph.User, error) {
@@ -98,32 +111,203 @@ func (c *Client) GetMe() (*msgraph.User, error) {
 	return graphUser, nil
 }
 
+// GetUserByEmail looks up a directory user whose user principal name or mail matches the
+// given email.
+func (c *Client) GetUserByEmail(email string) (*msgraph.User, error) {
+	ctx := context.Background()
+	escaped := strings.ReplaceAll(email, "'", "''")
+	req := c.builder.Users().Request()
+	req.Filter(fmt.Sprintf("userPrincipalName eq '%s' or mail eq '%s'", escaped, escaped))
+	users, err := req.Get(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot look up user")
+	}
+
+	if len(users) == 0 {
+		return nil, errors.Errorf("no user found with email %s", email)
+	}
+
+	return &users[0], nil
+}
+
+// GetUsersByEmails looks up the directory users matching the given emails in batches, returning
+// them keyed by the lowercased mail and user principal name they were found by.
+func (c *Client) GetUsersByEmails(emails []string) (map[string]*msgraph.User, error) {
+	ctx := context.Background()
+	found := map[string]*msgraph.User{}
+	for start := 0; start < len(emails); start += userLookupBatchSize {
+		end := min(start+userLookupBatchSize, len(emails))
+
+		quoted := make([]string, 0, end-start)
+		for _, email := range emails[start:end] {
+			quoted = append(quoted, fmt.Sprintf("'%s'", strings.ReplaceAll(email, "'", "''")))
+		}
+		values := strings.Join(quoted, ",")
+
+		req := c.builder.Users().Request()
+		req.Filter(fmt.Sprintf("mail in (%s) or userPrincipalName in (%s)", values, values))
+		users, err := req.Get(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot look up users")
+		}
+
+		for i := range users {
+			user := &users[i]
+			if user.Mail != nil {
+				found[strings.ToLower(*user.Mail)] = user
+			}
+			if user.UserPrincipalName != nil {
+				found[strings.ToLower(*user.UserPrincipalName)] = user
+			}
+		}
+	}
+
+	return found, nil
+}
+
 func (p *Plugin) StoreUserInfo(info *UserInfo) error {
 	key := []byte(p.getConfiguration().EncryptionKey)
 	data, err := info.EncryptedJSON(key)
 	if err != nil {
 		return err
 	}
+
+	previous, previousErr := p.GetUserInfo(info.UserID)
+
 	if appErr := p.API.KVSet(tokenKey+info.UserID, data); appErr != nil {
 		return appErr
 	}
 	if appErr := p.API.KVSet(tokenKeyByRemoteID+info.RemoteID, data); appErr != nil {
 		return appErr
 	}
+
+	// The user connected another Microsoft account, which no longer belongs to them.
+	if previousErr == nil && previous.RemoteID != "" && previous.RemoteID != info.RemoteID {
+		if linked, linkedErr := p.GetUserInfoByRemoteID(previous.RemoteID); linkedErr == nil && linked.UserID == info.UserID {
+			if appErr := p.API.KVDelete(tokenKeyByRemoteID + previous.RemoteID); appErr != nil {
+				return appErr
+			}
+		}
+	}
 	return nil
 }
 
+// compareAndStoreUserInfo stores the user info only if the stored value is still the one it was
+// read from, reporting whether it did, so a reconnect in the meantime is not overwritten.
+func (p *Plugin) compareAndStoreUserInfo(info *UserInfo, oldData []byte) (bool, error) {
+	key := []byte(p.getConfiguration().EncryptionKey)
+	data, err := info.EncryptedJSON(key)
+	if err != nil {
+		return false, err
+	}
+	stored, appErr := p.API.KVCompareAndSet(tokenKey+info.UserID, oldData, data)
+	if appErr != nil {
+		return false, appErr
+	}
+	if !stored {
+		return false, nil
+	}
+	if appErr = p.API.KVSet(tokenKeyByRemoteID+info.RemoteID, data); appErr != nil {
+		return false, appErr
+	}
+	return true, nil
+}
+
+// storingTokenSource stores the token of a user whenever it is refreshed, so the next client
+// built from the stored user info doesn't refresh it again.
+type storingTokenSource struct {
+	p      *Plugin
+	source oauth2.TokenSource
+
+	mu   sync.Mutex
+	info *UserInfo
+	data []byte
+}
+
+func (s *storingTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.source.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.data == nil || s.info.OAuthToken == nil || token.AccessToken == s.info.OAuthToken.AccessToken {
+		return token, nil
+	}
+
+	s.info.OAuthToken = token
+	stored, err := s.p.compareAndStoreUserInfo(s.info, s.data)
+	if err != nil {
+		s.p.API.LogWarn("failed to store refreshed token", "UserID", s.info.UserID, "error", err.Error())
+	} else if !stored {
+		s.p.API.LogDebug("refreshed token not stored as the user reconnected", "UserID", s.info.UserID)
+	}
+	// The stored value changed either way, later refreshes are not stored over it.
+	s.data = nil
+
+	return token, nil
+}
+
 func (p *Plugin) GetUserInfo(userID string) (*UserInfo, error) {
+	info, _, err := p.getStoredUserInfo(userID)
+	return info, err
+}
+
+// getStoredUserInfo returns the user info of a user together with the stored value it was
+// decoded from, to store it back with compareAndStoreUserInfo.
+func (p *Plugin) getStoredUserInfo(userID string) (*UserInfo, []byte, error) {
 	infoBytes, appErr := p.API.KVGet(tokenKey + userID)
 	if appErr != nil || infoBytes == nil {
-		return nil, errors.New("Your Mattermost account is not connected to any Microsoft Teams account") //nolint:golint
+		return nil, nil, errors.New("Your Mattermost account is not connected to any Microsoft Teams account") //nolint:golint
+	}
+
+	key := []byte(p.getConfiguration().EncryptionKey)
+	info, err := DecryptUserInfo(infoBytes, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return info, infoBytes, nil
+}
+
+// GetUserInfoByRemoteID returns the user info of whichever Mattermost user linked the given
+// Microsoft account.
+func (p *Plugin) GetUserInfoByRemoteID(remoteID string) (*UserInfo, error) {
+	infoBytes, appErr := p.API.KVGet(tokenKeyByRemoteID + remoteID)
+	if appErr != nil {
+		return nil, appErr
+	}
+	if infoBytes == nil {
+		return nil, errors.New("no Mattermost account is connected to this Microsoft account")
 	}
 
 	key := []byte(p.getConfiguration().EncryptionKey)
 	return DecryptUserInfo(infoBytes, key)
 }
 
+// getRemoteUserOwner returns the Mattermost user connected to the Microsoft account, if any. The
+// index by remote ID is checked against the user's own record, which is the one kept up to
+// date.
+func (p *Plugin) getRemoteUserOwner(remoteID string) string {
+	linked, err := p.GetUserInfoByRemoteID(remoteID)
+	if err != nil {
+		return ""
+	}
+
+	info, err := p.GetUserInfo(linked.UserID)
+	if err != nil || info.RemoteID != remoteID {
+		return ""
+	}
+	return info.UserID
+}
+
 func (p *Plugin) RemoveUser(userID string) error {
+	// The presence can no longer be read, so the status the sync set would stay forever.
+	if err := p.stopPresenceSync(userID); err != nil {
+		p.API.LogWarn("RemoveUser, failed to stop presence sync", "UserID", userID, "error", err.Error())
+	}
+
 	info, err := p.GetUserInfo(userID)
 	if err != nil {
 		return err
@@ -140,6 +324,56 @@ func (p *Plugin) RemoveUser(userID string) error {
 	return nil
 }
 
+// listConnectedUserIDs returns the Mattermost IDs of all users with a stored connection.
+func (p *Plugin) listConnectedUserIDs() ([]string, error) {
+	userIDs := []string{}
+	for page := 0; ; page++ {
+		keys, appErr := p.API.KVList(page, kvListPageSize)
+		if appErr != nil {
+			return nil, appErr
+		}
+
+		for _, key := range keys {
+			if strings.HasPrefix(key, tokenKey) {
+				userIDs = append(userIDs, strings.TrimPrefix(key, tokenKey))
+			}
+		}
+
+		if len(keys) < kvListPageSize {
+			return userIDs, nil
+		}
+	}
+}
+
+// removeDeactivatedUsers deletes the stored connections of users that were deactivated or
+// deleted in Mattermost, returning how many were removed.
+func (p *Plugin) removeDeactivatedUsers() (int, error) {
+	userIDs, err := p.listConnectedUserIDs()
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, userID := range userIDs {
+		user, appErr := p.API.GetUser(userID)
+		if appErr != nil && appErr.StatusCode != http.StatusNotFound {
+			p.API.LogWarn("removeDeactivatedUsers, failed to get user", "UserID", userID, "error", appErr.Error())
+			continue
+		}
+		if appErr == nil && user.DeleteAt == 0 {
+			continue
+		}
+
+		if err = p.RemoveUser(userID); err != nil {
+			p.API.LogWarn("removeDeactivatedUsers, failed to remove user", "UserID", userID, "error", err.Error())
+			continue
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
 func encrypt(key, data []byte) (string, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
@@ -219,15 +453,36 @@ func generateSecret() (string, error) {
 }
 
 func (p *Plugin) resetAllOAuthTokens() {
-	// At this time the only data the plugin stores in the KV store is the user
-	// OAuth2 tokens, and the temporary state to use during OAuth2
-	// authentication flow. Since a change in the encryption key invalidates all
-	// connections, we can safely remove all of plugin's data since it'll be
-	// irrelevant anyway.
+	// A change in the encryption key invalidates all connections, so the user
+	// OAuth2 tokens and the temporary state used during the OAuth2
+	// authentication flow are irrelevant and can be removed. Other data, like
+	// the meeting registry, is kept.
 	p.API.LogInfo("OAuth2 configuration changed. Resetting all users' tokens, everyone will need to reconnect to MS Teams")
-	appErr := p.API.KVDeleteAll()
-	if appErr != nil {
-		p.API.LogError("failed to reset users' OAuth2 tokens", "error", appErr.Error())
-		return
+
+	keys := []string{}
+	for page := 0; ; page++ {
+		pageKeys, appErr := p.API.KVList(page, kvListPageSize)
+		if appErr != nil {
+			p.API.LogError("failed to reset users' OAuth2 tokens", "error", appErr.Error())
+			return
+		}
+
+		for _, key := range pageKeys {
+			if strings.HasPrefix(key, tokenKey) ||
+				strings.HasPrefix(key, tokenKeyByRemoteID) ||
+				strings.HasPrefix(key, msteamsMeetingStateKeyPrefix) {
+				keys = append(keys, key)
+			}
+		}
+
+		if len(pageKeys) < kvListPageSize {
+			break
+		}
+	}
+
+	for _, key := range keys {
+		if appErr := p.API.KVDelete(key); appErr != nil {
+			p.API.LogError("failed to reset user's OAuth2 token", "key", key, "error", appErr.Error())
+		}
 	}
 }
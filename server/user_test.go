@@ -1,9 +1,15 @@
 package main
 
 import (
+	"fmt"
+	"net/http"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/oauth2"
 )
@@ -38,3 +44,195 @@ func TestEncryptUserData(t *testing.T) {
 	require.NoError(t, err)
 	require.EqualValues(t, &expected, decrypted)
 }
+
+func TestGetUserByEmail(t *testing.T) {
+	for _, testCase := range []struct {
+		description  string
+		email        string
+		body         string
+		expectFilter string
+		expectError  bool
+	}{
+		{
+			description:  "found",
+			email:        "alice@example.com",
+			body:         `{"value":[{"id":"remote-id","mail":"alice@example.com"}]}`,
+			expectFilter: "userPrincipalName eq 'alice@example.com' or mail eq 'alice@example.com'",
+		},
+		{
+			description:  "quote escaped",
+			email:        "o'brien@example.com",
+			body:         `{"value":[{"id":"remote-id","mail":"o'brien@example.com"}]}`,
+			expectFilter: "userPrincipalName eq 'o''brien@example.com' or mail eq 'o''brien@example.com'",
+		},
+		{
+			description:  "not found",
+			email:        "alice@example.com",
+			body:         `{"value":[]}`,
+			expectFilter: "userPrincipalName eq 'alice@example.com' or mail eq 'alice@example.com'",
+			expectError:  true,
+		},
+	} {
+		t.Run(testCase.description, func(t *testing.T) {
+			mockGraph(t, func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, testCase.expectFilter, r.URL.Query().Get("$filter"))
+				writeGraphJSON(w, http.StatusOK, testCase.body)
+			})
+
+			p := &Plugin{}
+			p.setConfiguration(&configuration{})
+			client := p.newClient(&http.Client{})
+
+			remoteUser, err := client.GetUserByEmail(testCase.email)
+			if testCase.expectError {
+				require.EqualError(t, err, "no user found with email "+testCase.email)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, "remote-id", *remoteUser.ID)
+		})
+	}
+}
+
+func TestStoreUserInfoRelink(t *testing.T) {
+	previous, err := (&UserInfo{UserID: "alice", RemoteID: "old-remote-id"}).EncryptedJSON(nil)
+	require.NoError(t, err)
+
+	p := &Plugin{}
+	p.setConfiguration(&configuration{})
+	api := &plugintest.API{}
+	api.On("KVGet", tokenKey+"alice").Return(previous, nil)
+	api.On("KVGet", tokenKeyByRemoteID+"old-remote-id").Return(previous, nil)
+	api.On("KVSet", tokenKey+"alice", mock.Anything).Return(nil)
+	api.On("KVSet", tokenKeyByRemoteID+"new-remote-id", mock.Anything).Return(nil)
+	api.On("KVDelete", tokenKeyByRemoteID+"old-remote-id").Return(nil)
+	p.SetAPI(api)
+
+	require.NoError(t, p.StoreUserInfo(&UserInfo{UserID: "alice", RemoteID: "new-remote-id"}))
+	api.AssertExpectations(t)
+}
+
+func TestGetRemoteUserOwner(t *testing.T) {
+	alice, err := (&UserInfo{UserID: "alice", RemoteID: "remote-id"}).EncryptedJSON(nil)
+	require.NoError(t, err)
+	relinked, err := (&UserInfo{UserID: "bob", RemoteID: "other-remote-id"}).EncryptedJSON(nil)
+	require.NoError(t, err)
+	stale, err := (&UserInfo{UserID: "bob", RemoteID: "stale-remote-id"}).EncryptedJSON(nil)
+	require.NoError(t, err)
+
+	p := &Plugin{}
+	p.setConfiguration(&configuration{})
+	api := &plugintest.API{}
+	api.On("KVGet", tokenKeyByRemoteID+"remote-id").Return(alice, nil)
+	api.On("KVGet", tokenKey+"alice").Return(alice, nil)
+	// Bob connected another account since, leaving the index of the first one behind.
+	api.On("KVGet", tokenKeyByRemoteID+"stale-remote-id").Return(stale, nil)
+	api.On("KVGet", tokenKey+"bob").Return(relinked, nil)
+	api.On("KVGet", tokenKeyByRemoteID+"unknown-remote-id").Return(nil, nil)
+	p.SetAPI(api)
+
+	require.Equal(t, "alice", p.getRemoteUserOwner("remote-id"))
+	require.Equal(t, "", p.getRemoteUserOwner("stale-remote-id"))
+	require.Equal(t, "", p.getRemoteUserOwner("unknown-remote-id"))
+}
+
+func TestRemoveDeactivatedUsers(t *testing.T) {
+	p := &Plugin{}
+	p.setConfiguration(&configuration{})
+	api := &plugintest.API{}
+	p.SetAPI(api)
+
+	api.On("KVList", 0, kvListPageSize).Return([]string{
+		tokenKey + "active",
+		tokenKey + "deactivated",
+		tokenKey + "deleted",
+		tokenKeyByRemoteID + "remote-active",
+		getOAuthUserStateKey("active"),
+	}, nil)
+	api.On("GetUser", "active").Return(&model.User{Id: "active"}, nil)
+	api.On("GetUser", "deactivated").Return(&model.User{Id: "deactivated", DeleteAt: 1}, nil)
+	api.On("GetUser", "deleted").Return(nil, model.NewAppError("GetUser", "not_found", nil, "", http.StatusNotFound))
+
+	for _, userID := range []string{"deactivated", "deleted"} {
+		data, err := (&UserInfo{UserID: userID, RemoteID: "remote-" + userID}).EncryptedJSON(nil)
+		require.NoError(t, err)
+		api.On("KVGet", tokenKey+userID).Return(data, nil)
+		api.On("KVGet", getPresenceSyncKey(userID)).Return(nil, nil)
+		api.On("KVDelete", tokenKey+userID).Return(nil).Once()
+		api.On("KVDelete", tokenKeyByRemoteID+"remote-"+userID).Return(nil).Once()
+	}
+
+	removed, err := p.removeDeactivatedUsers()
+	require.NoError(t, err)
+	require.Equal(t, 2, removed)
+	api.AssertExpectations(t)
+}
+
+func TestGetUsersByEmails(t *testing.T) {
+	emails := []string{}
+	for i := 0; i < 20; i++ {
+		emails = append(emails, fmt.Sprintf("user%d@example.com", i))
+	}
+
+	filters := []string{}
+	mockGraph(t, func(w http.ResponseWriter, r *http.Request) {
+		filter := r.URL.Query().Get("$filter")
+		filters = append(filters, filter)
+
+		// Only some of the users are in the directory, found either by mail or by UPN.
+		users := []string{}
+		for i, email := range emails {
+			if !strings.Contains(filter, "'"+email+"'") || i%3 != 0 {
+				continue
+			}
+			if i%2 == 0 {
+				users = append(users, fmt.Sprintf(`{"id":"remote-%d","mail":"%s","userPrincipalName":"upn%d@corp.example.com"}`, i, strings.ToUpper(email), i))
+			} else {
+				users = append(users, fmt.Sprintf(`{"id":"remote-%d","userPrincipalName":"%s"}`, i, email))
+			}
+		}
+		writeGraphJSON(w, http.StatusOK, `{"value":[`+strings.Join(users, ",")+`]}`)
+	})
+
+	p := &Plugin{}
+	p.setConfiguration(&configuration{})
+	client := p.newClient(&http.Client{})
+
+	found, err := client.GetUsersByEmails(emails)
+	require.NoError(t, err)
+
+	require.Len(t, filters, 2)
+	// Each email is matched against both the mail and the user principal name.
+	require.Equal(t, 2*userLookupBatchSize, strings.Count(filters[0], "@example.com'"))
+	require.Equal(t, 2*(len(emails)-userLookupBatchSize), strings.Count(filters[1], "@example.com'"))
+	require.Contains(t, filters[0], "'user14@example.com'")
+	require.NotContains(t, filters[0], "'user15@example.com'")
+	require.Contains(t, filters[1], "'user15@example.com'")
+	require.Contains(t, filters[1], "'user19@example.com'")
+
+	for i, email := range emails {
+		remoteUser, ok := found[email]
+		if i%3 != 0 {
+			require.False(t, ok, email)
+			continue
+		}
+		require.True(t, ok, email)
+		require.Equal(t, fmt.Sprintf("remote-%d", i), *remoteUser.ID)
+		if i%2 == 0 {
+			require.Equal(t, remoteUser, found[fmt.Sprintf("upn%d@corp.example.com", i)])
+		}
+	}
+}
+
+func TestGetUsersByEmailsError(t *testing.T) {
+	mockGraph(t, func(w http.ResponseWriter, r *http.Request) {
+		writeGraphJSON(w, http.StatusForbidden, `{"error":{"code":"Authorization_RequestDenied","message":"Insufficient privileges"}}`)
+	})
+
+	p := &Plugin{}
+	p.setConfiguration(&configuration{})
+	client := p.newClient(&http.Client{})
+
+	_, err := client.GetUsersByEmails([]string{"user@example.com"})
+	require.Error(t, err)
+}
@@ -3,12 +3,27 @@ package main
 import (
 	"fmt"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/pkg/errors"
 )
 
+// teamsMeetingHosts are the hosts Microsoft Teams meeting join links are served from across the
+// Microsoft clouds.
+var teamsMeetingHosts = map[string]bool{
+	"teams.microsoft.com":      true,
+	"teams.live.com":           true,
+	"gov.teams.microsoft.us":   true,
+	"dod.teams.microsoft.us":   true,
+	"teams.microsoftonline.cn": true,
+}
+
+// maxPostTopicLength is the number of characters of a post kept when using it as a meeting topic.
+const maxPostTopicLength = 100
+
 func (p *Plugin) getSiteURL() (string, error) {
 	siteURLRef := p.API.GetConfig().ServiceSettings.SiteURL
 	if siteURLRef == nil || *siteURLRef == "" {
@@ -18,7 +33,9 @@ func (p *Plugin) getSiteURL() (string, error) {
 	return *siteURLRef, nil
 }
 
-func (p *Plugin) checkPreviousMessages(channelID string) (recentMeeting bool, meetingLink string, creatorName string, provider string, err *model.AppError) {
+// checkPreviousMessages looks for a meeting posted recently in the channel, or in the given
+// thread if rootID is set.
+func (p *Plugin) checkPreviousMessages(channelID string, rootID string) (recentMeeting bool, meetingLink string, creatorName string, provider string, err *model.AppError) {
 	var meetingTimeWindow int64 = 30 // 30 seconds
 
 	postList, appErr := p.API.GetPostsSince(channelID, (time.Now().Unix()-meetingTimeWindow)*1000)
@@ -27,6 +44,10 @@ func (p *Plugin) checkPreviousMessages(channelID string) (recentMeeting bool, me
 	}
 
 	for _, post := range postList.ToSlice() {
+		if post.RootId != rootID && post.Id != rootID {
+			continue
+		}
+
 		meetingProvider := getString("meeting_provider", post.Props)
 		if meetingProvider == "" {
 			continue
@@ -55,12 +76,84 @@ func getString(key string, props model.StringInterface) string {
 	return value
 }
 
-func (p *Plugin) getPluginOauthURL() (string, error) {
+// getInt reads a number from the props, which are floats once stored.
+func getInt(key string, props model.StringInterface) int {
+	switch value := props[key].(type) {
+	case int:
+		return value
+	case float64:
+		return int(value)
+	case string:
+		number, _ := strconv.Atoi(value)
+		return number
+	}
+	return 0
+}
+
+// getStrings reads a list of strings from the props, which are lists of any type once stored.
+func getStrings(key string, props model.StringInterface) []string {
+	values := []string{}
+	switch list := props[key].(type) {
+	case []string:
+		values = append(values, list...)
+	case []interface{}:
+		for _, item := range list {
+			if value, ok := item.(string); ok {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+// getPostTopic derives a meeting topic from the first line of a post's message.
+func getPostTopic(message string) string {
+	topic := strings.TrimSpace(message)
+	if index := strings.IndexByte(topic, '\n'); index != -1 {
+		topic = strings.TrimSpace(topic[:index])
+	}
+
+	runes := []rune(topic)
+	if len(runes) > maxPostTopicLength {
+		topic = strings.TrimSpace(string(runes[:maxPostTopicLength])) + "…"
+	}
+	return topic
+}
+
+// isTeamsMeetingURL reports whether the link is a Microsoft Teams meeting join link.
+func isTeamsMeetingURL(link string) bool {
+	u, err := url.Parse(link)
+	if err != nil || u.Scheme != "https" {
+		return false
+	}
+
+	return teamsMeetingHosts[strings.ToLower(u.Host)] && strings.HasPrefix(u.Path, "/l/meetup-join/")
+}
+
+// getEmailDomain returns the lowercased domain part of an email or user principal name.
+func getEmailDomain(email string) string {
+	index := strings.LastIndex(email, "@")
+	if index == -1 {
+		return ""
+	}
+	return strings.ToLower(email[index+1:])
+}
+
+func (p *Plugin) getPluginURL() (string, error) {
 	siteURL, err := p.getSiteURL()
 	if err != nil {
 		return "", err
 	}
 
 	pluginID := url.PathEscape(manifest.Id)
-	return fmt.Sprintf("%s/plugins/%s/oauth2", siteURL, pluginID), nil
+	return fmt.Sprintf("%s/plugins/%s", siteURL, pluginID), nil
+}
+
+func (p *Plugin) getPluginOauthURL() (string, error) {
+	pluginURL, err := p.getPluginURL()
+	if err != nil {
+		return "", err
+	}
+
+	return pluginURL + "/oauth2", nil
 }
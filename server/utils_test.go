@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckPreviousMessages(t *testing.T) {
+	postList := model.NewPostList()
+	postList.AddPost(&model.Post{
+		Id:     "threadMeeting",
+		RootId: "root",
+		Props: model.StringInterface{
+			"meeting_provider":         msteamsProviderName,
+			"meeting_link":             "https://teams.microsoft.com/l/meetup-join/thread",
+			"meeting_creator_username": "alice",
+		},
+	})
+	postList.AddOrder("threadMeeting")
+	postList.AddPost(&model.Post{Id: "message", Message: "hello"})
+	postList.AddOrder("message")
+
+	p := &Plugin{}
+	api := &plugintest.API{}
+	api.On("GetPostsSince", "channel", mock.AnythingOfType("int64")).Return(postList, nil)
+	p.SetAPI(api)
+
+	recentMeeting, link, creator, provider, appErr := p.checkPreviousMessages("channel", "root")
+	require.Nil(t, appErr)
+	require.True(t, recentMeeting)
+	require.Equal(t, "https://teams.microsoft.com/l/meetup-join/thread", link)
+	require.Equal(t, "alice", creator)
+	require.Equal(t, msteamsProviderName, provider)
+
+	recentMeeting, _, _, _, appErr = p.checkPreviousMessages("channel", "")
+	require.Nil(t, appErr)
+	require.False(t, recentMeeting)
+
+	recentMeeting, _, _, _, appErr = p.checkPreviousMessages("channel", "otherRoot")
+	require.Nil(t, appErr)
+	require.False(t, recentMeeting)
+}
+
+func TestGetPostTopic(t *testing.T) {
+	require.Equal(t, "Can we discuss the release?", getPostTopic("  Can we discuss the release?\nDetails below  "))
+	require.Equal(t, "", getPostTopic(""))
+
+	long := strings.Repeat("a", maxPostTopicLength+10)
+	require.Equal(t, strings.Repeat("a", maxPostTopicLength)+"…", getPostTopic(long))
+}
+
+func TestIsTeamsMeetingURL(t *testing.T) {
+	require.True(t, isTeamsMeetingURL("https://teams.microsoft.com/l/meetup-join/19%3ameeting_abc%40thread.v2/0?context=%7b%7d"))
+	require.True(t, isTeamsMeetingURL("https://gov.teams.microsoft.us/l/meetup-join/19%3ameeting_abc%40thread.v2/0"))
+	require.False(t, isTeamsMeetingURL("http://teams.microsoft.com/l/meetup-join/19%3ameeting_abc%40thread.v2/0"))
+	require.False(t, isTeamsMeetingURL("https://teams.microsoft.com/l/channel/19%3aabc"))
+	require.False(t, isTeamsMeetingURL("https://example.com/l/meetup-join/19%3ameeting_abc"))
+}
@@ -0,0 +1,630 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1" //nolint:gosec
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+	msgraph "github.com/yaegashi/msgraph.go/beta"
+)
+
+const (
+	notificationsPath = "/api/v1/notifications"
+
+	webhookClientStateKey  = "webhookclientstate"
+	webhookEncryptionKey   = "webhookencryption"
+	meetingSubscriptionKey = "meetingsubscription_"
+	subscriptionMeetingKey = "subscriptionmeeting_"
+
+	meetingSubscriptionJobKey   = "meeting_subscriptions"
+	meetingSubscriptionInterval = 1 * time.Hour
+
+	// meetingSubscriptionLifetime is how long subscriptions last before being renewed, within the
+	// three days Microsoft allows for online meetings.
+	meetingSubscriptionLifetime = 48 * time.Hour
+	// meetingSubscriptionRenewal is how long before it expires a subscription is renewed.
+	meetingSubscriptionRenewal = 12 * time.Hour
+	// meetingSubscriptionGrace is how long after its scheduled end a meeting is still followed,
+	// as meetings often run late.
+	meetingSubscriptionGrace = 12 * time.Hour
+
+	// webhookEncryptionValidity is how long the certificate Microsoft encrypts the resource data
+	// of notifications with is valid.
+	webhookEncryptionValidity = 5 * 365 * 24 * time.Hour
+
+	meetingCallStarted = "started"
+	meetingCallEnded   = "ended"
+
+	lifecycleReauthorizationRequired = "reauthorizationRequired"
+	lifecycleSubscriptionRemoved     = "subscriptionRemoved"
+)
+
+// meetingSubscription is the Graph change notification subscription following a meeting.
+type meetingSubscription struct {
+	ID        string
+	ExpiresAt int64
+}
+
+// changeNotification is a Microsoft Graph change notification.
+type changeNotification struct {
+	SubscriptionID   string            `json:"subscriptionId"`
+	ClientState      string            `json:"clientState"`
+	ChangeType       string            `json:"changeType"`
+	LifecycleEvent   string            `json:"lifecycleEvent"`
+	Resource         string            `json:"resource"`
+	EncryptedContent *encryptedContent `json:"encryptedContent"`
+}
+
+// encryptedContent is the resource data of a change notification, encrypted with the
+// certificate given when subscribing.
+type encryptedContent struct {
+	Data                    string `json:"data"`
+	DataSignature           string `json:"dataSignature"`
+	DataKey                 string `json:"dataKey"`
+	EncryptionCertificateID string `json:"encryptionCertificateId"`
+}
+
+// meetingCallEvent is the resource data of a notification about the call of an online meeting.
+type meetingCallEvent struct {
+	EventType     string     `json:"eventType"`
+	EventDateTime *time.Time `json:"eventDateTime"`
+	State         string     `json:"state"`
+}
+
+// webhookEncryption is the certificate Microsoft encrypts the resource data of notifications
+// with, and its private key.
+type webhookEncryption struct {
+	ID          string
+	Certificate []byte
+	PrivateKey  []byte
+}
+
+// CreateMeetingSubscription subscribes to the call events of the online meeting with the join URL.
+// Call events are only sent with their resource data, encrypted with the given certificate.
+func (c *Client) CreateMeetingSubscription(joinURL, notificationURL, clientState string, encryption *webhookEncryption, expiresAt time.Time) (*msgraph.Subscription, error) {
+	resource := fmt.Sprintf("communications/onlineMeetings/?$filter=JoinWebUrl eq '%s'", strings.ReplaceAll(joinURL, "'", "''"))
+	changeType := "updated"
+	includeResourceData := true
+	certificate := base64.StdEncoding.EncodeToString(encryption.Certificate)
+	in := &msgraph.Subscription{
+		Resource:                 &resource,
+		ChangeType:               &changeType,
+		ClientState:              &clientState,
+		NotificationURL:          &notificationURL,
+		LifecycleNotificationURL: &notificationURL,
+		ExpirationDateTime:       &expiresAt,
+		IncludeResourceData:      &includeResourceData,
+		EncryptionCertificate:    &certificate,
+		EncryptionCertificateID:  &encryption.ID,
+	}
+
+	subscription, err := c.builder.Subscriptions().Request().Add(context.Background(), in)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create subscription")
+	}
+	return subscription, nil
+}
+
+// RenewSubscription extends a subscription until the given time.
+func (c *Client) RenewSubscription(subscriptionID string, expiresAt time.Time) error {
+	err := c.builder.Subscriptions().ID(subscriptionID).Request().Update(context.Background(), &msgraph.Subscription{
+		ExpirationDateTime: &expiresAt,
+	})
+	if err != nil {
+		return errors.Wrap(err, "cannot renew subscription")
+	}
+	return nil
+}
+
+// DeleteSubscription stops a subscription.
+func (c *Client) DeleteSubscription(subscriptionID string) error {
+	if err := c.builder.Subscriptions().ID(subscriptionID).Request().Delete(context.Background()); err != nil {
+		return errors.Wrap(err, "cannot delete subscription")
+	}
+	return nil
+}
+
+// getWebhookClientState returns the secret Microsoft sends back with every change notification,
+// generating it the first time.
+func (p *Plugin) getWebhookClientState() (string, error) {
+	for {
+		clientState, appErr := p.API.KVGet(webhookClientStateKey)
+		if appErr != nil {
+			return "", appErr
+		}
+		if clientState != nil {
+			return string(clientState), nil
+		}
+
+		secret, err := generateSecret()
+		if err != nil {
+			return "", err
+		}
+
+		ok, appErr := p.API.KVCompareAndSet(webhookClientStateKey, nil, []byte(secret))
+		if appErr != nil {
+			return "", appErr
+		}
+		if ok {
+			return secret, nil
+		}
+	}
+}
+
+// getWebhookEncryption returns the certificate Microsoft encrypts the resource data of
+// notifications with, generating it the first time.
+func (p *Plugin) getWebhookEncryption() (*webhookEncryption, error) {
+	for {
+		data, appErr := p.API.KVGet(webhookEncryptionKey)
+		if appErr != nil {
+			return nil, appErr
+		}
+		if data != nil {
+			encryption := &webhookEncryption{}
+			if err := json.Unmarshal(data, encryption); err != nil {
+				return nil, err
+			}
+			return encryption, nil
+		}
+
+		encryption, err := newWebhookEncryption()
+		if err != nil {
+			return nil, err
+		}
+		if data, err = json.Marshal(encryption); err != nil {
+			return nil, err
+		}
+
+		ok, appErr := p.API.KVCompareAndSet(webhookEncryptionKey, nil, data)
+		if appErr != nil {
+			return nil, appErr
+		}
+		if ok {
+			return encryption, nil
+		}
+	}
+}
+
+// newWebhookEncryption generates a self-signed certificate to encrypt the resource data of
+// notifications with.
+func newWebhookEncryption() (*webhookEncryption, error) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate private key")
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate serial number")
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      pkix.Name{CommonName: manifest.Id},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(webhookEncryptionValidity),
+		KeyUsage:     x509.KeyUsageKeyEncipherment,
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create certificate")
+	}
+
+	return &webhookEncryption{
+		ID:          model.NewId(),
+		Certificate: certificate,
+		PrivateKey:  x509.MarshalPKCS1PrivateKey(privateKey),
+	}, nil
+}
+
+// decrypt checks and decrypts the resource data of a notification. The data key is encrypted
+// with the certificate, and the data with the data key using AES-CBC, signed with HMAC-SHA256.
+func (e *webhookEncryption) decrypt(content *encryptedContent) ([]byte, error) {
+	if content.EncryptionCertificateID != e.ID {
+		return nil, errors.New("unknown encryption certificate")
+	}
+
+	privateKey, err := x509.ParsePKCS1PrivateKey(e.PrivateKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid private key")
+	}
+
+	encryptedKey, err := base64.StdEncoding.DecodeString(content.DataKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid data key")
+	}
+	key, err := rsa.DecryptOAEP(sha1.New(), rand.Reader, privateKey, encryptedKey, nil) //nolint:gosec
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt data key")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(content.Data)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid data")
+	}
+	signature, err := base64.StdEncoding.DecodeString(content.DataSignature)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid data signature")
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	if !hmac.Equal(mac.Sum(nil), signature) {
+		return nil, errors.New("data signature does not match")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid data key")
+	}
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, errors.New("invalid data length")
+	}
+	decrypted := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, key[:aes.BlockSize]).CryptBlocks(decrypted, data)
+	return unpad(decrypted)
+}
+
+func getMeetingSubscriptionKey(meetingID int) string {
+	return meetingSubscriptionKey + strconv.Itoa(meetingID)
+}
+
+// subscribeToMeeting follows the meeting to show on its post when it starts and ends.
+func (p *Plugin) subscribeToMeeting(meeting *Meeting) {
+	if !p.getConfiguration().EnableMeetingNotifications || meeting.ID == 0 || meeting.PostID == "" {
+		return
+	}
+
+	if err := p.createMeetingSubscription(meeting); err != nil {
+		p.API.LogWarn("subscribeToMeeting, failed to subscribe to meeting", "MeetingID", meeting.ID, "error", err.Error())
+	}
+}
+
+// resubscribeToMeeting follows the meeting again after its end changed, in case its subscription
+// was already stopped.
+func (p *Plugin) resubscribeToMeeting(meeting *Meeting) {
+	if !p.getConfiguration().EnableMeetingNotifications || meeting.ID == 0 || meeting.PostID == "" ||
+		!time.UnixMilli(meeting.EndAt).After(time.Now()) {
+		return
+	}
+
+	data, appErr := p.API.KVGet(getMeetingSubscriptionKey(meeting.ID))
+	if appErr != nil {
+		p.API.LogWarn("resubscribeToMeeting, failed to get subscription", "MeetingID", meeting.ID, "error", appErr.Error())
+		return
+	}
+	if data != nil {
+		// The subscription is renewed until the grace period after the new end.
+		return
+	}
+
+	p.subscribeToMeeting(meeting)
+}
+
+func (p *Plugin) createMeetingSubscription(meeting *Meeting) error {
+	pluginURL, err := p.getPluginURL()
+	if err != nil {
+		return err
+	}
+
+	clientState, err := p.getWebhookClientState()
+	if err != nil {
+		return err
+	}
+
+	encryption, err := p.getWebhookEncryption()
+	if err != nil {
+		return err
+	}
+
+	client := p.getAppClient()
+	subscription, err := client.CreateMeetingSubscription(meeting.JoinURL, pluginURL+notificationsPath, clientState, encryption, time.Now().Add(meetingSubscriptionLifetime))
+	if err != nil {
+		return err
+	}
+
+	stored := &meetingSubscription{
+		ID:        *subscription.ID,
+		ExpiresAt: model.GetMillisForTime(*subscription.ExpirationDateTime),
+	}
+	if err = p.storeMeetingSubscription(meeting.ID, stored); err != nil {
+		return err
+	}
+
+	if appErr := p.API.KVSet(subscriptionMeetingKey+stored.ID, []byte(strconv.Itoa(meeting.ID))); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+func (p *Plugin) storeMeetingSubscription(meetingID int, subscription *meetingSubscription) error {
+	data, err := json.Marshal(subscription)
+	if err != nil {
+		return err
+	}
+
+	if appErr := p.API.KVSet(getMeetingSubscriptionKey(meetingID), data); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+// renewMeetingSubscriptions renews the subscriptions about to expire, and stops following the
+// meetings that ended.
+func (p *Plugin) renewMeetingSubscriptions() {
+	meetingIDs, err := p.listMeetingIDs(meetingSubscriptionKey)
+	if err != nil {
+		p.API.LogError("renewMeetingSubscriptions, failed to list subscriptions", "error", err.Error())
+		return
+	}
+
+	client := p.getAppClient()
+	for _, meetingID := range meetingIDs {
+		if renewErr := p.renewMeetingSubscription(client, meetingID); renewErr != nil {
+			p.API.LogWarn("renewMeetingSubscriptions, failed to renew subscription", "MeetingID", meetingID, "error", renewErr.Error())
+		}
+	}
+}
+
+func (p *Plugin) renewMeetingSubscription(client *Client, meetingID int) error {
+	data, appErr := p.API.KVGet(getMeetingSubscriptionKey(meetingID))
+	if appErr != nil {
+		return appErr
+	}
+	if data == nil {
+		return nil
+	}
+
+	subscription := &meetingSubscription{}
+	if err := json.Unmarshal(data, subscription); err != nil {
+		return err
+	}
+
+	meeting, err := p.GetMeeting(meetingID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	expiresAt := time.UnixMilli(subscription.ExpiresAt)
+	if !p.getConfiguration().EnableMeetingNotifications || now.After(time.UnixMilli(meeting.EndAt).Add(meetingSubscriptionGrace)) {
+		return p.deleteMeetingSubscription(client, meetingID, subscription, expiresAt.After(now))
+	}
+
+	if expiresAt.Sub(now) > meetingSubscriptionRenewal {
+		return nil
+	}
+
+	if !expiresAt.After(now) {
+		// Expired subscriptions can't be renewed, follow the meeting again.
+		if err = p.deleteMeetingSubscription(client, meetingID, subscription, false); err != nil {
+			return err
+		}
+		return p.createMeetingSubscription(meeting)
+	}
+
+	subscription.ExpiresAt = model.GetMillisForTime(now.Add(meetingSubscriptionLifetime))
+	if err = client.RenewSubscription(subscription.ID, time.UnixMilli(subscription.ExpiresAt)); err != nil {
+		return err
+	}
+	return p.storeMeetingSubscription(meetingID, subscription)
+}
+
+func (p *Plugin) deleteMeetingSubscription(client *Client, meetingID int, subscription *meetingSubscription, active bool) error {
+	if active {
+		if err := client.DeleteSubscription(subscription.ID); err != nil {
+			return err
+		}
+	}
+
+	if appErr := p.API.KVDelete(subscriptionMeetingKey + subscription.ID); appErr != nil {
+		return appErr
+	}
+	if appErr := p.API.KVDelete(getMeetingSubscriptionKey(meetingID)); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+// handleNotifications receives the change notifications of the meetings followed.
+func (p *Plugin) handleNotifications(w http.ResponseWriter, r *http.Request) {
+	if !p.getConfiguration().EnableMeetingNotifications {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	// Microsoft validates the notification URL when subscribing by expecting the token back.
+	if validationToken := r.URL.Query().Get("validationToken"); validationToken != "" {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(validationToken))
+		return
+	}
+
+	notifications := struct {
+		Value []changeNotification `json:"value"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&notifications); err != nil {
+		p.API.LogError("handleNotifications, failed to decode payload", "Error", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	clientState, err := p.getWebhookClientState()
+	if err != nil {
+		p.API.LogError("handleNotifications, failed to get client state", "Error", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for _, notification := range notifications.Value {
+		if subtle.ConstantTimeCompare([]byte(notification.ClientState), []byte(clientState)) != 1 {
+			p.API.LogWarn("handleNotifications, invalid client state", "SubscriptionID", notification.SubscriptionID)
+			continue
+		}
+
+		if notification.LifecycleEvent != "" {
+			err = p.handleLifecycleNotification(notification)
+		} else {
+			err = p.handleMeetingNotification(notification)
+		}
+		if err != nil {
+			p.API.LogWarn("handleNotifications, failed to handle notification", "SubscriptionID", notification.SubscriptionID, "error", err.Error())
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleLifecycleNotification keeps a subscription alive when Microsoft asks for it to be
+// reauthorized, and follows the meeting again when Microsoft removed it.
+func (p *Plugin) handleLifecycleNotification(notification changeNotification) error {
+	meetingID, err := p.getSubscriptionMeetingID(notification.SubscriptionID)
+	if err != nil {
+		return err
+	}
+
+	data, appErr := p.API.KVGet(getMeetingSubscriptionKey(meetingID))
+	if appErr != nil {
+		return appErr
+	}
+	if data == nil {
+		return nil
+	}
+
+	subscription := &meetingSubscription{}
+	if err = json.Unmarshal(data, subscription); err != nil {
+		return err
+	}
+	if subscription.ID != notification.SubscriptionID {
+		return nil
+	}
+
+	client := p.getAppClient()
+	switch notification.LifecycleEvent {
+	case lifecycleReauthorizationRequired:
+		subscription.ExpiresAt = model.GetMillisForTime(time.Now().Add(meetingSubscriptionLifetime))
+		if err = client.RenewSubscription(subscription.ID, time.UnixMilli(subscription.ExpiresAt)); err != nil {
+			return err
+		}
+		return p.storeMeetingSubscription(meetingID, subscription)
+
+	case lifecycleSubscriptionRemoved:
+		if err = p.deleteMeetingSubscription(client, meetingID, subscription, false); err != nil {
+			return err
+		}
+
+		meeting, getErr := p.GetMeeting(meetingID)
+		if getErr != nil {
+			return getErr
+		}
+		p.resubscribeToMeeting(meeting)
+	}
+
+	return nil
+}
+
+// getSubscriptionMeetingID returns the meeting a subscription follows.
+func (p *Plugin) getSubscriptionMeetingID(subscriptionID string) (int, error) {
+	data, appErr := p.API.KVGet(subscriptionMeetingKey + subscriptionID)
+	if appErr != nil {
+		return 0, appErr
+	}
+	if data == nil {
+		return 0, errors.New("unknown subscription")
+	}
+
+	return strconv.Atoi(string(data))
+}
+
+func (p *Plugin) handleMeetingNotification(notification changeNotification) error {
+	if notification.EncryptedContent == nil {
+		return errors.New("notification has no resource data")
+	}
+
+	encryption, err := p.getWebhookEncryption()
+	if err != nil {
+		return err
+	}
+
+	data, err := encryption.decrypt(notification.EncryptedContent)
+	if err != nil {
+		return err
+	}
+
+	callEvent := &meetingCallEvent{}
+	if err = json.Unmarshal(data, callEvent); err != nil {
+		return err
+	}
+
+	event := getMeetingCallEvent(callEvent)
+	if event == "" {
+		return nil
+	}
+
+	meetingID, err := p.getSubscriptionMeetingID(notification.SubscriptionID)
+	if err != nil {
+		return err
+	}
+
+	meeting, err := p.GetMeeting(meetingID)
+	if err != nil {
+		return err
+	}
+
+	switch event {
+	case meetingCallStarted:
+		p.updateMeetingPost(meeting.PostID, func(post *model.Post) {
+			post.Message = fmt.Sprintf("Meeting started at [this link](%s).", meeting.JoinURL)
+			post.AddProp("meeting_status", postTypeStarted)
+			post.AddProp("meeting_started_at", model.GetMillis())
+			// The actions were removed if the call ended before, and apply again while it runs.
+			if actions := p.getMeetingCardActions(meeting); actions != nil {
+				post.AddProp("attachments", actions)
+			}
+		})
+		p.startMeetingStatus(meeting)
+
+	case meetingCallEnded:
+		meeting.EndAt = model.GetMillis()
+		if callEvent.EventDateTime != nil {
+			meeting.EndAt = model.GetMillisForTime(*callEvent.EventDateTime)
+		}
+		if err = p.StoreMeeting(meeting); err != nil {
+			return err
+		}
+		p.markMeetingEnded(meeting, meeting.PostID)
+	}
+
+	return nil
+}
+
+// getMeetingCallEvent reads whether the call of a meeting started or ended from a call event.
+// Other events, such as roster updates, are ignored.
+func getMeetingCallEvent(callEvent *meetingCallEvent) string {
+	switch callEvent.EventType {
+	case "Microsoft.Communication.CallStarted":
+		return meetingCallStarted
+	case "Microsoft.Communication.CallEnded":
+		return meetingCallEnded
+	}
+	return ""
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1" //nolint:gosec
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// encryptNotificationContent encrypts the resource data of a notification the way Microsoft
+// does for the certificate given when subscribing.
+func encryptNotificationContent(t *testing.T, encryption *webhookEncryption, data string) *encryptedContent {
+	t.Helper()
+
+	certificate, err := x509.ParseCertificate(encryption.Certificate)
+	require.NoError(t, err)
+
+	key := make([]byte, 32)
+	_, err = rand.Read(key)
+	require.NoError(t, err)
+	encryptedKey, err := rsa.EncryptOAEP(sha1.New(), rand.Reader, certificate.PublicKey.(*rsa.PublicKey), key, nil) //nolint:gosec
+	require.NoError(t, err)
+
+	block, err := aes.NewCipher(key)
+	require.NoError(t, err)
+	padded := pad([]byte(data))
+	encrypted := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, key[:aes.BlockSize]).CryptBlocks(encrypted, padded)
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(encrypted)
+
+	return &encryptedContent{
+		Data:                    base64.StdEncoding.EncodeToString(encrypted),
+		DataSignature:           base64.StdEncoding.EncodeToString(mac.Sum(nil)),
+		DataKey:                 base64.StdEncoding.EncodeToString(encryptedKey),
+		EncryptionCertificateID: encryption.ID,
+	}
+}
+
+func TestGetMeetingCallEvent(t *testing.T) {
+	require.Equal(t, meetingCallStarted, getMeetingCallEvent(&meetingCallEvent{EventType: "Microsoft.Communication.CallStarted"}))
+	require.Equal(t, meetingCallEnded, getMeetingCallEvent(&meetingCallEvent{EventType: "Microsoft.Communication.CallEnded"}))
+	require.Equal(t, "", getMeetingCallEvent(&meetingCallEvent{EventType: "Microsoft.Communication.CallRosterUpdate"}))
+	require.Equal(t, "", getMeetingCallEvent(&meetingCallEvent{EventType: "callStarted"}))
+	require.Equal(t, "", getMeetingCallEvent(&meetingCallEvent{}))
+}
+
+func TestWebhookEncryptionDecrypt(t *testing.T) {
+	encryption, err := newWebhookEncryption()
+	require.NoError(t, err)
+
+	content := encryptNotificationContent(t, encryption, `{"eventType":"Microsoft.Communication.CallStarted"}`)
+	data, err := encryption.decrypt(content)
+	require.NoError(t, err)
+	require.Equal(t, `{"eventType":"Microsoft.Communication.CallStarted"}`, string(data))
+
+	unknown := *content
+	unknown.EncryptionCertificateID = "other-certificate"
+	_, err = encryption.decrypt(&unknown)
+	require.Error(t, err)
+
+	tampered := *content
+	tampered.DataSignature = base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+	_, err = encryption.decrypt(&tampered)
+	require.Error(t, err)
+
+	other, err := newWebhookEncryption()
+	require.NoError(t, err)
+	other.ID = encryption.ID
+	_, err = other.decrypt(content)
+	require.Error(t, err)
+}
+
+func TestCreateMeetingSubscription(t *testing.T) {
+	encryption, err := newWebhookEncryption()
+	require.NoError(t, err)
+
+	mockGraph(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "/beta/subscriptions", r.URL.Path)
+
+		body, readErr := io.ReadAll(r.Body)
+		require.NoError(t, readErr)
+		subscription := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal(body, &subscription))
+		require.Equal(t, true, subscription["includeResourceData"])
+		require.Equal(t, base64.StdEncoding.EncodeToString(encryption.Certificate), subscription["encryptionCertificate"])
+		require.Equal(t, encryption.ID, subscription["encryptionCertificateId"])
+		require.Equal(t, "https://example.com/notifications", subscription["notificationUrl"])
+		require.Equal(t, "https://example.com/notifications", subscription["lifecycleNotificationUrl"])
+
+		writeGraphJSON(w, http.StatusCreated, `{"id":"subscription-id","expirationDateTime":"2024-03-04T11:00:00Z"}`)
+	})
+
+	p := &Plugin{}
+	p.setConfiguration(&configuration{
+		OAuth2Authority:    "tenant-id",
+		OAuth2ClientID:     "client-id",
+		OAuth2ClientSecret: "secret",
+	})
+	client := p.getAppClient()
+
+	subscription, err := client.CreateMeetingSubscription("https://teams.microsoft.com/l/meetup-join/1", "https://example.com/notifications", "client-state", encryption, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, "subscription-id", *subscription.ID)
+}
+
+func TestHandleNotificationsCallEvents(t *testing.T) {
+	encryption, err := newWebhookEncryption()
+	require.NoError(t, err)
+	encryptionData, err := json.Marshal(encryption)
+	require.NoError(t, err)
+
+	endedAt := time.Date(2024, time.March, 4, 10, 47, 12, 0, time.UTC)
+	for name, test := range map[string]struct {
+		CallEvent     string
+		ExpectMessage string
+		ExpectStatus  string
+		ExpectEndAt   int64
+		ExpectActions bool
+	}{
+		"call started": {
+			CallEvent:     `{"@odata.type":"#microsoft.graph.onlineMeeting","@odata.id":"communications/onlineMeetings/meeting-id","id":"meeting-id","eventType":"Microsoft.Communication.CallStarted","eventDateTime":"2024-03-04T10:02:31.0000000Z","state":"active"}`,
+			ExpectMessage: "Meeting started at [this link](https://teams.microsoft.com/l/meetup-join/1).",
+			ExpectStatus:  postTypeStarted,
+			ExpectActions: true,
+		},
+		"call ended": {
+			CallEvent:     `{"@odata.type":"#microsoft.graph.onlineMeeting","@odata.id":"communications/onlineMeetings/meeting-id","id":"meeting-id","eventType":"Microsoft.Communication.CallEnded","eventDateTime":"2024-03-04T10:47:12.0000000Z","state":"inactive"}`,
+			ExpectMessage: "Meeting ended.",
+			ExpectStatus:  postTypeEnded,
+			ExpectEndAt:   endedAt.UnixMilli(),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			meeting := &Meeting{
+				ID:      7,
+				JoinURL: "https://teams.microsoft.com/l/meetup-join/1",
+				PostID:  "post-id",
+				StartAt: endedAt.Add(-time.Hour).UnixMilli(),
+				EndAt:   endedAt.Add(time.Hour).UnixMilli(),
+			}
+			meetingData, err := json.Marshal(meeting)
+			require.NoError(t, err)
+
+			api := &plugintest.API{}
+			api.On("KVGet", webhookClientStateKey).Return([]byte("client-state"), nil)
+			api.On("KVGet", webhookEncryptionKey).Return(encryptionData, nil)
+			api.On("KVGet", subscriptionMeetingKey+"subscription-id").Return([]byte("7"), nil)
+			api.On("KVGet", getMeetingKey(7)).Return(meetingData, nil)
+			api.On("KVGet", getMeetingStatusKey(7)).Return(nil, nil)
+			api.On("KVGet", getAttendanceReportKey(7)).Return(nil, nil)
+			api.On("KVSet", getMeetingKey(7), mock.Anything).Return(nil)
+			api.On("GetConfig").Return(&model.Config{
+				ServiceSettings: model.ServiceSettings{
+					SiteURL: model.NewString("https://example.com"),
+				},
+			})
+			// A previous call of the meeting ended, removing the actions of the card.
+			api.On("GetPost", "post-id").Return(&model.Post{Id: "post-id", Message: "Meeting ended."}, nil)
+			api.On("UpdatePost", mock.Anything).Return(nil, nil)
+			api.On("LogWarn", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return()
+			p := &Plugin{}
+			p.SetAPI(api)
+			p.setConfiguration(&configuration{OAuth2Authority: "tenant-id", EnableMeetingNotifications: true})
+
+			// The sample notification of the Microsoft Graph documentation for meeting call events.
+			notification := map[string]interface{}{
+				"subscriptionId":                 "subscription-id",
+				"clientState":                    "client-state",
+				"changeType":                     "updated",
+				"resource":                       "communications/onlineMeetings/?$filter=JoinWebUrl eq 'https://teams.microsoft.com/l/meetup-join/1'",
+				"subscriptionExpirationDateTime": "2024-03-04T11:00:00.0000000Z",
+				"resourceData": map[string]interface{}{
+					"@odata.id":   "communications/onlineMeetings/?$filter=JoinWebUrl eq 'https://teams.microsoft.com/l/meetup-join/1'",
+					"@odata.type": "#microsoft.graph.onlineMeeting",
+					"id":          "communications/onlineMeetings/?$filter=JoinWebUrl eq 'https://teams.microsoft.com/l/meetup-join/1'",
+				},
+				"encryptedContent": encryptNotificationContent(t, encryption, test.CallEvent),
+				"tenantId":         "tenant-id",
+			}
+			body, err := json.Marshal(map[string]interface{}{"value": []interface{}{notification}})
+			require.NoError(t, err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, notificationsPath, strings.NewReader(string(body)))
+			p.handleNotifications(w, r)
+
+			require.Equal(t, http.StatusAccepted, w.Code)
+			api.AssertNotCalled(t, "LogWarn", "handleNotifications, failed to handle notification", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+			api.AssertCalled(t, "UpdatePost", mock.MatchedBy(func(post *model.Post) bool {
+				return post.Message == test.ExpectMessage && post.GetProp("meeting_status") == test.ExpectStatus &&
+					(post.GetProp("attachments") != nil) == test.ExpectActions
+			}))
+			if test.ExpectEndAt != 0 {
+				api.AssertCalled(t, "KVSet", getMeetingKey(7), mock.MatchedBy(func(data []byte) bool {
+					stored := &Meeting{}
+					return json.Unmarshal(data, stored) == nil && stored.EndAt == test.ExpectEndAt
+				}))
+			} else {
+				api.AssertNotCalled(t, "KVSet", getMeetingKey(7), mock.Anything)
+			}
+		})
+	}
+}
+
+func TestHandleNotificationsLifecycle(t *testing.T) {
+	mockGraph(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPatch, r.Method)
+		require.Equal(t, "/beta/subscriptions/subscription-id", r.URL.Path)
+		writeGraphJSON(w, http.StatusOK, `{"id":"subscription-id"}`)
+	})
+
+	api := &plugintest.API{}
+	api.On("KVGet", webhookClientStateKey).Return([]byte("client-state"), nil)
+	api.On("KVGet", subscriptionMeetingKey+"subscription-id").Return([]byte("7"), nil)
+	api.On("KVGet", getMeetingSubscriptionKey(7)).Return([]byte(`{"ID":"subscription-id","ExpiresAt":1}`), nil)
+	api.On("KVSet", getMeetingSubscriptionKey(7), mock.Anything).Return(nil)
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.setConfiguration(&configuration{
+		OAuth2Authority:            "tenant-id",
+		OAuth2ClientID:             "client-id",
+		OAuth2ClientSecret:         "secret",
+		EnableMeetingNotifications: true,
+	})
+
+	body := fmt.Sprintf(`{"value":[{"subscriptionId":"subscription-id","clientState":"client-state","lifecycleEvent":%q,"resource":"communications/onlineMeetings"}]}`, lifecycleReauthorizationRequired)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, notificationsPath, strings.NewReader(body))
+	p.handleNotifications(w, r)
+
+	require.Equal(t, http.StatusAccepted, w.Code)
+	api.AssertCalled(t, "KVSet", getMeetingSubscriptionKey(7), mock.MatchedBy(func(data []byte) bool {
+		subscription := &meetingSubscription{}
+		return json.Unmarshal(data, subscription) == nil && subscription.ExpiresAt > model.GetMillis()
+	}))
+}
+
+func TestHandleNotificationsValidation(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		p := &Plugin{}
+		p.setConfiguration(&configuration{EnableMeetingNotifications: enabled})
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, notificationsPath+"?validationToken=token%20value", nil)
+
+		p.handleNotifications(w, r)
+
+		if !enabled {
+			require.Equal(t, http.StatusNotFound, w.Code)
+			require.NotContains(t, w.Body.String(), "token value")
+			continue
+		}
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "text/plain", w.Header().Get("Content-Type"))
+		require.Equal(t, "token value", w.Body.String())
+	}
+}
@@ -3,62 +3,97 @@
 
 import {Dispatch} from 'redux';
 
-import {PostTypes} from 'mattermost-redux/action_types';
+import {IntegrationTypes, PostTypes} from 'mattermost-redux/action_types';
 import {GetStateFunc} from 'mattermost-redux/types/actions';
 
 import Client from '../client';
 
-export function startMeeting(channelId: string, force = false, topic: string) {
+export function startMeeting(channelId: string, force = false, topic: string, rootId = '') {
     return async (dispatch: Dispatch, getState: GetStateFunc) => {
         try {
-            const startFunction = force ? Client.forceStartMeeting : Client.startMeeting;
-            const meetingURL = await startFunction(channelId, true, topic);
+            const meetingURL = await Client.startMeeting(channelId, true, topic, 0, force, rootId);
             if (meetingURL) {
                 window.open(meetingURL);
             }
 
             return {data: true};
         } catch (error) {
-            let m : string;
-            if (error.message && error.message[0] === '{') {
-                const e = JSON.parse(error.message);
+            return postStartMeetingError(dispatch, getState, channelId, error);
+        }
+    };
+}
 
-                // Error is from MS API
-                if (e?.error?.message) {
-                    m = '\nMSTMeeting error: ' + e.error.message;
-                } else {
-                    m = e;
-                }
-            } else {
-                m = error.message;
+export function startMeetingForPost(postId: string, force = false) {
+    return async (dispatch: Dispatch, getState: GetStateFunc) => {
+        try {
+            const meetingURL = await Client.startMeetingForPost(postId, force);
+            if (meetingURL) {
+                window.open(meetingURL);
             }
 
-            const post = {
-                id: 'mstMeetingsPlugin' + Date.now(),
-                create_at: Date.now(),
-                update_at: 0,
-                edit_at: 0,
-                delete_at: 0,
-                is_pinned: false,
-                user_id: getState().entities.users.currentUserId,
-                channel_id: channelId,
-                root_id: '',
-                parent_id: '',
-                original_id: '',
-                message: m,
-                type: 'system_ephemeral',
-                props: {},
-                hashtags: '',
-                pending_post_id: '',
-            };
+            return {data: true};
+        } catch (error) {
+            const channelId = getState().entities.posts.posts[postId]?.channel_id || '';
+            return postStartMeetingError(dispatch, getState, channelId, error);
+        }
+    };
+}
 
+export function openNewMeetingDialog(channelId: string) {
+    return async (dispatch: Dispatch, getState: GetStateFunc) => {
+        try {
+            const dialog = await Client.getNewMeetingDialog(channelId);
             dispatch({
-                type: PostTypes.RECEIVED_NEW_POST,
-                data: post,
-                channelId,
+                type: IntegrationTypes.RECEIVED_DIALOG,
+                data: dialog,
             });
 
-            return {error};
+            return {data: true};
+        } catch (error) {
+            return postStartMeetingError(dispatch, getState, channelId, error);
+        }
+    };
+}
+
+function postStartMeetingError(dispatch: Dispatch, getState: GetStateFunc, channelId: string, error: Error) {
+    let m : string;
+    if (error.message && error.message[0] === '{') {
+        const e = JSON.parse(error.message);
+
+        // Error is from MS API
+        if (e?.error?.message) {
+            m = '\nMSTMeeting error: ' + e.error.message;
+        } else {
+            m = e;
         }
+    } else {
+        m = error.message;
+    }
+
+    const post = {
+        id: 'mstMeetingsPlugin' + Date.now(),
+        create_at: Date.now(),
+        update_at: 0,
+        edit_at: 0,
+        delete_at: 0,
+        is_pinned: false,
+        user_id: getState().entities.users.currentUserId,
+        channel_id: channelId,
+        root_id: '',
+        parent_id: '',
+        original_id: '',
+        message: m,
+        type: 'system_ephemeral',
+        props: {},
+        hashtags: '',
+        pending_post_id: '',
     };
+
+    dispatch({
+        type: PostTypes.RECEIVED_NEW_POST,
+        data: post,
+        channelId,
+    });
+
+    return {error};
 }
@@ -13,14 +13,18 @@ export default class Client {
         this.url = url + '/plugins/' + id;
     }
 
-    startMeeting = async (channelId: string, personal = true, topic: string, meetingId = 0, force = false) => {
-        const res = await doPost(`${this.url}/api/v1/meetings${force ? '?force=true' : ''}`, {channel_id: channelId, personal, topic, meeting_id: meetingId});
+    startMeeting = async (channelId: string, personal = true, topic: string, meetingId = 0, force = false, rootId = '') => {
+        const res = await doPost(`${this.url}/api/v1/meetings${force ? '?force=true' : ''}`, {channel_id: channelId, root_id: rootId, personal, topic, meeting_id: meetingId});
         return res.meeting_url;
     }
 
-    forceStartMeeting = async (channelId: string, personal = true, topic: string, meetingId = 0) => {
-        const meetingUrl = await this.startMeeting(channelId, personal, topic, meetingId, true);
-        return meetingUrl;
+    startMeetingForPost = async (postId: string, force = false) => {
+        const res = await doPost(`${this.url}/api/v1/meetings/post${force ? '?force=true' : ''}`, {post_id: postId});
+        return res.meeting_url;
+    }
+
+    getNewMeetingDialog = async (channelId: string, rootId = '') => {
+        return doPost(`${this.url}/api/v1/meetings/dialog`, {channel_id: channelId, root_id: rootId});
     }
 }
 
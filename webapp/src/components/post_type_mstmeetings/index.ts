@@ -4,6 +4,7 @@
 import {connect} from 'react-redux';
 import {ActionCreatorsMapObject, bindActionCreators, Dispatch} from 'redux';
 
+import {doPostAction} from 'mattermost-redux/actions/posts';
 import {getBool} from 'mattermost-redux/selectors/entities/preferences';
 import {getCurrentChannelId} from 'mattermost-redux/selectors/entities/common';
 import {ActionResult} from 'mattermost-redux/types/actions';
@@ -11,7 +12,7 @@ import {Post} from 'mattermost-redux/types/posts';
 import {GlobalState} from 'mattermost-redux/types/store';
 import {Theme} from 'mattermost-redux/types/preferences';
 
-import {startMeeting} from '../../actions';
+import {startMeeting, startMeetingForPost} from '../../actions';
 
 import PostTypeMSTMeetings from './post_type_mstmeetings';
 
@@ -24,7 +25,9 @@ type OwnProps = {
 }
 
 type Actions = {
-    startMeeting: (channelID: string, force: boolean, topic: string) => ActionResult;
+    startMeeting: (channelID: string, force: boolean, topic: string, rootID?: string) => ActionResult;
+    startMeetingForPost: (postId: string, force: boolean) => ActionResult;
+    doPostAction: (postId: string, actionId: string) => ActionResult;
 }
 
 function mapStateToProps(state: GlobalState, ownProps: OwnProps) {
@@ -41,6 +44,8 @@ function mapDispatchToProps(dispatch: Dispatch) {
     return {
         actions: bindActionCreators<ActionCreatorsMapObject, Actions>({
             startMeeting,
+            startMeetingForPost,
+            doPostAction,
         }, dispatch),
     };
 }
@@ -20,10 +20,17 @@ type Props = {
     currentChannelId: string;
     fromBot: boolean;
     actions: {
-        startMeeting: (channelID: string, force: boolean, topic: string) => ActionResult;
+        startMeeting: (channelID: string, force: boolean, topic: string, rootID?: string) => ActionResult;
+        startMeetingForPost: (postId: string, force: boolean) => ActionResult;
+        doPostAction: (postId: string, actionId: string) => ActionResult;
     };
 }
 
+type PostAction = {
+    id: string;
+    name: string;
+}
+
 export default function PostTypeMSTMeetings(props: Props) {
     const style = getStyle(props.theme);
     const post = props.post;
@@ -34,7 +41,13 @@ export default function PostTypeMSTMeetings(props: Props) {
     const handleForceStart = async () => {
         if (!creatingMeeting) {
             setCreatingMeeting(true);
-            await props.actions.startMeeting(props.currentChannelId, true, postProps.meeting_topic);
+
+            // Meetings started about a post keep inviting its author when forced.
+            if (postProps.meeting_post_id) {
+                await props.actions.startMeetingForPost(postProps.meeting_post_id, true);
+            } else {
+                await props.actions.startMeeting(props.currentChannelId, true, postProps.meeting_topic, post.root_id);
+            }
             setCreatingMeeting(false);
         }
     };
@@ -47,6 +60,9 @@ export default function PostTypeMSTMeetings(props: Props) {
         if (props.fromBot) {
             preText = `${props.creatorName} has started a meeting`;
         }
+        if (postProps.meeting_id) {
+            subtitle = `Meeting #${postProps.meeting_id}`;
+        }
         content = (
             <a
                 className='btn btn-lg btn-primary'
@@ -61,6 +77,30 @@ export default function PostTypeMSTMeetings(props: Props) {
                 {'JOIN MEETING'}
             </a>
         );
+
+        const actions: PostAction[] = postProps.attachments?.[0]?.actions || [];
+        if (actions.length) {
+            content = (
+                <div>
+                    {content}
+                    <div>
+                        {actions.map((action) => (
+                            <button
+                                key={action.id}
+                                className='btn btn-sm btn-tertiary'
+                                style={style.action}
+                                onClick={() => props.actions.doPostAction(post.id, action.id)}
+                            >
+                                {action.name}
+                            </button>
+                        ))}
+                    </div>
+                </div>
+            );
+        }
+    } else if (postProps.meeting_status === 'ENDED') {
+        preText = `${props.creatorName} has ended the meeting`;
+        subtitle = 'This meeting has ended.';
     } else if (postProps.meeting_status === 'RECENTLY_CREATED') {
         preText = `${props.creatorName} already created a MS Teams Meeting recently`;
 
@@ -151,6 +191,10 @@ const getStyle = makeStyleFromTheme((theme) => {
             borderRadius: '4px',
             color: theme.buttonColor,
         },
+        action: {
+            marginTop: '8px',
+            marginRight: '8px',
+        },
         buttonIcon: {
             paddingRight: '8px',
             fill: theme.buttonColor,
@@ -11,7 +11,7 @@ import {getConfig} from 'mattermost-redux/selectors/entities/general';
 import {id as pluginId} from './manifest';
 import Icon from './components/icon';
 import PostTypeMSTMeetings from './components/post_type_mstmeetings';
-import {startMeeting} from './actions';
+import {openNewMeetingDialog, startMeeting, startMeetingForPost} from './actions';
 import Client from './client';
 // eslint-disable-next-line import/no-unresolved
 import {PluginRegistry} from './types/mattermost-webapp';
@@ -39,6 +39,16 @@ class Plugin {
             registry.registerAppBarComponent(iconURL, action, helpText);
         }
 
+        // Channel header menu
+        registry.registerChannelHeaderMenuAction('New MS Teams meeting...', async (channelId: string) => {
+            await openNewMeetingDialog(channelId)(store.dispatch, store.getState);
+        });
+
+        // Post dot menu
+        registry.registerPostDropdownMenuAction('Start MS Teams meeting about this post', async (postId: string) => {
+            await startMeetingForPost(postId)(store.dispatch, store.getState);
+        });
+
         registry.registerPostTypeComponent('custom_mstmeetings', PostTypeMSTMeetings);
         Client.setServerRoute(getServerRoute(store.getState()));
     }
@@ -2,6 +2,8 @@ import {Channel, ChannelMembership} from 'mattermost-redux/types/channels';
 
 export interface PluginRegistry {
     registerChannelHeaderButtonAction(icon: React.ReactNode, callback: (channel: Channel) => void, text: string)
+    registerChannelHeaderMenuAction(text: React.ReactNode, action: (channelId: string) => void)
     registerPostTypeComponent(typeName: string, component: React.ElementType)
+    registerPostDropdownMenuAction(text: React.ReactNode, action: (postId: string) => void, filter?: (postId: string) => boolean)
     registerAppBarComponent(iconUrl: string, action: (channel: Channel, channelMember: ChannelMembership) => void, tooltipText: React.ReactNode)
 }